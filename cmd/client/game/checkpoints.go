@@ -0,0 +1,66 @@
+package game
+
+import (
+	"time"
+
+	"fyp/common/ctypes"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// checkpointNotice is how long the player is told that they reached a checkpoint for.
+const checkpointNotice = 2 * time.Second
+
+/*
+syncCheckpoint takes the local player's checkpoint from the server, which decides who has
+reached which checkpoint. Checkpoints are forgotten when the next match counts down, so the
+server sending none is taken as the player not having one.
+*/
+func (g *Game) syncCheckpoint(authoritative ctypes.Player) {
+	reached := authoritative.Checkpoint != nil &&
+		(g.localPlayer.Checkpoint == nil || *g.localPlayer.Checkpoint != *authoritative.Checkpoint)
+
+	if reached {
+		g.checkpointNoticeUntil = time.Now().Add(checkpointNotice)
+	}
+
+	g.localPlayer.Checkpoint = authoritative.Checkpoint
+}
+
+// respawnPoint returns where the local player is put back when they can't move, see ctypes.Player.RespawnPoint.
+func (g *Game) respawnPoint() ctypes.Position {
+	x, y := g.currentMap.GetSpawnPoint()
+
+	return g.localPlayer.RespawnPoint(ctypes.NewPosition(x, y))
+}
+
+// isHidden reports whether the tile at the given position is left out when the map is drawn.
+func (g *Game) isHidden(position ctypes.Position) bool {
+	return g.isCollected(position) || g.isCheckpoint(position)
+}
+
+// isCheckpoint reports whether the local player's checkpoint is at the given position.
+func (g *Game) isCheckpoint(position ctypes.Position) bool {
+	return g.localPlayer.Checkpoint != nil && *g.localPlayer.Checkpoint == position
+}
+
+/*
+drawCheckpoint draws the local player's checkpoint as active, in place of the inactive
+tile that the map has there. Only the player that reached a checkpoint sees it activated.
+*/
+func (g *Game) drawCheckpoint(screen *ebiten.Image) {
+	if g.localPlayer.Checkpoint == nil {
+		return
+	}
+
+	g.tiles.Checkpoint.DrawActive(screen, g.localPlayer.Checkpoint.X, g.localPlayer.Checkpoint.Y)
+}
+
+// checkpointLines says that the local player has just reached a checkpoint.
+func (g *Game) checkpointLines() []string {
+	if time.Now().Before(g.checkpointNoticeUntil) {
+		return []string{"Checkpoint reached!"}
+	}
+
+	return nil
+}
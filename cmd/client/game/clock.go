@@ -0,0 +1,238 @@
+package game
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"fyp/common/ctypes/state"
+)
+
+const (
+	// How often the clock is resynchronised with the server, once the first samples have
+	// been taken.
+	timeSyncInterval = time.Second
+
+	// The first few samples are taken more often, so that a usable estimate is available
+	// soon after connecting.
+	timeSyncInitialInterval = 100 * time.Millisecond
+	timeSyncInitialSamples  = 8
+
+	// The most samples used for the estimate. Older samples are dropped.
+	maxClockSamples = 32
+
+	// The largest drift that is believed, as a rate. Crystal oscillators are usually
+	// well within this, so anything larger is more likely to be noise.
+	maxClockDrift = 1e-3
+)
+
+type clockSample struct {
+	at     time.Time
+	offset time.Duration
+	rtt    time.Duration
+}
+
+/*
+serverClock estimates the server's clock from NTP-style exchanges over the TCP
+connection. For each exchange, where t0 is when the client sent the request, t1 is when
+the server received it, t2 is when the server replied, and t3 is when the client received
+the reply:
+
+	offset = ((t1 - t0) + (t2 - t3)) / 2
+	rtt    = (t3 - t0) - (t2 - t1)
+
+Samples with a round trip much longer than the quickest one are likely to have been
+queued on one leg of the trip only, so are left out of the estimate. The drift between
+the two clocks is estimated with a least-squares fit of the remaining offsets against the
+local time that they were taken at.
+*/
+type serverClock struct {
+	mutex   sync.RWMutex
+	samples []clockSample
+	offset  time.Duration
+	base    time.Time
+	drift   float64
+	rtt     time.Duration
+	synced  bool
+}
+
+// addSample records the timestamps of a single exchange, and updates the estimate.
+func (c *serverClock) addSample(clientSent, serverReceived, serverSent, clientReceived time.Time) {
+	sample := clockSample{
+		at:     clientReceived,
+		offset: (serverReceived.Sub(clientSent) + serverSent.Sub(clientReceived)) / 2,
+		rtt:    clientReceived.Sub(clientSent) - serverSent.Sub(serverReceived),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > maxClockSamples {
+		c.samples = c.samples[len(c.samples)-maxClockSamples:]
+	}
+
+	c.rtt = sample.rtt
+	c.estimate()
+	c.synced = true
+}
+
+func (c *serverClock) estimate() {
+	quickest := c.samples[0].rtt
+	for _, sample := range c.samples {
+		quickest = min(quickest, sample.rtt)
+	}
+
+	threshold := quickest*3/2 + time.Millisecond
+	used := make([]clockSample, 0, len(c.samples))
+
+	for _, sample := range c.samples {
+		if sample.rtt <= threshold {
+			used = append(used, sample)
+		}
+	}
+
+	latest := used[len(used)-1]
+	if len(used) < 2 {
+		c.offset = latest.offset
+		c.base = latest.at
+		c.drift = 0
+
+		return
+	}
+
+	var meanX, meanY float64
+	for _, sample := range used {
+		meanX += sample.at.Sub(used[0].at).Seconds()
+		meanY += sample.offset.Seconds()
+	}
+
+	meanX /= float64(len(used))
+	meanY /= float64(len(used))
+
+	var sxx, sxy float64
+	for _, sample := range used {
+		dx := sample.at.Sub(used[0].at).Seconds() - meanX
+		sxx += dx * dx
+		sxy += dx * (sample.offset.Seconds() - meanY)
+	}
+
+	drift := 0.0
+	if sxx > 0 {
+		drift = max(-maxClockDrift, min(maxClockDrift, sxy/sxx))
+	}
+
+	latestX := latest.at.Sub(used[0].at).Seconds()
+
+	c.drift = drift
+	c.base = latest.at
+	c.offset = time.Duration((meanY + drift*(latestX-meanX)) * float64(time.Second))
+}
+
+/*
+ToServerTime converts a local time into the server's time. Until the first sample has
+been taken, the local time is returned as-is.
+*/
+func (c *serverClock) ToServerTime(local time.Time) time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if !c.synced {
+		return local
+	}
+
+	elapsed := local.Sub(c.base).Seconds()
+
+	return local.Add(c.offset + time.Duration(c.drift*elapsed*float64(time.Second)))
+}
+
+// ServerTime returns the current time on the server's clock.
+func (c *serverClock) ServerTime() time.Time {
+	return c.ToServerTime(time.Now())
+}
+
+// Synced reports whether at least one sample has been taken.
+func (c *serverClock) Synced() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.synced
+}
+
+/*
+Estimate returns the current estimate of the server's clock offset from the local clock,
+its drift as a rate, and the round trip time of the latest exchange.
+*/
+func (c *serverClock) Estimate() (offset time.Duration, drift float64, rtt time.Duration) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.offset, c.drift, c.rtt
+}
+
+/*
+syncClock periodically sends time sync requests to the server over TCP, until the
+connection is closed. The replies are handled by receiveTCP.
+*/
+func (g *Game) syncClock() {
+	for sent := 0; ; sent++ {
+		if _, err := g.tcpConn.Write(state.WithClientRequestingTimeSync(time.Now())); err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+
+			g.logger.Warnf("[TCP] Could not send time sync request: %s", err.Error())
+		}
+
+		if sent < timeSyncInitialSamples {
+			time.Sleep(timeSyncInitialInterval)
+		} else {
+			time.Sleep(timeSyncInterval)
+		}
+	}
+}
+
+// receiveTCP handles messages from the server's TCP connection, until it is closed.
+func (g *Game) receiveTCP() {
+	for {
+		var message state.State
+
+		_, err := g.tcpConn.ReadFrom(&message)
+		receivedAt := time.Now()
+
+		if err != nil {
+			if strings.Contains(err.Error(), "could not unmarshal") {
+				g.logger.Warnf("[TCP-RX] %s", err.Error())
+				continue
+			}
+
+			g.logger.Warn("[TCP-RX] Closed")
+			return
+		}
+
+		switch message.Submessage {
+		case state.Submessages.SERVER_TIME_SYNC:
+			g.clock.addSample(
+				time.Unix(0, message.TimeSync.ClientSentAt),
+				time.Unix(0, message.TimeSync.ServerReceivedAt),
+				time.Unix(0, message.TimeSync.ServerSentAt),
+				receivedAt,
+			)
+		case state.Submessages.SERVER_LISTING_ROOMS, state.Submessages.SERVER_JOINING_ROOM, state.Submessages.SERVER_REFUSING_ROOM:
+			g.roomChannel <- message
+		case state.Submessages.SERVER_PING:
+			// do nothing
+		default:
+			g.logger.Warnf("[TCP-RX] Unknown or unhandled state submessage: %s", message.Submessage.String())
+		}
+	}
+}
+
+/*
+ServerTime returns the current time on the server's clock, as estimated from the clock
+synchronisation exchanges on the TCP connection. Until the first exchange has completed,
+this is the local time.
+*/
+func (g *Game) ServerTime() time.Time {
+	return g.clock.ServerTime()
+}
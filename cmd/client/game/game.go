@@ -1,12 +1,18 @@
 package game
 
 import (
+	"fmt"
 	"net"
+	"sort"
 	"strings"
+	"time"
 
 	"fyp/common/ctypes"
+	"fyp/common/ctypes/sprites"
 	"fyp/common/ctypes/state"
-	"fyp/common/ctypes/tiles"
+	"fyp/common/maps"
+	"fyp/common/rollback"
+	"fyp/common/simulation"
 	"fyp/common/utils/logging"
 	"fyp/resources"
 
@@ -19,6 +25,7 @@ import (
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/audio"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/gofont/goregular"
 )
@@ -26,12 +33,16 @@ import (
 type Game struct {
 	ui                  *ebitenui.UI
 	font                font.Face
-	spritesheet         ctypes.Spritesheet
-	tiles               tiles.Tiles
-	currentMap          Map
+	spritesheet         sprites.Spritesheet
+	tiles               sprites.Tiles
+	playerSprites       sprites.Players
+	currentMap          maps.Map
 	localPlayer         ctypes.Player
 	localPlayerCanMove  bool
 	playerUpdateChannel chan ctypes.Player
+	inputSequence       uint64
+	tick                uint64
+	prediction          prediction
 
 	screenWidth  int
 	screenHeight int
@@ -46,25 +57,57 @@ type Game struct {
 
 	logger *logging.Logger
 
-	id                  string
-	serverAddress       string
-	tcpConn             *state.TCPConnection
-	tcpIsConnected      bool
-	udpConn             *state.UDPConnection
-	udpIsConnected      bool
-	udpCloseLoopChannel chan any
-	rxUDPSocketConn     *state.UDPConnection
+	id                    string
+	serverAddress         string
+	tcpConn               *state.TCPConnection
+	tcpIsConnected        bool
+	udpConn               *state.UDPConnection
+	udpIsConnected        bool
+	udpCloseLoopChannel   chan any
+	rxUDPSocketConn       *state.UDPConnection
+	joinChannel           chan state.State
+	roomChoice            RoomChoice
+	roomChannel           chan state.State
+	room                  state.RoomInfo
+	joinTicket            string
+	match                 state.MatchInfo
+	collected             map[ctypes.Position]bool
+	diedUntil             time.Time
+	checkpointNoticeUntil time.Time
+	finishedRound         int
+	playerNames           map[string]string
+	joined                bool
+	queuePosition         int
+	lastKeepAlive         time.Time
 
 	stateChannel       chan state.State
 	forceUpdateChannel chan state.State
+	inputChannel       chan state.State
 	serverState        state.State
 	clientID           uuid.NullUUID
+	displayName        string
 	clientSlot         int
-	players            map[string]ctypes.Player
+	clock              serverClock
+	clockWasSynced     bool
+	players            map[string]*remotePlayer
+	interpolationDelay time.Duration
+	showNetworkDebug   bool
+	stepper            *simulation.FixedStep
+	physics            simulation.Physics
+
+	netcodeMode     state.NetcodeMode
+	rollback        *rollback.Session
+	rollbackInbox   rollbackInbox
+	rollbackSession uint64
+	rollbackPlayers map[string]ctypes.Player
 }
 
+/*
+New creates a new Game, which plays in the chosen room. interpolationDelay is how far in
+the past remote players are rendered, see interpolationBuffer.
+*/
 func New(
-	serverAddress, tcpPort, udpPort string, logger *logging.Logger,
+	serverAddress, tcpPort, udpPort, displayName string, room RoomChoice, interpolationDelay time.Duration, logger *logging.Logger,
 ) *Game {
 	return &Game{
 		audioCtx:            audio.NewContext(44100),
@@ -76,10 +119,20 @@ func New(
 		udpPort:             udpPort,
 		logger:              logger,
 		udpCloseLoopChannel: make(chan any),
+		roomChoice:          room,
+		roomChannel:         make(chan state.State, 4),
 		stateChannel:        make(chan state.State),
 		forceUpdateChannel:  make(chan state.State),
+		inputChannel:        make(chan state.State, inputQueueSize),
+		playerNames:         make(map[string]string),
+		collected:           make(map[ctypes.Position]bool),
 		clientID:            uuid.NullUUID{Valid: false},
+		displayName:         displayName,
 		clientSlot:          0,
+		players:             make(map[string]*remotePlayer),
+		interpolationDelay:  interpolationDelay,
+		stepper:             simulation.NewFixedStep(simulation.SystemClock{}),
+		physics:             simulation.DefaultPhysics(),
 	}
 }
 
@@ -94,12 +147,13 @@ func (g *Game) init() error {
 		return err
 	}
 
-	g.tiles = tiles.Initialise(&g.spritesheet)
-	currentMap, err := LoadMapFromFile("resources/maps/01_start.map")
+	g.tiles = sprites.Initialise(&g.spritesheet)
+
+	playerSprites, err := sprites.NewPlayers(&g.spritesheet)
 	if err != nil {
 		return err
 	}
-	g.currentMap = *currentMap
+	g.playerSprites = playerSprites
 
 	stream, err := resources.GetMusicBgm()
 	if err != nil {
@@ -137,6 +191,25 @@ func (g *Game) init() error {
 
 		g.tcpConn = conn
 		g.tcpIsConnected = true
+
+		go g.receiveTCP()
+		go g.syncClock()
+
+		if err := g.enterRoom(); err != nil {
+			return err
+		}
+
+		// Every room plays on a map of its own.
+		mapPath, err := maps.PathOf(g.room.Map)
+		if err != nil {
+			return err
+		}
+
+		currentMap, err := maps.LoadMapFromFile(mapPath)
+		if err != nil {
+			return err
+		}
+		g.currentMap = *currentMap
 	}
 
 	if !g.udpIsConnected {
@@ -172,7 +245,7 @@ func (g *Game) init() error {
 		portColonIndex := strings.LastIndex(localAddress, ":")
 		portStr := localAddress[portColonIndex+1:]
 
-		s := state.WithClientUDPPort(portStr)
+		s := state.WithClientUDPPort(portStr, g.joinTicket)
 
 		bytesWritten, err := conn.Write(s)
 		if err != nil {
@@ -183,114 +256,14 @@ func (g *Game) init() error {
 		g.logger.Debugf("[UDP NET-INIT] Wrote %d bytes to server at %s: %s", bytesWritten, conn.RemoteAddr().String(), s)
 		g.logger.Debugf("[UDP NET-INIT] Local addr: %s", conn.LocalAddr().String())
 
-		type chanFields struct {
-			State *state.State
-			Error error
-		}
-
-		initStateChan := make(chan chanFields)
-
-		go func() {
-			for {
-				var initState state.State
-				_, _, err = socketConn.ReadFrom(&initState)
-				if err != nil {
-					if strings.Contains(err.Error(), "use of closed network connection") {
-						continue
-					}
-
-					g.logger.Errorf("[UDP] Could not get initial state from server: %s", err.Error())
-					initStateChan <- chanFields{Error: err}
-					continue
-				}
-
-				x, y := currentMap.GetSpawnPoint()
-				initState.Client.InitialPosition.X = x
-				initState.Client.InitialPosition.Y = y
-
-				initStateChan <- chanFields{State: &initState}
-				break
-			}
-		}()
-
-		res := <-initStateChan
-		if err = res.Error; err != nil {
-			g.logger.Fatalf(false, "[UDP] Could not get initial state from server: %s", err.Error())
-			return err
-		}
-
 		g.rxUDPSocketConn = socketConn
 		g.udpConn = conn
 		g.udpIsConnected = true
-		g.clientID = res.State.Client.ID
-		g.clientSlot = res.State.Client.Slot
-
-		player, err := ctypes.NewPlayer(res.State.Client.Colour, &g.spritesheet, res.State.Client.InitialPosition)
-		if err != nil {
-			g.logger.Fatalf(false, "[UDP] Could not create player from initial state from server: %s\n\nState received from server: %s", err.Error(), res.State)
-			return err
-		}
+		g.joinChannel = make(chan state.State)
 
-		g.localPlayer = *player
-		g.playerUpdateChannel = make(chan ctypes.Player)
+		go g.receiveJoin()
 	}
 
-	receivedState := state.Empty()
-
-	go func(c <-chan ctypes.Player) {
-		for {
-			if player, ok := <-c; ok {
-				if _, err := g.udpConn.Write(state.WithClientReady(g.clientID.UUID, player)); err != nil {
-					if strings.Contains(err.Error(), "connection refused") {
-						g.logger.Warnf("Exiting due to unavailable server: %s", err.Error())
-						break
-					}
-
-					g.logger.Warnf("Couldn't send ready message to server: %s", err.Error())
-					continue
-				}
-			} else {
-				continue
-			}
-
-			size, _, err := g.rxUDPSocketConn.ReadFrom(&receivedState)
-			if err != nil {
-				if strings.Contains(err.Error(), "use of closed network connection") {
-					g.logger.Warn("[UDP-RX] Closed")
-					break
-				}
-
-				continue
-			}
-
-			g.logger.Tracef("[UDP-RX] Received %d bytes from server: %s", size, receivedState)
-
-			if receivedState.Server.PriorityUpdate {
-				g.logger.Trace("[UDP] Game received priority update: force updating")
-				g.forceUpdateChannel <- receivedState
-				continue
-			} else if (receivedState.Server.UpdateID - g.serverState.Server.UpdateID) > 500 {
-				g.logger.Trace("[UDP] Game is lagging by more than 500 updates: force updating")
-				g.forceUpdateChannel <- receivedState
-				continue
-			}
-
-			g.stateChannel <- receivedState
-		}
-	}(g.playerUpdateChannel)
-
-	go func() {
-		<-g.udpCloseLoopChannel
-		g.logger.Infof("[UDP-RX] Stopping...")
-
-		defer g.udpConn.Close()
-		defer g.rxUDPSocketConn.Close()
-
-		if _, err := g.udpConn.Write(state.WithClientDisconnecting(g.clientID, g.localPlayer.PlayerSpriteIndex.String())); err != nil {
-			g.logger.Warnf("[UDP-TX] Could not warn server of disconnection: %s", err)
-		}
-	}()
-
 	return nil
 }
 
@@ -324,28 +297,20 @@ func (g *Game) Update() error {
 	g.ui.Container.RemoveChildren()
 
 	g.ui.Update()
-	if g.localPlayerCanMove {
-		g.localPlayer.Update()
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		g.showNetworkDebug = !g.showNetworkDebug
 	}
-	g.tiles.StepAnimateTiles()
 
-	colliding, tile := g.currentMap.IsColliding(int(g.localPlayer.Position.X), int(g.localPlayer.Position.Y))
-	if colliding {
-		tile := *tile
-
-		switch tile {
-		case tiles.Typeses.GROUND_UL_TILE:
-		case tiles.Typeses.GROUND_UM_TILE:
-		case tiles.Typeses.GROUND_UR_TILE:
-		case tiles.Typeses.GROUND_ML_TILE:
-		case tiles.Typeses.GROUND_MM_TILE:
-		case tiles.Typeses.GROUND_MR_TILE:
-			break
-		default:
-			g.localPlayer.TickPhysics()
-		}
-	} else {
-		g.localPlayer.TickPhysics()
+	if !g.joined {
+		return g.updateJoining()
+	}
+
+	g.updateMatch()
+
+	// The simulation runs at a fixed rate, however often Update is called.
+	for ticks := g.stepper.Ticks(); ticks > 0; ticks-- {
+		g.step()
 	}
 
 	select {
@@ -353,37 +318,103 @@ func (g *Game) Update() error {
 	default:
 	}
 
+	var received bool
+
 	select {
 	case s := <-g.forceUpdateChannel:
 		g.logger.Trace("Force updated")
 		g.serverState = s
+		received = true
 	case s := <-g.stateChannel:
 		g.logger.Info("Updated from server")
 		g.serverState = s
+		received = true
 	default:
 	}
 
-	if g.serverState.Message == state.Messages.FROM_SERVER {
+	// Snapshots received before the clock was synchronised were stamped with the local
+	// time, so can't be compared with the ones received afterwards.
+	if synced := g.clock.Synced(); synced != g.clockWasSynced {
+		g.clockWasSynced = synced
+
+		for _, remote := range g.players {
+			remote.buffer = interpolationBuffer{}
+		}
+	}
+
+	if received && g.serverState.Message == state.Messages.FROM_SERVER {
 		switch g.serverState.Submessage {
 		case state.Submessages.SERVER_FIRST_CLIENT_CONNECTION_INFORMATION:
 			g.clientID = g.serverState.Client.ID
 		case state.Submessages.SERVER_UPDATING_PLAYERS:
-			g.players = make(map[string]ctypes.Player)
+			clear(g.playerNames)
+			for id, player := range g.serverState.Server.Players {
+				g.playerNames[id] = player.DisplayName
+			}
+
+			if g.netcodeMode == state.NetcodeRollback {
+				if g.localPlayerCanMove && (g.rollback == nil || g.rollbackPlayersChanged(g.serverState.Server.Players)) {
+					g.startRollback(g.serverState.Server.Players)
+				}
+
+				break
+			}
 
-			for name, player := range g.serverState.Server.Players {
-				if name == g.localPlayer.PlayerSpriteIndex.String() {
+			snapshotAt := time.Now()
+			if g.clockWasSynced && g.serverState.Server.Time != 0 {
+				snapshotAt = time.Unix(0, g.serverState.Server.Time)
+			}
+
+			for id, player := range g.serverState.Server.Players {
+				if id == g.localID() {
+					g.syncHealth(player)
+					g.syncCheckpoint(player)
+					g.localPlayer.Score = player.Score
+					g.prediction.reconcile(&g.localPlayer, player, &g.currentMap, &g.physics)
 					continue
 				}
 
-				g.players[name] = player
+				remote, ok := g.players[id]
+				if !ok {
+					remote = &remotePlayer{player: player}
+					g.players[id] = remote
+				}
+
+				remote.player.Facing = player.Facing
+				remote.player.Score = player.Score
+				remote.player.Health = player.Health
+				remote.player.InvulnerableUntil = player.InvulnerableUntil
+				remote.buffer.push(snapshotAt, player.Position)
+			}
+
+			for id := range g.players {
+				if _, ok := g.serverState.Server.Players[id]; !ok {
+					delete(g.players, id)
+				}
 			}
 		case state.Submessages.SERVER_THIS_CLIENT_CAN_MOVE:
 			g.localPlayerCanMove = true
 		case state.Submessages.SERVER_THIS_CLIENT_CANNOT_MOVE:
 			g.localPlayerCanMove = false
 
-			x, y := g.currentMap.GetSpawnPoint()
-			g.localPlayer.Position = ctypes.NewPosition(x, y)
+			g.localPlayer.SetPosition(g.respawnPoint())
+			g.prediction.reset()
+			g.rollback = nil
+		case state.Submessages.SERVER_UPDATING_MATCH, state.Submessages.SERVER_PLAYERS_HAVE_FINISHED:
+			if g.serverState.Server.Match != nil {
+				g.match = *g.serverState.Server.Match
+
+				clear(g.collected)
+				for _, position := range g.match.Collected {
+					g.collected[position] = true
+				}
+			}
+		case state.Submessages.SERVER_REACHING_CHECKPOINT:
+			if player, ok := g.serverState.Server.Players[g.localID()]; ok {
+				g.syncCheckpoint(player)
+			}
+		case state.Submessages.SERVER_REJECTING_NAME:
+			return fmt.Errorf("the server rejected the name %q: %s", g.displayName, g.serverState.Server.Reason)
 		case state.Submessages.SUBMESSAGE_NONE:
 			// do nothing
 		default:
@@ -391,36 +422,145 @@ func (g *Game) Update() error {
 		}
 	}
 
-	id := g.clientID
-	player := g.localPlayer
+	return nil
+}
 
-	go updateServer(id, player, g.udpConn, g.logger)
+// localID returns the ID of this client, which is also the ID of its player.
+func (g *Game) localID() string {
+	return g.clientID.UUID.String()
+}
 
-	return nil
+// step runs a single tick of the simulation, see simulation.TickRate.
+func (g *Game) step() {
+	g.tick++
+	g.inputSequence++
+
+	var input ctypes.PlayerInput
+	if g.localPlayerCanMove {
+		input = inputFromKeyboard()
+	}
+	input.Sequence = g.inputSequence
+	input.Tick = g.tick
+	input.ViewTime = g.ServerTime().UnixNano()
+	_, _, rtt := g.clock.Estimate()
+	input.RoundTrip = int64(rtt)
+
+	switch {
+	case g.netcodeMode == state.NetcodeRollback && g.rollback != nil:
+		g.updateRollback(input)
+		g.checkFinished()
+	case g.netcodeMode == state.NetcodeRollback:
+		// Nothing moves until every client has started its session from the same state.
+		// The server still hears from the client meanwhile, as its player is sent every
+		// frame, see Update.
+	default:
+		// The input is applied straight away rather than waiting for the server, see
+		// prediction.
+		g.localPlayer.Simulate(input, &g.currentMap, &g.physics)
+		g.prediction.push(input)
+		g.prediction.step()
+	}
+
+	g.tiles.StepAnimateTiles()
+
+	if g.netcodeMode != state.NetcodeRollback {
+		g.queueInputs(state.WithPlayerInputs(g.clientID, g.prediction.latest(redundantInputs)))
+	}
+}
+
+// inputQueueSize is how many packets of inputs can wait to be sent before the newest are dropped.
+const inputQueueSize = 8
+
+/*
+queueInputs queues a packet of inputs to be sent to the server, without waiting for it to
+be sent. If the queue is full the packet is dropped, as its inputs are sent again in the
+next one.
+*/
+func (g *Game) queueInputs(message state.State) {
+	select {
+	case g.inputChannel <- message:
+	default:
+		g.logger.Warn("[UDP] Dropped inputs, as the previous ones haven't been sent yet")
+	}
 }
 
-func updateServer(clientID uuid.NullUUID, player ctypes.Player, conn *state.UDPConnection, logger *logging.Logger) {
-	_, err := conn.Write(state.WithUpdatedPlayerState(clientID, player))
+/*
+sendInputs sends every packet of inputs that is queued to the server, one at a time, so
+that they are sent in the order that they were made.
+*/
+func (g *Game) sendInputs() {
+	for message := range g.inputChannel {
+		if _, err := g.udpConn.Write(message); err != nil {
+			g.logger.Errorf("[UDP] Could not send inputs to the server: %s", err.Error())
+		}
+	}
+}
+
+// sendKeepAlive tells the server that the client is still there, so its session isn't evicted.
+func sendKeepAlive(clientID uuid.NullUUID, conn *state.UDPConnection, logger *logging.Logger) {
+	_, err := conn.Write(state.WithClientKeepingAlive(clientID))
 	if err != nil {
-		logger.Errorf("error updating server's version of this player via UDP: %s", err.Error())
+		logger.Errorf("[UDP] Could not send keep-alive to the server: %s", err.Error())
 	}
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
-	g.currentMap.Draw(screen, &g.tiles)
-	g.localPlayer.Draw(screen)
+	if !g.joined {
+		g.drawJoining(screen)
+		return
+	}
+
+	if g.rollback != nil {
+		g.drawRollback(screen)
+		return
+	}
 
-	if !g.localPlayerCanMove {
-		ebitenutil.DebugPrintAt(screen, "Waiting for players...", g.screenWidth/2, g.screenHeight/2)
+	// The server decides who collected what, see models.Pickups, and who reached which checkpoint.
+	drawMapHiding(screen, &g.currentMap, &g.tiles, g.isHidden)
+	g.drawCheckpoint(screen)
+	if !g.isBlinking(&g.localPlayer) {
+		g.playerSprites.DrawWithOffset(screen, &g.localPlayer, g.prediction.offsetX, g.prediction.offsetY)
 	}
 
-	for _, player := range g.players {
-		player.InitFrames(&g.spritesheet)
-		player.RemoteUpdatePosition()
-		player.Draw(screen)
+	renderAt := g.ServerTime().Add(-g.interpolationDelay)
+
+	for _, remote := range g.players {
+		remote.player.Position = remote.buffer.sample(renderAt)
+		if !g.isBlinking(&remote.player) {
+			g.playerSprites.Draw(screen, &remote.player)
+		}
+	}
+
+	g.drawMatch(screen)
+
+	if g.showNetworkDebug {
+		states := g.InterpolationStates()
+		ids := make([]string, 0, len(states))
+		for id := range states {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		for line, id := range ids {
+			ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%s: %s", g.players[id].player.DisplayName, states[id]), 0, line*16)
+		}
 	}
 }
 
+/*
+InterpolationStates returns the state of every remote player's snapshot buffer, keyed by
+the ID of the player's client. The same information is drawn on screen when F3 is
+pressed.
+*/
+func (g *Game) InterpolationStates() map[string]InterpolationState {
+	states := make(map[string]InterpolationState, len(g.players))
+	for id, remote := range g.players {
+		states[id] = remote.buffer.state
+	}
+
+	return states
+}
+
 func (g *Game) Layout(_, _ int) (screenWidth, screenHeight int) {
 	g.screenWidth = 640
 	g.screenHeight = 480
@@ -433,8 +573,19 @@ func (g *Game) Delete() error {
 		g.tcpConn.Close()
 	}
 
-	if g.udpIsConnected {
+	switch {
+	case g.joined:
 		g.udpCloseLoopChannel <- nil
+	case g.udpIsConnected:
+		// A queued client still has to leave the queue.
+		if g.clientID.Valid {
+			if _, err := g.udpConn.Write(state.WithClientDisconnecting(g.clientID)); err != nil {
+				g.logger.Warnf("[UDP-TX] Could not leave the queue: %s", err)
+			}
+		}
+
+		g.udpConn.Close()
+		g.rxUDPSocketConn.Close()
 	}
 
 	g.audioPlayer.Close()
@@ -0,0 +1,47 @@
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"fyp/common/ctypes"
+)
+
+// blinkInterval is how long an invulnerable player is shown, and then hidden, for.
+const blinkInterval = 100 * time.Millisecond
+
+/*
+syncHealth takes the local player's health from the server, which decides who is hurt and
+killed. A player who has died since the last update has already been respawned, so the
+client only has to say so until they can be hurt again.
+*/
+func (g *Game) syncHealth(authoritative ctypes.Player) {
+	if authoritative.Deaths > g.localPlayer.Deaths {
+		g.diedUntil = time.Unix(0, authoritative.InvulnerableUntil)
+	}
+
+	g.localPlayer.Health = authoritative.Health
+	g.localPlayer.InvulnerableUntil = authoritative.InvulnerableUntil
+	g.localPlayer.Deaths = authoritative.Deaths
+}
+
+// isBlinking reports whether an invulnerable player should be hidden this frame, so that they flash.
+func (g *Game) isBlinking(player *ctypes.Player) bool {
+	now := g.ServerTime()
+	if !player.IsInvulnerable(now) {
+		return false
+	}
+
+	return (now.UnixNano()/int64(blinkInterval))%2 == 0
+}
+
+// healthLines describes the local player's health, and whether they have just died.
+func (g *Game) healthLines() []string {
+	lines := []string{fmt.Sprintf("Health: %d", g.localPlayer.Health)}
+
+	if g.ServerTime().Before(g.diedUntil) {
+		lines = append(lines, "You died! Respawning...")
+	}
+
+	return lines
+}
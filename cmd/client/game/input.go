@@ -0,0 +1,20 @@
+package game
+
+import (
+	"fyp/common/ctypes"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+/*
+inputFromKeyboard reads the current keyboard state into a PlayerInput. The sequence and
+tick numbers are left for the caller to fill in.
+*/
+func inputFromKeyboard() ctypes.PlayerInput {
+	return ctypes.PlayerInput{
+		Left:   ebiten.IsKeyPressed(ebiten.KeyA),
+		Right:  ebiten.IsKeyPressed(ebiten.KeyD),
+		Jump:   ebiten.IsKeyPressed(ebiten.KeyW),
+		Crouch: ebiten.IsKeyPressed(ebiten.KeyS),
+	}
+}
@@ -0,0 +1,196 @@
+package game
+
+import (
+	"fmt"
+	"time"
+
+	"fyp/common/ctypes"
+)
+
+const (
+	// DefaultInterpolationDelay is how far in the past remote players are rendered when no
+	// other delay is configured.
+	DefaultInterpolationDelay = 100 * time.Millisecond
+
+	// The longest a remote player is extrapolated past their latest snapshot before they
+	// are held in place.
+	maxExtrapolation = 250 * time.Millisecond
+
+	// The most snapshots kept for each remote player.
+	maxSnapshots = 64
+)
+
+type InterpolationMode int
+
+const (
+	InterpolationEmpty InterpolationMode = iota
+	InterpolationHolding
+	InterpolationInterpolating
+	InterpolationExtrapolating
+)
+
+func (mode InterpolationMode) String() string {
+	switch mode {
+	case InterpolationEmpty:
+		return "empty"
+	case InterpolationHolding:
+		return "holding"
+	case InterpolationInterpolating:
+		return "interpolating"
+	case InterpolationExtrapolating:
+		return "extrapolating"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+InterpolationState describes the state of a remote player's snapshot buffer, as of the
+last time it was sampled. This is only intended for debugging.
+*/
+type InterpolationState struct {
+	Snapshots    int
+	Oldest       time.Time
+	Newest       time.Time
+	RenderTime   time.Time
+	Mode         InterpolationMode
+	Extrapolated time.Duration
+}
+
+func (is InterpolationState) String() string {
+	behind := is.Newest.Sub(is.RenderTime).Milliseconds()
+
+	return fmt.Sprintf("%d snaps, %dms ahead of render, %s (+%dms)", is.Snapshots, behind, is.Mode, is.Extrapolated.Milliseconds())
+}
+
+type snapshot struct {
+	at       time.Time
+	position ctypes.Position
+}
+
+/*
+interpolationBuffer keeps time-stamped snapshots of a remote player's position. Rather
+than moving the player straight to the latest position received, the player is rendered
+a short delay in the past, between the two snapshots either side of that time. This
+hides late and lost packets, as long as they arrive within the delay.
+
+If no snapshot newer than the render time has arrived, the player is extrapolated from
+the velocity between the last two snapshots, for at most maxExtrapolation.
+*/
+type interpolationBuffer struct {
+	snapshots []snapshot
+	state     InterpolationState
+}
+
+// push inserts a snapshot, keeping the buffer ordered by time.
+func (b *interpolationBuffer) push(at time.Time, position ctypes.Position) {
+	index := len(b.snapshots)
+	for index > 0 && b.snapshots[index-1].at.After(at) {
+		index--
+	}
+
+	if index > 0 && b.snapshots[index-1].at.Equal(at) {
+		b.snapshots[index-1].position = position
+		return
+	}
+
+	b.snapshots = append(b.snapshots, snapshot{})
+	copy(b.snapshots[index+1:], b.snapshots[index:])
+	b.snapshots[index] = snapshot{at: at, position: position}
+
+	if len(b.snapshots) > maxSnapshots {
+		b.snapshots = b.snapshots[len(b.snapshots)-maxSnapshots:]
+	}
+}
+
+/*
+sample returns the position of the player at renderAt, and drops any snapshots that are
+no longer needed to sample times after renderAt.
+*/
+func (b *interpolationBuffer) sample(renderAt time.Time) ctypes.Position {
+	var position ctypes.Position
+
+	b.state.RenderTime = renderAt
+	b.state.Extrapolated = 0
+
+	switch {
+	case len(b.snapshots) == 0:
+		b.state.Mode = InterpolationEmpty
+	case !renderAt.After(b.snapshots[0].at):
+		b.state.Mode = InterpolationHolding
+		position = b.snapshots[0].position
+	case renderAt.After(b.snapshots[len(b.snapshots)-1].at):
+		position = b.extrapolate(renderAt)
+	default:
+		position = b.interpolate(renderAt)
+	}
+
+	b.state.Snapshots = len(b.snapshots)
+	if len(b.snapshots) > 0 {
+		b.state.Oldest = b.snapshots[0].at
+		b.state.Newest = b.snapshots[len(b.snapshots)-1].at
+	}
+
+	return position
+}
+
+func (b *interpolationBuffer) interpolate(renderAt time.Time) ctypes.Position {
+	next := 1
+	for b.snapshots[next].at.Before(renderAt) {
+		next++
+	}
+
+	from := b.snapshots[next-1]
+	to := b.snapshots[next]
+
+	// Only the snapshot either side of renderAt is needed from now on.
+	b.snapshots = b.snapshots[next-1:]
+	b.state.Mode = InterpolationInterpolating
+
+	t := float64(renderAt.Sub(from.at)) / float64(to.at.Sub(from.at))
+
+	return ctypes.NewPosition(
+		from.position.X+(to.position.X-from.position.X)*t,
+		from.position.Y+(to.position.Y-from.position.Y)*t,
+	)
+}
+
+func (b *interpolationBuffer) extrapolate(renderAt time.Time) ctypes.Position {
+	last := b.snapshots[len(b.snapshots)-1]
+
+	if len(b.snapshots) > 1 {
+		b.snapshots = b.snapshots[len(b.snapshots)-2:]
+	}
+
+	if len(b.snapshots) < 2 {
+		b.state.Mode = InterpolationHolding
+		return last.position
+	}
+
+	previous := b.snapshots[0]
+	interval := last.at.Sub(previous.at)
+
+	ahead := renderAt.Sub(last.at)
+	if ahead > maxExtrapolation {
+		ahead = maxExtrapolation
+	}
+
+	b.state.Mode = InterpolationExtrapolating
+	b.state.Extrapolated = ahead
+
+	t := float64(ahead) / float64(interval)
+
+	return ctypes.NewPosition(
+		last.position.X+(last.position.X-previous.position.X)*t,
+		last.position.Y+(last.position.Y-previous.position.Y)*t,
+	)
+}
+
+/*
+remotePlayer is a player controlled by another client, along with the snapshots used to
+render it.
+*/
+type remotePlayer struct {
+	player ctypes.Player
+	buffer interpolationBuffer
+}
@@ -0,0 +1,196 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/state"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// How often a queued client lets the server know that it is still waiting.
+const queueKeepAliveInterval = time.Second
+
+/*
+receiveJoin passes on what the server sends while the client is joining, until the
+client is either let in or refused. While the room is full, the client is told where it is
+in the room's queue until a slot frees up.
+*/
+func (g *Game) receiveJoin() {
+	for {
+		var received state.State
+		if _, _, err := g.rxUDPSocketConn.ReadFrom(&received); err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+
+			g.logger.Errorf("[UDP] Could not get initial state from server: %s", err.Error())
+			continue
+		}
+
+		switch received.Submessage {
+		case state.Submessages.SERVER_QUEUEING_CLIENT:
+			g.joinChannel <- received
+		case state.Submessages.SERVER_FIRST_CLIENT_CONNECTION_INFORMATION, state.Submessages.SERVER_REFUSING_CONNECTION:
+			g.joinChannel <- received
+			return
+		}
+	}
+}
+
+/*
+updateJoining runs instead of the game until the client has joined. It returns an error
+if the server refuses the client.
+*/
+func (g *Game) updateJoining() error {
+	select {
+	case received := <-g.joinChannel:
+		switch received.Submessage {
+		case state.Submessages.SERVER_QUEUEING_CLIENT:
+			if g.queuePosition != received.Server.QueuePosition {
+				g.logger.Infof("[UDP] Room is full, number %d in the queue", received.Server.QueuePosition)
+			}
+
+			g.clientID = received.Client.ID
+			g.queuePosition = received.Server.QueuePosition
+		case state.Submessages.SERVER_REFUSING_CONNECTION:
+			return fmt.Errorf("the server refused the connection: %s", received.Server.Reason)
+		case state.Submessages.SERVER_FIRST_CLIENT_CONNECTION_INFORMATION:
+			return g.join(received)
+		}
+	default:
+	}
+
+	if g.queuePosition > 0 && time.Since(g.lastKeepAlive) >= queueKeepAliveInterval {
+		g.lastKeepAlive = time.Now()
+		go sendKeepAlive(g.clientID, g.udpConn, g.logger)
+	}
+
+	return nil
+}
+
+// join sets up the game from the initial state that the server sends once there is room.
+func (g *Game) join(initial state.State) error {
+	g.queuePosition = 0
+
+	g.clientID = initial.Client.ID
+	g.clientSlot = initial.Client.Slot
+	g.netcodeMode = initial.Client.NetcodeMode
+
+	if initial.Client.Physics != nil {
+		g.physics = *initial.Client.Physics
+	}
+
+	if g.netcodeMode == "" {
+		g.netcodeMode = state.NetcodeAuthoritative
+	}
+
+	g.logger.Infof("[UDP] Using %s netcode", g.netcodeMode)
+
+	x, y := g.currentMap.GetSpawnPoint()
+
+	player, err := ctypes.NewPlayer(initial.Client.Colour, ctypes.NewPosition(x, y))
+	if err != nil {
+		g.logger.Fatalf(false, "[UDP] Could not create player from initial state from server: %s\n\nState received from server: %s", err.Error(), initial)
+		return err
+	}
+
+	if g.displayName == "" {
+		g.displayName = player.PlayerSpriteIndex.String()
+	}
+
+	player.DisplayName = g.displayName
+
+	g.localPlayer = *player
+	g.playerUpdateChannel = make(chan ctypes.Player)
+
+	go g.sendInputs()
+
+	receivedState := state.Empty()
+
+	go func(c <-chan ctypes.Player) {
+		for {
+			if player, ok := <-c; ok {
+				if _, err := g.udpConn.Write(state.WithClientReady(g.clientID.UUID, g.displayName, player)); err != nil {
+					if strings.Contains(err.Error(), "connection refused") {
+						g.logger.Warnf("Exiting due to unavailable server: %s", err.Error())
+						break
+					}
+
+					g.logger.Warnf("Couldn't send ready message to server: %s", err.Error())
+					continue
+				}
+			}
+		}
+	}(g.playerUpdateChannel)
+
+	// The server sends several updates for every input, so the receiving side is kept
+	// separate from the sending side to avoid a backlog building up in the socket.
+	go func() {
+		for {
+			size, _, err := g.rxUDPSocketConn.ReadFrom(&receivedState)
+			if err != nil {
+				if strings.Contains(err.Error(), "use of closed network connection") {
+					g.logger.Warn("[UDP-RX] Closed")
+					break
+				}
+
+				continue
+			}
+
+			g.logger.Tracef("[UDP-RX] Received %d bytes from server: %s", size, receivedState)
+
+			// Relayed inputs arrive far more often than anything else, and must not be
+			// dropped like a stale state update can be.
+			if receivedState.Submessage == state.Submessages.SERVER_RELAYING_ROLLBACK_INPUTS {
+				g.rollbackInbox.push(receivedState.Server.RollbackSession, receivedState.Server.RelayedInputs)
+				continue
+			}
+
+			if receivedState.Server.PriorityUpdate {
+				g.logger.Trace("[UDP] Game received priority update: force updating")
+				g.forceUpdateChannel <- receivedState
+				continue
+			} else if (receivedState.Server.UpdateID - g.serverState.Server.UpdateID) > 500 {
+				g.logger.Trace("[UDP] Game is lagging by more than 500 updates: force updating")
+				g.forceUpdateChannel <- receivedState
+				continue
+			}
+
+			g.stateChannel <- receivedState
+		}
+	}()
+
+	go func() {
+		<-g.udpCloseLoopChannel
+		g.logger.Infof("[UDP-RX] Stopping...")
+
+		defer g.udpConn.Close()
+		defer g.rxUDPSocketConn.Close()
+
+		if _, err := g.udpConn.Write(state.WithClientDisconnecting(g.clientID)); err != nil {
+			g.logger.Warnf("[UDP-TX] Could not warn server of disconnection: %s", err)
+		}
+	}()
+
+	g.joined = true
+
+	return nil
+}
+
+// drawJoining draws what the client is waiting for while it joins.
+func (g *Game) drawJoining(screen *ebiten.Image) {
+	drawMap(screen, &g.currentMap, &g.tiles)
+
+	message := "Connecting..."
+	if g.queuePosition > 0 {
+		message = fmt.Sprintf("Room is full, you are number %d in the queue...", g.queuePosition)
+	}
+
+	ebitenutil.DebugPrintAt(screen, message, g.screenWidth/4, g.screenHeight/2)
+	ebitenutil.DebugPrintAt(screen, g.roomLabel(), g.screenWidth/4, g.screenHeight/2+16)
+}
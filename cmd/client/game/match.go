@@ -0,0 +1,163 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/state"
+	"fyp/common/utils/logging"
+
+	"github.com/google/uuid"
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// readyKey toggles whether the player is ready for the next match.
+const readyKey = ebiten.KeyR
+
+// updateMatch lets the player ready up for the next match, while there isn't one going.
+func (g *Game) updateMatch() {
+	if g.match.Phase != state.MatchWaiting && g.match.Phase != state.MatchReadyCheck {
+		return
+	}
+
+	if inpututil.IsKeyJustPressed(readyKey) {
+		go sendReady(g.clientID, !g.isReady(), g.udpConn, g.logger)
+	}
+}
+
+/*
+checkFinished tells the server once the local player reaches the exit. This is only
+needed in rollback mode, as otherwise the server sees for itself.
+*/
+func (g *Game) checkFinished() {
+	if g.netcodeMode != state.NetcodeRollback || g.match.Phase != state.MatchPlaying || g.finishedRound == g.match.Round {
+		return
+	}
+
+	if !g.currentMap.ReachedExit(int(g.localPlayer.Position.X), int(g.localPlayer.Position.Y)) {
+		return
+	}
+
+	g.finishedRound = g.match.Round
+
+	go func() {
+		if _, err := g.udpConn.Write(state.WithClientFinishingLevel(g.clientID)); err != nil {
+			g.logger.Errorf("[UDP] Could not tell the server that the level was finished: %s", err.Error())
+		}
+	}()
+}
+
+func sendReady(clientID uuid.NullUUID, ready bool, conn *state.UDPConnection, logger *logging.Logger) {
+	if _, err := conn.Write(state.WithClientReadyingUp(clientID, ready)); err != nil {
+		logger.Errorf("[UDP] Could not tell the server whether the player is ready: %s", err.Error())
+	}
+}
+
+// isReady reports whether the server has the local player down as ready for the next match.
+func (g *Game) isReady() bool {
+	return slices.Contains(g.match.Ready, g.localID())
+}
+
+// nameOf returns the display name of the player with the given ID.
+func (g *Game) nameOf(id string) string {
+	if name, ok := g.playerNames[id]; ok {
+		return name
+	}
+
+	return "Someone who left"
+}
+
+// isCollected reports whether the server says the pickup at the given position has been collected.
+func (g *Game) isCollected(position ctypes.Position) bool {
+	return g.collected[position]
+}
+
+// secondsLeft returns how many whole seconds are left until the match's current phase ends.
+func (g *Game) secondsLeft() int {
+	left := time.Unix(0, g.match.EndsAt).Sub(g.ServerTime())
+
+	return max(0, int(math.Ceil(left.Seconds())))
+}
+
+/*
+drawMatch draws what the match is waiting for, or how it went. The countdown is worked out
+from the server's clock, so every client shows the same number at the same time.
+*/
+func (g *Game) drawMatch(screen *ebiten.Image) {
+	var lines []string
+
+	switch g.match.Phase {
+	case state.MatchWaiting, "":
+		lines = append(lines, "Waiting for players...", g.roomLabel())
+		if g.isReady() {
+			lines = append(lines, "You're ready")
+		} else {
+			lines = append(lines, "Press R to ready up")
+		}
+	case state.MatchReadyCheck:
+		lines = append(lines, fmt.Sprintf("Ready check: %d of %d players ready", len(g.match.Ready), len(g.playerNames)))
+		if g.isReady() {
+			lines = append(lines, "You're ready, waiting for everyone else")
+		} else {
+			lines = append(lines, "Press R when you're ready")
+		}
+	case state.MatchCountdown:
+		lines = append(lines, fmt.Sprintf("Match %d starts in %d...", g.match.Round, g.secondsLeft()))
+	case state.MatchPlaying:
+		lines = append(lines, g.playingLines()...)
+	case state.MatchResults:
+		lines = append(lines, fmt.Sprintf("Match %d results", g.match.Round), g.match.Summary)
+		for place, id := range g.match.Standings {
+			if g.match.Mode == state.GameModeCoins {
+				lines = append(lines, fmt.Sprintf("%d. %s, %d points", place+1, g.nameOf(id), g.match.Scores[id]))
+			} else {
+				lines = append(lines, fmt.Sprintf("%d. %s", place+1, g.nameOf(id)))
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("Next match in %d...", g.secondsLeft()))
+	}
+
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		ebitenutil.DebugPrintAt(screen, line, g.screenWidth/3, g.screenHeight/3+i*16)
+	}
+}
+
+// playingLines describes how the match is going while it is being played, which depends on its game mode.
+func (g *Game) playingLines() []string {
+	var lines []string
+
+	// In rollback mode the server doesn't simulate anyone, so can't keep score or hurt them.
+	if g.netcodeMode != state.NetcodeRollback {
+		lines = append(lines, fmt.Sprintf("Score: %d", g.localPlayer.Score))
+		lines = append(lines, g.healthLines()...)
+		lines = append(lines, g.checkpointLines()...)
+	}
+
+	switch g.match.Mode {
+	case state.GameModeCoins:
+		// The match's scores are the same as the players' own, which are shown above.
+		lines = append(lines, fmt.Sprintf("Most points wins, %d seconds left", g.secondsLeft()))
+	case state.GameModeCoop:
+		lines = append(lines, fmt.Sprintf("%d of %d players at the door, %d seconds left", len(g.match.Finished), len(g.playerNames), g.secondsLeft()))
+	default:
+		if place := slices.Index(g.match.Finished, g.localID()); place >= 0 {
+			lines = append(lines, fmt.Sprintf("You finished in place %d", place+1))
+		}
+
+		if len(g.match.Finished) > 0 && g.match.EndsAt != 0 {
+			lines = append(lines, fmt.Sprintf("%s finished first, %d seconds left", g.nameOf(g.match.Finished[0]), g.secondsLeft()))
+		}
+	}
+
+	return lines
+}
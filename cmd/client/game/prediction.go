@@ -0,0 +1,119 @@
+package game
+
+import (
+	"math"
+
+	"fyp/common/ctypes"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+)
+
+const (
+	// The most inputs kept while waiting for the server to acknowledge them. If the server
+	// stops responding, the oldest inputs are dropped.
+	maxPendingInputs = 256
+
+	// How many of the latest pending inputs are sent in every packet, so that a lost packet
+	// doesn't lose an input. This is the same as in rollback mode, see rollback.RedundantInputs.
+	redundantInputs = 8
+
+	// How much of the remaining correction offset is kept each frame.
+	correctionDecay = 0.8
+
+	// Offsets smaller than this are dropped, rather than decaying forever.
+	correctionEpsilon = 0.1
+
+	// Corrections larger than this are snapped instead of being smoothed, e.g. when the
+	// server has respawned the player.
+	correctionSnapDistance = 4 * maps.TileSize
+)
+
+/*
+prediction implements client-side prediction for the local player. Every input is
+applied locally as soon as it is read, and kept until the server acknowledges that it has
+processed it. When the server's authoritative state for the local player arrives, the
+local player is rewound to it, and the inputs the server hasn't processed yet are
+replayed on top.
+
+The difference between where the player was drawn before and after a reconciliation is
+kept as an offset, which decays over the following frames so that corrections are
+smoothed rather than snapped.
+*/
+type prediction struct {
+	pending      []ctypes.PlayerInput
+	acknowledged uint64
+	offsetX      float64
+	offsetY      float64
+}
+
+// push records an input that has been applied locally but not yet acknowledged.
+func (p *prediction) push(input ctypes.PlayerInput) {
+	if len(p.pending) >= maxPendingInputs {
+		p.pending = p.pending[1:]
+	}
+
+	p.pending = append(p.pending, input)
+}
+
+/*
+reconcile rewinds the local player to the authoritative state sent by the server, and
+replays every input that the server hasn't processed yet. Snapshots older than the last
+one reconciled against are ignored, as UDP can reorder packets.
+*/
+func (p *prediction) reconcile(player *ctypes.Player, authoritative ctypes.Player, ground ctypes.Ground, physics *simulation.Physics) {
+	if authoritative.LastInputSequence < p.acknowledged {
+		return
+	}
+
+	p.acknowledged = authoritative.LastInputSequence
+
+	acknowledgedAmount := 0
+	for acknowledgedAmount < len(p.pending) && p.pending[acknowledgedAmount].Sequence <= p.acknowledged {
+		acknowledgedAmount++
+	}
+
+	p.pending = p.pending[acknowledgedAmount:]
+
+	before := player.Position
+
+	player.ResetTo(authoritative.Body)
+	player.LastInputSequence = p.acknowledged
+
+	for _, input := range p.pending {
+		player.Simulate(input, ground, physics)
+	}
+
+	p.offsetX += before.X - player.Position.X
+	p.offsetY += before.Y - player.Position.Y
+
+	if math.Hypot(p.offsetX, p.offsetY) > correctionSnapDistance {
+		p.offsetX, p.offsetY = 0, 0
+	}
+}
+
+// latest returns up to the last amount inputs that haven't been acknowledged yet, oldest first.
+func (p *prediction) latest(amount int) []ctypes.PlayerInput {
+	pending := p.pending[max(0, len(p.pending)-amount):]
+
+	return append([]ctypes.PlayerInput(nil), pending...)
+}
+
+// step decays the correction offset, and should be called once per frame.
+func (p *prediction) step() {
+	p.offsetX *= correctionDecay
+	p.offsetY *= correctionDecay
+
+	if math.Abs(p.offsetX) < correctionEpsilon {
+		p.offsetX = 0
+	}
+
+	if math.Abs(p.offsetY) < correctionEpsilon {
+		p.offsetY = 0
+	}
+}
+
+// reset drops every pending input and any correction that is being smoothed.
+func (p *prediction) reset() {
+	p.pending = nil
+	p.offsetX, p.offsetY = 0, 0
+}
@@ -0,0 +1,223 @@
+package game
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/state"
+	"fyp/common/rollback"
+	"fyp/common/simulation"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+// Every client has to seed its simulation the same way for their sessions to agree.
+const rollbackSeed = 0
+
+// maxInboxSessions is how many rollback sessions the inbox holds inputs for at once.
+const maxInboxSessions = 4
+
+/*
+rollbackInbox holds the inputs relayed from the other clients, by the rollback session
+that they are for, until the game loop is ready to add them to that session. Clients
+don't all start a session at the same moment, so inputs for a session can arrive before
+this client has started it, and are kept until it does. Every input is only kept once,
+however many times it is resent.
+*/
+type rollbackInbox struct {
+	mutex  sync.Mutex
+	inputs map[uint64]map[string]map[uint64]ctypes.PlayerInput
+	// sessions is every session with inputs in the inbox, in the order they were first seen.
+	sessions []uint64
+}
+
+func (ri *rollbackInbox) push(session uint64, relayed map[string][]ctypes.PlayerInput) {
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+
+	if ri.inputs == nil {
+		ri.inputs = make(map[uint64]map[string]map[uint64]ctypes.PlayerInput)
+	}
+
+	byID, ok := ri.inputs[session]
+	if !ok {
+		// Sessions that were never started, or have been left, are forgotten eventually.
+		if len(ri.sessions) >= maxInboxSessions {
+			delete(ri.inputs, ri.sessions[0])
+			ri.sessions = ri.sessions[1:]
+		}
+
+		byID = make(map[string]map[uint64]ctypes.PlayerInput)
+		ri.inputs[session] = byID
+		ri.sessions = append(ri.sessions, session)
+	}
+
+	for id, inputs := range relayed {
+		if _, ok := byID[id]; !ok {
+			byID[id] = make(map[uint64]ctypes.PlayerInput)
+		}
+
+		for _, input := range inputs {
+			byID[id][input.Tick] = input
+		}
+	}
+}
+
+// drain takes every input that is held for the session out of the inbox, oldest first.
+func (ri *rollbackInbox) drain(session uint64) map[string][]ctypes.PlayerInput {
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+
+	byID, ok := ri.inputs[session]
+	if !ok {
+		return nil
+	}
+
+	delete(ri.inputs, session)
+	ri.sessions = slices.DeleteFunc(ri.sessions, func(held uint64) bool { return held == session })
+
+	drained := make(map[string][]ctypes.PlayerInput, len(byID))
+	for id, byTick := range byID {
+		inputs := make([]ctypes.PlayerInput, 0, len(byTick))
+		for _, input := range byTick {
+			inputs = append(inputs, input)
+		}
+
+		sort.Slice(inputs, func(i, j int) bool { return inputs[i].Tick < inputs[j].Tick })
+		drained[id] = inputs
+	}
+
+	return drained
+}
+
+/*
+rollbackSessionID identifies the rollback session that every client starts for the given
+round of the match and players, so that inputs are only ever added to the session they
+were made in. A new session is started whenever the players change, and every client
+sees the same players and round, so they all work out the same ID.
+*/
+func rollbackSessionID(round int, players map[string]ctypes.Player) uint64 {
+	ids := make([]string, 0, len(players))
+	for id := range players {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	hash := fnv.New64a()
+	hash.Write([]byte(strconv.Itoa(round)))
+
+	for _, id := range ids {
+		hash.Write([]byte{0})
+		hash.Write([]byte(id))
+	}
+
+	return hash.Sum64()
+}
+
+/*
+startRollback starts a new rollback session from the players that the server knows
+about. Every client starts its session from the same positions, as sent by the server,
+rather than from wherever its own copy of each player happens to be.
+*/
+func (g *Game) startRollback(players map[string]ctypes.Player) {
+	sim := simulation.New(&g.currentMap, g.physics, rollbackSeed)
+	localID := g.localID()
+
+	g.rollbackPlayers = make(map[string]ctypes.Player, len(players))
+
+	for id, player := range players {
+		g.rollbackPlayers[id] = player
+
+		sim.AddPlayer(id, simulation.NewBody(player.Position))
+	}
+
+	if _, ok := players[localID]; !ok {
+		g.rollbackPlayers[localID] = g.localPlayer
+		sim.AddPlayer(localID, g.localPlayer.Body)
+	}
+
+	// Inputs that other clients have already sent for this session are left in the inbox,
+	// and added on the first update.
+	g.rollbackSession = rollbackSessionID(g.match.Round, players)
+	g.rollback = rollback.NewSession(sim, localID, rollback.DefaultInputDelay)
+	g.logger.Infof("[ROLLBACK] Started session %x with %d players", g.rollbackSession, len(sim.Players()))
+}
+
+// rollbackPlayersChanged reports whether the server's players differ from the session's.
+func (g *Game) rollbackPlayersChanged(players map[string]ctypes.Player) bool {
+	ids := make([]string, 0, len(players))
+	for id := range players {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	current := g.rollback.Simulation().Players()
+	if len(current) != len(ids) {
+		return true
+	}
+
+	for i := range ids {
+		if ids[i] != current[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+updateRollback runs a single frame of the rollback session: the relayed remote inputs
+are added, the local input is scheduled and sent to the other clients through the
+server, and the simulation is advanced. While the session is stalled waiting for remote
+inputs, the local input is dropped rather than replacing one that was already sent, see
+rollback.Session.AddLocalInput.
+*/
+func (g *Game) updateRollback(input ctypes.PlayerInput) {
+	for id, inputs := range g.rollbackInbox.drain(g.rollbackSession) {
+		for _, remoteInput := range inputs {
+			g.rollback.AddRemoteInput(id, remoteInput)
+		}
+	}
+
+	g.rollback.AddLocalInput(input)
+
+	g.queueInputs(state.WithRollbackInputs(g.clientID, g.rollbackSession, g.rollback.LocalInputs()))
+
+	if !g.rollback.Advance() {
+		g.logger.Debug("[ROLLBACK] Waiting for inputs from remote players")
+	}
+
+	if body, ok := g.rollback.Simulation().Player(g.localID()); ok {
+		g.localPlayer.Body = body
+	}
+}
+
+/*
+drawRollback draws the rollback session's simulation as it is. Every player is already
+where this client believes them to be, so there is nothing to interpolate.
+*/
+func (g *Game) drawRollback(screen *ebiten.Image) {
+	sim := g.rollback.Simulation()
+
+	drawMapHiding(screen, &g.currentMap, &g.tiles, sim.IsCollected)
+
+	for _, id := range sim.Players() {
+		player := g.rollbackPlayers[id]
+		player.Body, _ = sim.Player(id)
+		g.playerSprites.Draw(screen, &player)
+	}
+
+	g.drawMatch(screen)
+
+	if g.showNetworkDebug {
+		ebitenutil.DebugPrintAt(screen, fmt.Sprintf("frame %d, %d rollbacks", sim.Frame(), g.rollback.Rollbacks), 0, 0)
+	}
+}
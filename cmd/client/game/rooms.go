@@ -0,0 +1,88 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"fyp/common/ctypes/state"
+)
+
+// roomReplyTimeout is how long the client waits for the server to answer a room request.
+const roomReplyTimeout = 5 * time.Second
+
+/*
+RoomChoice is which room the client plays in. With Create set, the client creates a room
+with those settings and joins it. Otherwise it joins the room with the code ID, or the
+server's default room if ID is empty.
+*/
+type RoomChoice struct {
+	ID       string
+	Password string
+	Create   *state.RoomSettings
+}
+
+/*
+enterRoom lists the server's rooms, and then creates or joins the room that was chosen
+over TCP, see RoomChoice. The server answers with a ticket, which the client's UDP
+connection is let into the room with.
+*/
+func (g *Game) enterRoom() error {
+	if _, err := g.tcpConn.Write(state.WithClientListingRooms()); err != nil {
+		return fmt.Errorf("could not ask the server for its rooms: %w", err)
+	}
+
+	request := state.WithClientJoiningRoom(g.roomChoice.ID, g.roomChoice.Password)
+	if g.roomChoice.Create != nil {
+		request = state.WithClientCreatingRoom(*g.roomChoice.Create)
+	}
+
+	if _, err := g.tcpConn.Write(request); err != nil {
+		return fmt.Errorf("could not ask the server for a room: %w", err)
+	}
+
+	timeout := time.After(roomReplyTimeout)
+
+	for {
+		select {
+		case reply := <-g.roomChannel:
+			switch reply.Submessage {
+			case state.Submessages.SERVER_LISTING_ROOMS:
+				g.logRooms(reply.Server.Rooms)
+			case state.Submessages.SERVER_JOINING_ROOM:
+				if reply.Server.Room == nil {
+					return errors.New("the server let the client into a room without saying which")
+				}
+
+				g.room = *reply.Server.Room
+				g.joinTicket = reply.Server.JoinTicket
+				g.logger.Infof("[TCP] Joined room %s (%q) on %s, playing %s, with %d of %d players", g.room.ID, g.room.Name, g.room.Map, g.room.Mode, g.room.Players, g.room.MaxPlayers)
+
+				return nil
+			case state.Submessages.SERVER_REFUSING_ROOM:
+				return fmt.Errorf("the server refused the room: %s", reply.Server.Reason)
+			}
+		case <-timeout:
+			return errors.New("the server didn't answer the room request in time")
+		}
+	}
+}
+
+// logRooms logs every public room on the server, so that the user can pick one to join.
+func (g *Game) logRooms(rooms []state.RoomInfo) {
+	g.logger.Infof("[TCP] The server has %d public rooms", len(rooms))
+
+	for _, room := range rooms {
+		password := ""
+		if room.HasPassword {
+			password = ", needs a password"
+		}
+
+		g.logger.Infof("[TCP]   %s: %q on %s, playing %s, %d of %d players, %d queued%s", room.ID, room.Name, room.Map, room.Mode, room.Players, room.MaxPlayers, room.Queued, password)
+	}
+}
+
+// roomLabel describes the room that the client is in, so that its code can be shared.
+func (g *Game) roomLabel() string {
+	return fmt.Sprintf("Room %q, code %s, playing %s", g.room.Name, g.room.ID, g.room.Mode)
+}
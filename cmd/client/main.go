@@ -2,8 +2,11 @@ package main
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"fyp/cmd/client/game"
+	"fyp/common/ctypes/state"
 	"fyp/common/utils/env"
 	"fyp/common/utils/logging"
 
@@ -42,7 +45,58 @@ func main() {
 		os.Exit(1)
 	}
 
-	g := game.New(serverAddress, tcpPort, udpPort, log)
+	interpolationDelay := game.DefaultInterpolationDelay
+	if _p, isPresent := os.LookupEnv("INTERPOLATION_DELAY"); isPresent {
+		delay, err := time.ParseDuration(_p)
+		if err != nil {
+			log.Errorf("Could not parse INTERPOLATION_DELAY value, expected a duration such as \"100ms\": %s", err.Error())
+			os.Exit(1)
+		}
+
+		interpolationDelay = delay
+	}
+
+	// The server checks the name, and the client exits if it is rejected. Without one,
+	// the player is named after the colour the server gives it.
+	displayName, _ := os.LookupEnv("PLAYER_NAME")
+
+	// Without a room code, the client joins the server's default room. CREATE_ROOM creates
+	// a room instead, whose code is shown in game so that others can join it.
+	var room game.RoomChoice
+	room.ID, _ = os.LookupEnv("ROOM")
+	room.Password, _ = os.LookupEnv("ROOM_PASSWORD")
+
+	if _p, isPresent := os.LookupEnv("CREATE_ROOM"); isPresent && _p != "" {
+		settings := state.RoomSettings{Name: _p, Password: room.Password}
+		settings.Map, _ = os.LookupEnv("ROOM_MAP")
+		if _p, isPresent := os.LookupEnv("ROOM_MODE"); isPresent {
+			settings.Mode = state.GameModeName(_p)
+		}
+
+		if _p, isPresent := os.LookupEnv("ROOM_PRIVATE"); isPresent && _p != "" {
+			private, err := strconv.ParseBool(_p)
+			if err != nil {
+				log.Errorf("Could not parse ROOM_PRIVATE value, expected true or false: %s", err.Error())
+				os.Exit(1)
+			}
+
+			settings.Private = private
+		}
+
+		if _p, isPresent := os.LookupEnv("ROOM_MAX_PLAYERS"); isPresent && _p != "" {
+			maxPlayers, err := strconv.Atoi(_p)
+			if err != nil {
+				log.Errorf("Could not parse ROOM_MAX_PLAYERS value, expected a value convertable to an integer: %s", err.Error())
+				os.Exit(1)
+			}
+
+			settings.MaxPlayers = maxPlayers
+		}
+
+		room.Create = &settings
+	}
+
+	g := game.New(serverAddress, tcpPort, udpPort, displayName, room, interpolationDelay, log)
 
 	ebiten.SetWindowTitle("Final Year Project")
 	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
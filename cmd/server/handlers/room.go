@@ -0,0 +1,786 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/state"
+	"fyp/common/ctypes/tiles"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+	"fyp/common/utils/logging"
+	"fyp/internal/models"
+
+	typedsockets "fyp/common/utils/net/typed-sockets"
+
+	"github.com/google/uuid"
+)
+
+/*
+sendQueueLimit is how many control and relayed messages can be waiting for a client
+before it is considered to have fallen too far behind, and is disconnected. Player
+updates don't count towards it, as only the latest one is ever kept.
+*/
+const sendQueueLimit = 256
+
+// sweepInterval is how often a room evicts idle sessions when nobody is sending anything.
+const sweepInterval = time.Second
+
+// matchTickInterval is how often a room checks whether its match is due its next phase.
+const matchTickInterval = 50 * time.Millisecond
+
+/*
+roomInboxSize is how many packets can be waiting for a room's tick loop before any more
+are dropped, so that a room that has fallen behind doesn't hold up every other room.
+*/
+const roomInboxSize = 256
+
+// roomPacket is a single packet from a client, passed on by the UDP handler to its room.
+type roomPacket struct {
+	message    state.State
+	addr       net.Addr
+	receivedAt time.Time
+}
+
+/*
+RoomHandler runs a single room, with a server state, sessions and map of its own, in a
+tick loop of its own. The UDP handler passes on every packet from the room's clients,
+see RoomManager, and the room is only ever changed from its tick loop.
+*/
+type RoomHandler struct {
+	id             string
+	config         models.RoomConfig
+	logger         *logging.Logger
+	manager        *RoomManager
+	serverState    *models.ServerState
+	world          *maps.Map
+	physics        simulation.Physics
+	netcodeMode    state.NetcodeMode
+	lagCompensator *models.LagCompensator
+	inputLimiter   *models.InputLimiter
+	sessions       *models.Sessions
+	outbox         *models.Outbox
+	stateHandler   *StateHandler
+	inbox          chan roomPacket
+	closeChannel   chan any
+	done           chan struct{}
+	// dropped is how many packets were dropped because the room's inbox was full.
+	dropped  atomic.Uint64
+	updateID atomic.Uint64
+	updates  map[uint64]state.State
+
+	// joining is how many clients have redeemed a ticket into the room, but haven't been
+	// given a session by the tick loop yet. It is only increased with the manager's
+	// mutex held, so that a room is never cleaned up while someone is joining it.
+	joining atomic.Int64
+	// emptySince is only used by the manager, see RoomManager.removeEmptyRooms.
+	emptySince time.Time
+}
+
+func newRoomHandler(id string, config models.RoomConfig, world *maps.Map, mode models.GameMode, manager *RoomManager) *RoomHandler {
+	logger := manager.logger
+	spawnX, spawnY := world.GetSpawnPoint()
+	matchConfig := models.DefaultMatchConfig(ctypes.NewPosition(spawnX, spawnY), mode)
+	serverState := models.NewServerState(models.NewEventBus(), matchConfig)
+	closeChannel := make(chan any)
+
+	outbox := models.NewOutbox(sendQueueLimit, func(clientID string, err error) {
+		logger.Errorf("[ROOM %s] Could not send to client with id '%s': %s", id, clientID, err.Error())
+	})
+
+	return &RoomHandler{
+		id:             id,
+		config:         config,
+		logger:         logger,
+		manager:        manager,
+		serverState:    serverState,
+		world:          world,
+		physics:        manager.physics,
+		netcodeMode:    manager.netcodeMode,
+		lagCompensator: models.NewLagCompensator(),
+		inputLimiter:   models.NewInputLimiter(models.DefaultInputBurst),
+		sessions:       models.NewSessions(config.MaxPlayers, config.QueueSize, config.IdleTimeout),
+		outbox:         outbox,
+		stateHandler:   NewStateHandler(logger, id, serverState, closeChannel),
+		inbox:          make(chan roomPacket, roomInboxSize),
+		closeChannel:   closeChannel,
+		done:           make(chan struct{}),
+		updates:        make(map[uint64]state.State),
+	}
+}
+
+// ID returns the code that the room is joined by.
+func (rh *RoomHandler) ID() string {
+	return rh.id
+}
+
+// ServerState returns the room's server state.
+func (rh *RoomHandler) ServerState() *models.ServerState {
+	return rh.serverState
+}
+
+// Info describes the room as it is now, as it is listed to clients.
+func (rh *RoomHandler) Info() state.RoomInfo {
+	seated, queued := rh.sessions.Counts()
+
+	return state.RoomInfo{
+		ID:          rh.id,
+		Name:        rh.config.Name,
+		Map:         maps.NameOf(rh.config.MapPath),
+		Mode:        rh.config.Mode,
+		Players:     seated,
+		MaxPlayers:  rh.config.MaxPlayers,
+		Queued:      queued,
+		Private:     rh.config.Private,
+		HasPassword: rh.config.Password != "",
+	}
+}
+
+/*
+deliver passes a packet on to the room's tick loop without waiting, as every room is fed
+by the same UDP read loop. It returns an error if the room has already closed, or if it
+has fallen so far behind that its inbox is full, in which case the packet is dropped and
+counted. Clients resend their latest inputs in every packet, so a dropped input is made
+up for by the next packet that gets through.
+*/
+func (rh *RoomHandler) deliver(packet roomPacket) error {
+	select {
+	case <-rh.done:
+		return errors.New("room has closed")
+	default:
+	}
+
+	select {
+	case rh.inbox <- packet:
+		return nil
+	default:
+		return fmt.Errorf("room is busy, and has dropped %d packets", rh.dropped.Add(1))
+	}
+}
+
+// close stops the room's tick loop, and its state handler.
+func (rh *RoomHandler) close() {
+	close(rh.closeChannel)
+}
+
+/*
+run is the room's tick loop. It handles the room's packets in the order they arrived,
+moves the match on and brings back pickups whenever they are due, and every
+sweepInterval evicts idle sessions and resolves touches even when nobody is sending
+anything.
+*/
+func (rh *RoomHandler) run() {
+	defer close(rh.done)
+
+	go rh.stateHandler.Handle()
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	matchTicker := time.NewTicker(matchTickInterval)
+	defer matchTicker.Stop()
+
+	for {
+		select {
+		case <-rh.closeChannel:
+			rh.logger.Infof("[ROOM %s] Closed", rh.id)
+			return
+		case packet := <-rh.inbox:
+			rh.handlePacket(packet)
+		case now := <-matchTicker.C:
+			rh.advanceMatch(now)
+			rh.respawnPickups(now)
+		case now := <-ticker.C:
+			rh.evictIdleSessions(now)
+			rh.disconnectFallenBehind()
+			rh.resolveTouches(now)
+		}
+	}
+}
+
+/*
+endSession cleans up after a session that has been closed or evicted, letting in the next
+queued client, and removing the client's player from the game and telling everyone else.
+*/
+func (rh *RoomHandler) endSession(session models.Session) {
+	rh.manager.untrack(session.ID)
+	rh.outbox.Remove(session.ID.String())
+	if err := session.Conn.Close(); err != nil {
+		rh.logger.Debugf("[ROOM %s] Could not close connection to client with id '%s': %s", rh.id, session.ID, err.Error())
+	}
+
+	if session.Phase == models.SessionQueued {
+		rh.notifyQueue()
+	} else {
+		rh.admitQueued()
+	}
+
+	id := session.ID.String()
+	if !rh.serverState.ContainsPlayer(id) {
+		return
+	}
+
+	rh.serverState.RemovePlayer(id)
+	rh.lagCompensator.RemovePlayer(id)
+	rh.inputLimiter.RemovePlayer(id)
+
+	rh.broadcastPlayers()
+	rh.broadcastMatch()
+	rh.advanceMatch(time.Now())
+}
+
+/*
+disconnectFallenBehind disconnects every client whose outbound queue overflowed since it
+was last called. It is called from the tick loop rather than from the outbox, so that the
+server state is only ever changed from one place.
+*/
+func (rh *RoomHandler) disconnectFallenBehind() {
+	for _, id := range rh.outbox.FallenBehind() {
+		sessionID, err := uuid.Parse(id)
+		if err != nil {
+			continue
+		}
+
+		if session, ok := rh.sessions.Close(sessionID); ok {
+			rh.logger.Warnf("[ROOM %s] Client with id '%s' fell too far behind, disconnecting", rh.id, id)
+			rh.endSession(session)
+		}
+	}
+}
+
+/*
+admitQueued lets in every queued client that has been given a slot, and tells the rest
+where they now are in the queue.
+*/
+func (rh *RoomHandler) admitQueued() {
+	admitted := rh.sessions.Admitted()
+	if len(admitted) == 0 {
+		return
+	}
+
+	for _, session := range admitted {
+		rh.logger.Infof("[ROOM %s] Admitted queued client with id '%s' into slot %d", rh.id, session.ID, session.Slot)
+		rh.welcome(session)
+	}
+
+	rh.notifyQueue()
+}
+
+// notifyQueue tells every queued client where it is in the queue.
+func (rh *RoomHandler) notifyQueue() {
+	for i, session := range rh.sessions.Queued() {
+		rh.notifyQueued(session, i+1)
+	}
+}
+
+// welcome starts sending to a client that has a slot, beginning with its initial state.
+func (rh *RoomHandler) welcome(session models.Session) {
+	id := session.ID.String()
+
+	rh.outbox.Add(id, session.Conn.Write)
+	rh.outbox.Send(id, state.WithNewClientConnection(session.ID, session.Slot, rh.netcodeMode, rh.physics))
+	rh.logger.Infof("[ROOM %s] Queued initial data for client at %s", rh.id, session.ReplyAddress)
+}
+
+/*
+notifyQueued tells a queued client where it is in the queue. Queued clients aren't sent
+anything else, so they have no outbound queue, and are written to directly.
+*/
+func (rh *RoomHandler) notifyQueued(session models.Session, position int) {
+	if _, err := session.Conn.Write(state.WithServerQueueingClient(session.ID, position)); err != nil {
+		rh.logger.Errorf("[ROOM %s] Could not tell client with id '%s' its place in the queue: %s", rh.id, session.ID, err.Error())
+	}
+}
+
+// evictIdleSessions disconnects every client that hasn't been heard from recently.
+func (rh *RoomHandler) evictIdleSessions(now time.Time) {
+	for _, session := range rh.sessions.EvictIdle(now) {
+		rh.logger.Warnf("[ROOM %s] Client with id '%s' (%s) was idle since %s, disconnecting", rh.id, session.ID, session.Phase, session.LastSeen.Format(time.StampMilli))
+		rh.endSession(session)
+	}
+}
+
+/*
+openSession connects to a client that has just joined the room, and gives it a session.
+The client is queued if the room is full, or refused if its queue is full too.
+*/
+func (rh *RoomHandler) openSession(clientState state.State, addr net.Addr, receivedAt time.Time) {
+	defer rh.joining.Add(-1)
+
+	portColonIndex := strings.LastIndex(addr.String(), ":")
+	clientIP := addr.String()[:portColonIndex]
+	clientPort := clientState.Client.UDPPort
+
+	rh.logger.Debugf("[ROOM %s] Initial connection with client at %s: %s", rh.id, addr, clientState)
+
+	clientConn, err := typedsockets.DialUDP[state.State](clientIP, clientPort)
+	if err != nil {
+		rh.logger.Errorf("[ROOM %s] Could not connect to client at '%s:%s'", rh.id, clientIP, clientPort)
+		return
+	}
+
+	session, err := rh.sessions.Open(addr.String(), net.JoinHostPort(clientIP, clientPort), clientConn, receivedAt)
+	if err != nil {
+		rh.logger.Warnf("[ROOM %s] Refused client at %s: %s", rh.id, addr, err.Error())
+
+		if _, err := clientConn.Write(state.WithServerRefusingConnection(err.Error())); err != nil {
+			rh.logger.Errorf("[ROOM %s] Could not tell client at %s why it was refused: %s", rh.id, addr, err.Error())
+		}
+
+		clientConn.Close()
+		return
+	}
+
+	rh.manager.track(session.ID, rh)
+
+	if session.Phase == models.SessionQueued {
+		position := len(rh.sessions.Queued())
+		rh.logger.Infof("[ROOM %s] Room is full, queued client at %s with id '%s' at position %d", rh.id, session.ReplyAddress, session.ID, position)
+		rh.notifyQueued(session, position)
+
+		return
+	}
+
+	rh.logger.Infof("[ROOM %s] Connected to client's UDP socket at %s. Client ID: %s, slot %d", rh.id, session.ReplyAddress, session.ID, session.Slot)
+	rh.welcome(session)
+}
+
+/*
+handleConnection adds the client's player to the server state if it isn't already
+present, under the display name the client picked. New players are always placed at the
+map's spawn point, in their session's colour, regardless of what the client sent. If the
+display name is invalid or already taken, the client is told why and isn't added.
+*/
+func (rh *RoomHandler) handleConnection(session models.Session, clientState state.State, receivedAt time.Time) {
+	id := session.ID.String()
+
+	if !rh.serverState.ContainsPlayer(id) {
+		displayName := clientState.Client.Player.Name
+		if err := models.ValidateDisplayName(displayName); err != nil {
+			rh.rejectName(id, displayName, err)
+			return
+		}
+
+		player := clientState.Client.Player.Inner
+		x, y := rh.world.GetSpawnPoint()
+		player.Position = ctypes.NewPosition(x, y)
+		player.LastInputSequence = 0
+		player.PlayerSpriteIndex = session.Colour
+		player.DisplayName = displayName
+
+		if err := rh.serverState.AddPlayer(id, player); err != nil {
+			rh.rejectName(id, displayName, err)
+			return
+		}
+
+		rh.sessions.MarkPlaying(session.ID, displayName, receivedAt)
+
+		// A player that joins part of the way through a match plays in it, from the spawn
+		// point.
+		match := rh.serverState.Snapshot().Match()
+		rh.outbox.Send(id, state.WithServerUpdatingMatch(match.Info()))
+		if match.State == models.MatchPlaying {
+			rh.outbox.Send(id, state.WithServerMakingPlayerAbleToMove())
+		}
+
+		rh.broadcastPlayers()
+		rh.advanceMatch(receivedAt)
+
+		return
+	}
+
+	rh.broadcastPlayers()
+}
+
+func (rh *RoomHandler) rejectName(id, displayName string, err error) {
+	rh.logger.Infof("[ROOM %s] Rejected display name %q from client with id '%s': %s", rh.id, displayName, id, err.Error())
+	rh.outbox.Send(id, state.WithServerRejectingName(err.Error()))
+}
+
+// displayName returns the display name of the client's player, or its ID if it has none.
+func (rh *RoomHandler) displayName(id string) string {
+	if player, ok := rh.serverState.Snapshot().Player(id); ok {
+		return player.DisplayName
+	}
+
+	return id
+}
+
+/*
+handleInputs runs every input command that the client sent, oldest first, and publishes
+the resulting authoritative positions to every client. Clients resend their latest
+inputs in every packet, so any that have already been run are skipped, see handleInput.
+*/
+func (rh *RoomHandler) handleInputs(clientState state.State, receivedAt time.Time) {
+	inputs := clientState.Client.Inputs
+	if len(inputs) == 0 {
+		inputs = []ctypes.PlayerInput{clientState.Client.Input}
+	}
+
+	moved := false
+	for _, input := range inputs {
+		if rh.handleInput(clientState.Client.ID.UUID.String(), input, receivedAt) {
+			moved = true
+		}
+	}
+
+	if moved {
+		rh.broadcastPlayers()
+	}
+}
+
+/*
+handleInput runs the movement simulation for the client's player from a single input
+command, and returns whether it was run. Players can only move while a match is being
+played, so otherwise only gravity is simulated. Players that touch spikes or fall out of
+the map are then hurt or killed, and players that touch a checkpoint respawn there from
+then on. The resulting position is recorded for lag compensation against the time the
+client saw it, which is also when the player reached the exit if they did.
+
+Clients can't step their player more often than the simulation's tick rate by sending
+inputs faster than it, see models.InputLimiter, so those inputs are dropped.
+*/
+func (rh *RoomHandler) handleInput(id string, input ctypes.PlayerInput, receivedAt time.Time) bool {
+	// Inputs that SimulatePlayer would ignore mustn't use up any of the player's budget.
+	if player, ok := rh.serverState.Snapshot().Player(id); !ok || input.Sequence <= player.LastInputSequence {
+		return false
+	}
+
+	if !rh.inputLimiter.Allow(id, receivedAt) {
+		rh.logger.Debugf("[ROOM %s] Dropped input %d from %s, who is sending inputs faster than the tick rate", rh.id, input.Sequence, rh.displayName(id))
+		return false
+	}
+
+	if rh.serverState.Snapshot().Match().State != models.MatchPlaying {
+		input = input.WithoutMovement()
+	}
+
+	player, ok := rh.serverState.SimulatePlayer(id, input, rh.world, &rh.physics)
+	if !ok {
+		return false
+	}
+
+	player = rh.applyHazards(id, player, receivedAt)
+
+	viewTime := models.ViewTime(receivedAt, input)
+	rh.lagCompensator.Record(id, viewTime, receivedAt, player.Position, rh.world)
+
+	if checkpoint, ok := rh.world.TouchingCheckpoint(int(player.Position.X), int(player.Position.Y)); ok {
+		rh.reachCheckpoint(id, checkpoint.Position)
+	}
+
+	if rh.world.ReachedExit(int(player.Position.X), int(player.Position.Y)) {
+		rh.reachExit(id, viewTime, receivedAt)
+	}
+
+	return true
+}
+
+/*
+applyHazards kills the client's player if they fell out of the map, or hurts them if they
+are touching spikes, and returns the player afterwards. Hazards only apply while the match
+is being played, and players who die are respawned straight away, see
+models.ServerState.KillPlayer.
+*/
+func (rh *RoomHandler) applyHazards(id string, player ctypes.Player, now time.Time) ctypes.Player {
+	switch {
+	case rh.world.FellOut(player.Position.Y):
+		if !rh.serverState.KillPlayer(id, "falling out of the map", now) {
+			return player
+		}
+	case rh.world.TouchingHazard(int(player.Position.X), int(player.Position.Y)):
+		damage := rh.serverState.MatchConfig().Health.SpikeDamage
+		if !rh.serverState.HurtPlayer(id, damage, "spikes", now) {
+			return player
+		}
+	default:
+		return player
+	}
+
+	if hurt, ok := rh.serverState.Snapshot().Player(id); ok {
+		return hurt
+	}
+
+	return player
+}
+
+/*
+reachCheckpoint makes the checkpoint at position the client's player's respawn point, and
+tells every client so that the player's own client respawns them there too. Every player
+has their own checkpoint, so reaching one is never contested.
+*/
+func (rh *RoomHandler) reachCheckpoint(id string, position ctypes.Position) {
+	if !rh.serverState.ReachCheckpoint(id, position) {
+		return
+	}
+
+	player, _ := rh.serverState.Snapshot().Player(id)
+	rh.logger.Infof("[ROOM %s] %s reached the checkpoint at (%.0f, %.0f)", rh.id, player.DisplayName, position.X, position.Y)
+
+	rh.outbox.Broadcast(state.WithServerReachingCheckpoint(id, player))
+}
+
+/*
+touchTile tells the room's game mode that the client's player touched a tile, and tells
+every client if that changed the match, e.g. because the player finished or scored.
+*/
+func (rh *RoomHandler) touchTile(id string, tile tiles.Types, at time.Time) {
+	if !rh.serverState.TouchTile(id, tile, at) {
+		return
+	}
+
+	match := rh.serverState.Snapshot().Match()
+	rh.logger.Infof("[ROOM %s] %s touched %s in match %d, with %d finished and a score of %d", rh.id, rh.displayName(id), tile.String(), match.Round, len(match.Finished()), match.Score(id))
+	rh.broadcastMatch()
+}
+
+/*
+reachExit tells the room's game mode that the client's player reached the door at the time
+they saw themselves reach it, and tells every client if that finished them. Finishers are
+placed by that time rather than by when their packets arrived, see models.Match.Finished,
+so where they placed is logged, along with everyone they placed ahead of, so that the
+standings can be audited.
+*/
+func (rh *RoomHandler) reachExit(id string, at, receivedAt time.Time) {
+	if !rh.serverState.TouchTile(id, tiles.Typeses.DOOR_OPENED_TILE, at) {
+		return
+	}
+
+	match := rh.serverState.Snapshot().Match()
+	finished := match.Finished()
+	place := slices.Index(finished, id) + 1
+
+	rh.logger.Infof(
+		"[ROOM %s: lag compensation] %s reached the door in place %d of match %d, seen at %s, received at %s",
+		rh.id, rh.displayName(id), place, match.Round, at.Format(time.StampMicro), receivedAt.Format(time.StampMicro),
+	)
+
+	for _, overtaken := range finished[place:] {
+		rh.logger.Infof("[ROOM %s: lag compensation] %s placed ahead of %s, who was heard from first but reached the door later", rh.id, rh.displayName(id), rh.displayName(overtaken))
+	}
+
+	rh.broadcastMatch()
+}
+
+/*
+advanceMatch moves the match on to its next phase if it is due one, and tells every
+client. Players can only move while the match is being played, and are put back at their
+checkpoint, or the spawn point, when a countdown starts or the match ends, so are sent again.
+*/
+func (rh *RoomHandler) advanceMatch(now time.Time) {
+	previous := rh.serverState.Snapshot().Match().State
+
+	match, changed := rh.serverState.AdvanceMatch(now)
+	if !changed {
+		return
+	}
+
+	rh.logger.Infof("[ROOM %s] Match %d went from %s to %s", rh.id, match.Round, previous, match.State)
+
+	switch {
+	case match.State == models.MatchPlaying:
+		rh.outbox.Broadcast(state.WithServerMakingPlayerAbleToMove())
+	case previous == models.MatchPlaying:
+		rh.outbox.Broadcast(state.WithServerMakingPlayerUnableToMove())
+		rh.broadcastPlayers()
+	case match.State == models.MatchCountdown:
+		// Every pickup is back for the next match.
+		rh.lagCompensator.ReleaseAll()
+		rh.broadcastPlayers()
+	}
+
+	if match.State == models.MatchResults {
+		rh.outbox.Broadcast(state.WithServerPlayersHaveFinished(match.Info()))
+		return
+	}
+
+	rh.outbox.Broadcast(state.WithServerUpdatingMatch(match.Info()))
+}
+
+// broadcastMatch tells every client how the match is going, e.g. who is ready.
+func (rh *RoomHandler) broadcastMatch() {
+	rh.outbox.Broadcast(state.WithServerUpdatingMatch(rh.serverState.Snapshot().Match().Info()))
+}
+
+/*
+relayRollbackInputs passes a client's rollback inputs on to every other client. In
+rollback mode the server doesn't simulate anything itself.
+*/
+func (rh *RoomHandler) relayRollbackInputs(clientID string, clientState state.State) {
+	relayed := state.WithRelayedRollbackInputs(clientID, clientState.Client.RollbackSession, clientState.Client.Inputs)
+
+	rh.outbox.BroadcastExcept(clientID, relayed)
+}
+
+/*
+resolveTouches resolves every contestable tile whose claim window has closed, logs the
+outcome, and lets whoever touched it first collect it if it is a pickup, or otherwise
+tells the game mode. Contested decisions are always logged so that they can be audited.
+*/
+func (rh *RoomHandler) resolveTouches(now time.Time) {
+	for _, resolution := range rh.lagCompensator.Resolve(now) {
+		winner := resolution.Winner
+		tile := resolution.Tile
+
+		if pickup, ok := rh.config.Pickups[tile.Type]; ok {
+			rh.collectPickup(winner.PlayerID, tile, pickup, winner.At)
+		} else {
+			rh.touchTile(winner.PlayerID, tile.Type, winner.At)
+		}
+
+		if !resolution.Contested() {
+			rh.logger.Debugf("[ROOM %s: lag compensation] %s touched %s at (%.0f, %.0f)", rh.id, rh.displayName(winner.PlayerID), tile.Type.String(), tile.Position.X, tile.Position.Y)
+			continue
+		}
+
+		rh.logger.Infof(
+			"[ROOM %s: lag compensation] Contested %s at (%.0f, %.0f): %s won, seen at %s, received at %s",
+			rh.id, tile.Type.String(), tile.Position.X, tile.Position.Y,
+			rh.displayName(winner.PlayerID), winner.At.Format(time.StampMicro), winner.ReceivedAt.Format(time.StampMicro),
+		)
+
+		for _, loser := range resolution.Losers {
+			rh.logger.Infof(
+				"[ROOM %s: lag compensation] Contested %s at (%.0f, %.0f): %s lost, seen at %s (%s later), received at %s",
+				rh.id, tile.Type.String(), tile.Position.X, tile.Position.Y,
+				rh.displayName(loser.PlayerID), loser.At.Format(time.StampMicro), loser.At.Sub(winner.At), loser.ReceivedAt.Format(time.StampMicro),
+			)
+		}
+	}
+}
+
+/*
+collectPickup lets the client's player collect a pickup, and tells every client that it
+has gone and what the player's score is now. Pickups can only be collected while the
+match is being played, so otherwise the tile is let go to be claimed again.
+*/
+func (rh *RoomHandler) collectPickup(id string, tile maps.PlacedTile, pickup models.Pickup, at time.Time) {
+	if !rh.serverState.CollectPickup(id, tile.Type, tile.Position, pickup, at) {
+		rh.lagCompensator.Release(tile.Position)
+		return
+	}
+
+	player, _ := rh.serverState.Snapshot().Player(id)
+	rh.logger.Infof("[ROOM %s] %s collected %s at (%.0f, %.0f), and has a score of %d", rh.id, player.DisplayName, tile.Type.String(), tile.Position.X, tile.Position.Y, player.Score)
+
+	rh.broadcastPlayers()
+	rh.broadcastMatch()
+}
+
+// respawnPickups brings back every pickup that is due back, and tells every client.
+func (rh *RoomHandler) respawnPickups(now time.Time) {
+	respawned := rh.serverState.RespawnPickups(now)
+	if len(respawned) == 0 {
+		return
+	}
+
+	for _, position := range respawned {
+		rh.lagCompensator.Release(position)
+	}
+
+	rh.broadcastMatch()
+}
+
+/*
+broadcastPlayers sends the authoritative state of every player to every connected
+client, including the client's own player so that it can correct its local copy. Sending
+never waits on a client, see models.Outbox.
+*/
+func (rh *RoomHandler) broadcastPlayers() {
+	// Every client is sent the same snapshot, even if the state changes part of the way
+	// through.
+	players := rh.serverState.Snapshot().Players()
+
+	rh.outbox.Broadcast(state.WithUpdatedPlayers(int(rh.updateID.Load()), players))
+}
+
+// handlePacket handles a single packet from one of the room's clients.
+func (rh *RoomHandler) handlePacket(packet roomPacket) {
+	clientState := packet.message
+	receivedAt := packet.receivedAt
+
+	rh.updateID.Add(1)
+
+	if clientState.Submessage == state.Submessages.CLIENT_SENDING_UDP_PORT {
+		rh.openSession(clientState, packet.addr, receivedAt)
+		return
+	}
+
+	clientData := clientState.Client
+	sessionID := clientData.ID.UUID
+	id := sessionID.String()
+
+	session, ok := rh.sessions.Touch(sessionID, packet.addr.String(), receivedAt)
+	if !ok {
+		rh.logger.Errorf("[ROOM %s] Client with id '%s' not found at %s", rh.id, id, packet.addr)
+		return
+	}
+
+	// Queued clients can only keep their place or leave the queue.
+	if session.Phase == models.SessionQueued && clientState.Submessage != state.Submessages.CLIENT_DISCONNECTING {
+		return
+	}
+
+	switch clientState.Submessage {
+	case state.Submessages.CLIENT_SENDING_INPUT:
+		rh.logger.Tracef("[ROOM %s] Receiving client input from: %s", rh.id, id)
+
+		rh.handleInputs(clientState, receivedAt)
+	case state.Submessages.CLIENT_SENDING_ROLLBACK_INPUTS:
+		if rh.netcodeMode != state.NetcodeRollback {
+			rh.logger.Errorf("[ROOM %s] Unexpected rollback inputs from client with id '%s'", rh.id, id)
+			return
+		}
+
+		rh.relayRollbackInputs(id, clientState)
+	case state.Submessages.CLIENT_READY:
+		rh.logger.Tracef("[ROOM %s] Handling connection for %s", rh.id, id)
+		rh.handleConnection(session, clientState, receivedAt)
+	case state.Submessages.CLIENT_REQUESTING_UPDATE_ID:
+		requestedUpdateID := clientData.UpdateID
+
+		prevState, ok := rh.updates[requestedUpdateID]
+
+		if !ok {
+			rh.logger.Errorf("[ROOM %s] Requested update with id '%d' not found", rh.id, requestedUpdateID)
+		}
+
+		prevState.SetAsResending()
+
+		if !rh.outbox.Send(id, prevState) {
+			rh.logger.Errorf("[ROOM %s] Could not resend update with id '%d' to client with id '%s'", rh.id, requestedUpdateID, id)
+		}
+	case state.Submessages.CLIENT_DISCONNECTING:
+		if session, ok := rh.sessions.Close(sessionID); ok {
+			rh.endSession(session)
+		}
+
+		rh.logger.Infof("[ROOM %s] Disconnected from client with id: %s", rh.id, id)
+	case state.Submessages.CLIENT_READYING_UP:
+		if rh.serverState.SetReady(id, clientData.Ready) {
+			rh.broadcastMatch()
+			rh.advanceMatch(receivedAt)
+		}
+	case state.Submessages.CLIENT_HAS_FINISHED_LEVEL:
+		// Otherwise the server decides for itself when a player finishes, see handleInput.
+		if rh.netcodeMode != state.NetcodeRollback {
+			rh.logger.Debugf("[ROOM %s] Ignored client with id '%s' saying that it finished", rh.id, id)
+			return
+		}
+
+		rh.reachExit(id, receivedAt, receivedAt)
+	case state.Submessages.CLIENT_KEEPING_ALIVE:
+		// The session has already been kept alive.
+	}
+
+	rh.disconnectFallenBehind()
+
+	rh.updates[rh.updateID.Load()] = rh.serverState.Copy()
+	rh.resolveTouches(receivedAt)
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"fyp/common/ctypes/state"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+	"fyp/common/utils/logging"
+	"fyp/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// joinTicketLifetime is how long a client has to connect over UDP once it has joined a room.
+const joinTicketLifetime = 10 * time.Second
+
+// roomSweepInterval is how often empty rooms are looked for.
+const roomSweepInterval = time.Second
+
+/*
+RoomManager hosts every room on the server. Clients list, create and join rooms over TCP,
+and are given a ticket that their UDP connection is let into the room with. The default
+room is always there, and is where clients without a ticket go. Every other room is
+closed once it has been empty for long enough.
+*/
+type RoomManager struct {
+	logger      *logging.Logger
+	physics     simulation.Physics
+	netcodeMode state.NetcodeMode
+	// defaults is the default room's config. Created rooms are given its queue size, idle
+	// timeout and pickups, its map and game mode unless they pick their own, and can't
+	// have more players than it.
+	defaults     models.RoomConfig
+	maxRooms     int
+	emptyTimeout time.Duration
+	tickets      *models.JoinTickets
+	closeChannel <-chan any
+
+	mutex       sync.RWMutex
+	rooms       map[string]*RoomHandler
+	defaultRoom *RoomHandler
+	// clients is which room every client with a session is in, keyed by client ID.
+	clients map[uuid.UUID]*RoomHandler
+}
+
+var _ Handler = &RoomManager{}
+
+/*
+NewRoomManager creates a manager that hosts at most maxRooms rooms at once, including
+the default room, which it starts with the given config. Other rooms are closed once they
+have been empty for emptyTimeout.
+*/
+func NewRoomManager(logger *logging.Logger, physics simulation.Physics, netcodeMode state.NetcodeMode, defaults models.RoomConfig, maxRooms int, emptyTimeout time.Duration, gracefulCloseChannel <-chan any) (*RoomManager, error) {
+	rm := &RoomManager{
+		logger:       logger,
+		physics:      physics,
+		netcodeMode:  netcodeMode,
+		defaults:     defaults,
+		maxRooms:     maxRooms,
+		emptyTimeout: emptyTimeout,
+		tickets:      models.NewJoinTickets(joinTicketLifetime),
+		closeChannel: gracefulCloseChannel,
+		rooms:        make(map[string]*RoomHandler),
+		clients:      make(map[uuid.UUID]*RoomHandler),
+	}
+
+	defaults.Persistent = true
+
+	room, err := rm.open(defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	rm.defaultRoom = room
+
+	return rm, nil
+}
+
+// Default returns the default room.
+func (rm *RoomManager) Default() *RoomHandler {
+	return rm.defaultRoom
+}
+
+// List describes every room that isn't private, by name.
+func (rm *RoomManager) List() []state.RoomInfo {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	rooms := make([]state.RoomInfo, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		if !room.config.Private {
+			rooms = append(rooms, room.Info())
+		}
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Name != rooms[j].Name {
+			return rooms[i].Name < rooms[j].Name
+		}
+
+		return rooms[i].ID < rooms[j].ID
+	})
+
+	return rooms
+}
+
+/*
+Create starts a room with the given settings, and joins it. It returns the new room, and
+the ticket that the creator's UDP connection is let into it with.
+*/
+func (rm *RoomManager) Create(settings state.RoomSettings, now time.Time) (state.RoomInfo, string, error) {
+	config := models.RoomConfig{
+		Name:        settings.Name,
+		Private:     settings.Private,
+		Password:    settings.Password,
+		MaxPlayers:  settings.MaxPlayers,
+		QueueSize:   rm.defaults.QueueSize,
+		IdleTimeout: rm.defaults.IdleTimeout,
+		MapPath:     rm.defaults.MapPath,
+		Mode:        settings.Mode,
+		Pickups:     rm.defaults.Pickups,
+	}
+
+	if config.Mode == "" {
+		config.Mode = rm.defaults.Mode
+	}
+
+	if config.MaxPlayers == 0 {
+		config.MaxPlayers = rm.defaults.MaxPlayers
+	} else if config.MaxPlayers > rm.defaults.MaxPlayers {
+		return state.RoomInfo{}, "", fmt.Errorf("rooms can have at most %d players, asked for %d", rm.defaults.MaxPlayers, config.MaxPlayers)
+	}
+
+	if settings.Map != "" {
+		path, err := maps.PathOf(settings.Map)
+		if err != nil {
+			return state.RoomInfo{}, "", err
+		}
+
+		config.MapPath = path
+	}
+
+	room, err := rm.open(config)
+	if err != nil {
+		return state.RoomInfo{}, "", err
+	}
+
+	rm.logger.Infof("[ROOMS] Created room %s (%q) on %s, playing %s, for up to %d players", room.id, config.Name, maps.NameOf(config.MapPath), config.Mode, config.MaxPlayers)
+
+	return rm.Join(room.id, config.Password, now)
+}
+
+/*
+Join checks that the room exists and that the password is right, and returns the room
+along with a ticket that the client's UDP connection is let into it with. An empty room
+ID joins the default room. Whether there is space in the room is only checked once the
+client connects, as the room queues clients once it is full.
+*/
+func (rm *RoomManager) Join(roomID, password string, now time.Time) (state.RoomInfo, string, error) {
+	rm.mutex.RLock()
+	room := rm.defaultRoom
+	if roomID != "" {
+		room = rm.rooms[models.NormaliseRoomCode(roomID)]
+	}
+	rm.mutex.RUnlock()
+
+	if room == nil {
+		return state.RoomInfo{}, "", fmt.Errorf("there is no room with the code %q", roomID)
+	}
+
+	if !room.config.Admits(password) {
+		return state.RoomInfo{}, "", errors.New("wrong password for the room")
+	}
+
+	ticket, err := rm.tickets.Issue(room.id, now)
+	if err != nil {
+		return state.RoomInfo{}, "", err
+	}
+
+	return room.Info(), ticket, nil
+}
+
+/*
+Redeem returns the room that the ticket lets a client into, or the default room if there
+is no ticket. The room counts the client as joining until it has been given a session,
+see RoomHandler.openSession, so it isn't cleaned up in the meantime.
+*/
+func (rm *RoomManager) Redeem(ticket string, now time.Time) (*RoomHandler, error) {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	room := rm.defaultRoom
+
+	if ticket != "" {
+		roomID, ok := rm.tickets.Redeem(ticket, now)
+		if !ok {
+			return nil, errors.New("join ticket is unknown or has expired, join the room again")
+		}
+
+		room, ok = rm.rooms[roomID]
+		if !ok {
+			return nil, errors.New("room has closed")
+		}
+	}
+
+	room.joining.Add(1)
+
+	return room, nil
+}
+
+// RoomOf returns the room that the client with the given ID has a session in.
+func (rm *RoomManager) RoomOf(clientID uuid.UUID) (*RoomHandler, bool) {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	room, ok := rm.clients[clientID]
+
+	return room, ok
+}
+
+func (rm *RoomManager) track(clientID uuid.UUID, room *RoomHandler) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	rm.clients[clientID] = room
+}
+
+func (rm *RoomManager) untrack(clientID uuid.UUID) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	delete(rm.clients, clientID)
+}
+
+/*
+open loads the room's map and starts its tick loop under a new code. It returns an error
+if the config is invalid, or the server already has as many rooms as it can host.
+*/
+func (rm *RoomManager) open(config models.RoomConfig) (*RoomHandler, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	mode, err := models.NewGameMode(config.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	world, err := maps.LoadMapFromFile(config.MapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	if len(rm.rooms) >= rm.maxRooms {
+		return nil, fmt.Errorf("server is already hosting as many rooms as it can (%d)", rm.maxRooms)
+	}
+
+	var id string
+	for id == "" || rm.rooms[id] != nil {
+		if id, err = models.NewRoomCode(); err != nil {
+			return nil, err
+		}
+	}
+
+	room := newRoomHandler(id, config, world, mode, rm)
+	rm.rooms[id] = room
+
+	go room.run()
+
+	return room, nil
+}
+
+/*
+removeEmptyRooms closes every room that has had nobody in it, or on their way into it,
+for at least emptyTimeout. The default room is never closed.
+*/
+func (rm *RoomManager) removeEmptyRooms(now time.Time) {
+	rm.mutex.Lock()
+	defer rm.mutex.Unlock()
+
+	for id, room := range rm.rooms {
+		if room.config.Persistent {
+			continue
+		}
+
+		if room.sessions.Len() > 0 || room.joining.Load() > 0 || rm.tickets.Pending(id, now) {
+			room.emptySince = time.Time{}
+			continue
+		}
+
+		if room.emptySince.IsZero() {
+			room.emptySince = now
+			continue
+		}
+
+		if now.Sub(room.emptySince) < rm.emptyTimeout {
+			continue
+		}
+
+		delete(rm.rooms, id)
+		room.close()
+
+		rm.logger.Infof("[ROOMS] Removed room %s (%q), as it was empty since %s", id, room.config.Name, room.emptySince.Format(time.StampMilli))
+	}
+}
+
+/*
+Handle cleans up empty rooms until the server is closed, and then closes every room and
+waits for their tick loops to stop.
+*/
+func (rm *RoomManager) Handle() error {
+	rm.logger.Infof("Started room manager, with default room %s (%q)", rm.defaultRoom.id, rm.defaults.Name)
+
+	ticker := time.NewTicker(roomSweepInterval)
+	defer ticker.Stop()
+
+outer:
+	for {
+		select {
+		case now := <-ticker.C:
+			rm.removeEmptyRooms(now)
+		case <-rm.closeChannel:
+			break outer
+		}
+	}
+
+	rm.logger.Info("[ROOMS] Stopping...")
+
+	rm.mutex.Lock()
+	rooms := make([]*RoomHandler, 0, len(rm.rooms))
+	for id, room := range rm.rooms {
+		rooms = append(rooms, room)
+		delete(rm.rooms, id)
+	}
+	rm.mutex.Unlock()
+
+	for _, room := range rooms {
+		room.close()
+		<-room.done
+	}
+
+	rm.logger.Warn("[ROOMS] Closed")
+	return nil
+}
@@ -5,39 +5,54 @@ import (
 	"fyp/internal/models"
 )
 
+// How many events the state handler can fall behind by before the oldest are dropped.
+const stateHandlerQueueSize = 1024
+
 type StateHandler struct {
-	logger        *logging.Logger
-	serverState   *models.ServerState
-	updateChannel <-chan string
-	closeChannel  <-chan any
-	exitChannel   chan bool
+	logger       *logging.Logger
+	roomID       string
+	serverState  *models.ServerState
+	events       *models.Subscription
+	closeChannel <-chan any
 }
 
-func NewStateHandler(logger *logging.Logger, serverState *models.ServerState, updateChannel <-chan string, gracefulCloseChannel <-chan any) *StateHandler {
+func NewStateHandler(logger *logging.Logger, roomID string, serverState *models.ServerState, gracefulCloseChannel <-chan any) *StateHandler {
 	return &StateHandler{
-		logger:        logger,
-		serverState:   serverState,
-		updateChannel: updateChannel,
-		closeChannel:  gracefulCloseChannel,
-		exitChannel:   make(chan bool),
+		logger:       logger,
+		roomID:       roomID,
+		serverState:  serverState,
+		events:       serverState.Events().Subscribe("state-handler", stateHandlerQueueSize, models.DropOldest),
+		closeChannel: gracefulCloseChannel,
 	}
 }
 
+/*
+Handle logs every change to the room's server state until the room is closed. The state
+handler is only one of the subscribers to the server state's events, so falling behind
+only loses its own log lines.
+*/
 func (sh *StateHandler) Handle() error {
 	go func() {
 		<-sh.closeChannel
-		sh.logger.Info("[STATE-HANDLER] Stopping...")
-		sh.exitChannel <- true
+		sh.logger.Infof("[STATE-HANDLER: %s] Stopping...", sh.roomID)
+		sh.events.Close()
 	}()
 
-	for !<-sh.exitChannel {
-		select {
-		case message := <-sh.updateChannel:
-			sh.logger.Infof("[STATE-HANDLER] State updated: %s", message)
+	for event := range sh.events.Events {
+		switch event := event.(type) {
+		case models.PlayerMoved:
+			// Players are simulated many times a second, so these are only worth seeing
+			// when tracing.
+			sh.logger.Tracef("[STATE-HANDLER: %s] Version %d: %s", sh.roomID, event.Version, event)
 		default:
+			sh.logger.Infof("[STATE-HANDLER: %s] Version %d: %s", sh.roomID, event.Meta().Version, event)
 		}
 	}
 
-	sh.logger.Info("[STATE-HANDLER] Stopped.")
+	if dropped := sh.events.Dropped(); dropped > 0 {
+		sh.logger.Warnf("[STATE-HANDLER: %s] Dropped %d events while falling behind", sh.roomID, dropped)
+	}
+
+	sh.logger.Infof("[STATE-HANDLER: %s] Stopped.", sh.roomID)
 	return nil
 }
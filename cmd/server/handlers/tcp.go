@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net"
 	"strings"
+	"time"
 
 	"fyp/common/ctypes/state"
 	"fyp/common/utils/logging"
@@ -14,7 +17,7 @@ import (
 type TCPHandler struct {
 	Handler
 
-	serverState    *models.ServerState
+	rooms          *RoomManager
 	logger         *logging.Logger
 	connectionsMap *models.ConnectionsMap[state.TCPConnection]
 	socket         *state.TCPSocketListener
@@ -22,10 +25,10 @@ type TCPHandler struct {
 	closeChannel   <-chan any
 }
 
-func NewTCPHandler(logger *logging.Logger, serverState *models.ServerState, socket *net.TCPListener, tcpPort int, gracefulCloseChannel <-chan any) *TCPHandler {
+func NewTCPHandler(logger *logging.Logger, rooms *RoomManager, socket *net.TCPListener, tcpPort int, gracefulCloseChannel <-chan any) *TCPHandler {
 	return &TCPHandler{
 		logger:         logger,
-		serverState:    serverState,
+		rooms:          rooms,
 		connectionsMap: models.NewConnectionsMap[state.TCPConnection](),
 		socket:         typedsockets.NewTypedTCPSocketListener[state.State](socket),
 		port:           tcpPort,
@@ -60,6 +63,8 @@ func (th *TCPHandler) Handle() error {
 
 			th.logger.Infof("[TCP] Connected with %s", conn.RemoteAddr())
 			th.connectionsMap.UpdateConnection(conn.RemoteAddr().String(), conn)
+
+			go th.handleConnection(conn)
 		}
 	}()
 
@@ -81,3 +86,73 @@ func (th *TCPHandler) Handle() error {
 
 	return nil
 }
+
+/*
+handleConnection reads messages from a single client's TCP connection until the
+connection is closed.
+*/
+func (th *TCPHandler) handleConnection(conn *state.TCPConnection) {
+	id := conn.RemoteAddr().String()
+
+	for {
+		var message state.State
+
+		_, err := conn.ReadFrom(&message)
+		receivedAt := time.Now()
+
+		if err != nil {
+			if strings.Contains(err.Error(), "could not unmarshal") {
+				th.logger.Errorf("[TCP] Could not read from %s: %s", id, err.Error())
+				continue
+			}
+
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				th.logger.Errorf("[TCP] Connection with %s failed: %s", id, err.Error())
+			}
+
+			th.connectionsMap.DeleteConnection(id)
+			th.logger.Infof("[TCP] Disconnected from %s", id)
+
+			return
+		}
+
+		switch message.Submessage {
+		case state.Submessages.CLIENT_REQUESTING_TIME_SYNC:
+			if _, err := conn.Write(state.WithServerTimeSync(message, receivedAt)); err != nil {
+				th.logger.Errorf("[TCP] Could not answer time sync request from %s: %s", id, err.Error())
+			}
+		case state.Submessages.CLIENT_LISTING_ROOMS:
+			if _, err := conn.Write(state.WithServerListingRooms(th.rooms.List())); err != nil {
+				th.logger.Errorf("[TCP] Could not list rooms for %s: %s", id, err.Error())
+			}
+		case state.Submessages.CLIENT_CREATING_ROOM:
+			var settings state.RoomSettings
+			if message.Client.RoomSettings != nil {
+				settings = *message.Client.RoomSettings
+			}
+
+			room, ticket, err := th.rooms.Create(settings, receivedAt)
+			th.answerJoin(conn, id, room, ticket, err)
+		case state.Submessages.CLIENT_JOINING_ROOM:
+			room, ticket, err := th.rooms.Join(message.Client.RoomID, message.Client.RoomPassword, receivedAt)
+			th.answerJoin(conn, id, room, ticket, err)
+		default:
+			th.logger.Warnf("[TCP] Unknown or unhandled state submessage from %s: %s", id, message.Submessage.String())
+		}
+	}
+}
+
+// answerJoin tells a client that created or joined a room whether it got in.
+func (th *TCPHandler) answerJoin(conn *state.TCPConnection, id string, room state.RoomInfo, ticket string, err error) {
+	answer := state.WithServerJoiningRoom(room, ticket)
+	if err != nil {
+		th.logger.Infof("[TCP] Refused room request from %s: %s", id, err.Error())
+		answer = state.WithServerRefusingRoom(err.Error())
+	} else {
+		th.logger.Infof("[TCP] %s joined room %s (%q)", id, room.ID, room.Name)
+	}
+
+	if _, err := conn.Write(answer); err != nil {
+		th.logger.Errorf("[TCP] Could not answer room request from %s: %s", id, err.Error())
+	}
+}
@@ -4,101 +4,95 @@ import (
 	"net"
 	"net/netip"
 	"strings"
-	"sync/atomic"
+	"time"
 
-	"fyp/common/ctypes"
 	"fyp/common/ctypes/state"
 	"fyp/common/utils/logging"
-	"fyp/internal/models"
 
 	typedsockets "fyp/common/utils/net/typed-sockets"
-
-	"github.com/google/uuid"
 )
 
+// readInterval is the longest the read loop waits for a packet before checking whether to stop.
+const readInterval = time.Second
+
+/*
+UDPHandler reads every packet sent to the server's UDP socket, and passes it on to the
+room that the sending client is in, see RoomHandler. A new client's first packet is
+passed on to the room that its join ticket is for.
+*/
 type UDPHandler struct {
-	logger          *logging.Logger
-	serverState     *models.ServerState
-	connectionsMap  *models.ConnectionsMap[state.UDPConnection]
-	connectionSlots map[uuid.UUID]int
-	connectedAmount int
-	socket          state.UDPConnection
-	connInfo        netip.AddrPort
-	closeChannel    <-chan any
-	exitChannel     chan bool
-	updateID        atomic.Uint64
-	updates         map[uint64]state.State
+	logger       *logging.Logger
+	rooms        *RoomManager
+	socket       state.UDPConnection
+	connInfo     netip.AddrPort
+	closeChannel <-chan any
+	exitChannel  chan bool
 }
 
 var _ Handler = &UDPHandler{}
 
-func NewUDPHandler(logger *logging.Logger, serverState *models.ServerState, socket *net.UDPConn, udpHost *net.UDPAddr, udpPort int, gracefulCloseChannel <-chan any) *UDPHandler {
+func NewUDPHandler(logger *logging.Logger, rooms *RoomManager, socket *net.UDPConn, udpHost *net.UDPAddr, udpPort int, gracefulCloseChannel <-chan any) *UDPHandler {
 	return &UDPHandler{
-		logger:          logger,
-		serverState:     serverState,
-		connectionsMap:  models.NewConnectionsMap[state.UDPConnection](),
-		socket:          typedsockets.NewUDPTypedConnection[state.State](socket),
-		connInfo:        netip.AddrPortFrom(udpHost.AddrPort().Addr(), uint16(udpPort)),
-		closeChannel:    gracefulCloseChannel,
-		exitChannel:     make(chan bool),
-		connectionSlots: make(map[uuid.UUID]int),
-		updates:         make(map[uint64]state.State),
+		logger:       logger,
+		rooms:        rooms,
+		socket:       typedsockets.NewUDPTypedConnection[state.State](socket),
+		connInfo:     netip.AddrPortFrom(udpHost.AddrPort().Addr(), uint16(udpPort)),
+		closeChannel: gracefulCloseChannel,
+		exitChannel:  make(chan bool),
 	}
 }
 
-func (uh *UDPHandler) handleDisconnection(id, name string) {
-	if !uh.serverState.ContainsPlayer(name) {
+/*
+refuse tells a client that sent its UDP port why it can't join, when it never got as far
+as a room.
+*/
+func (uh *UDPHandler) refuse(addr net.Addr, clientState state.State, reason string) {
+	uh.logger.Warnf("[UDP] Refused client at %s: %s", addr, reason)
+
+	portColonIndex := strings.LastIndex(addr.String(), ":")
+	clientIP := addr.String()[:portColonIndex]
+
+	clientConn, err := typedsockets.DialUDP[state.State](clientIP, clientState.Client.UDPPort)
+	if err != nil {
+		uh.logger.Errorf("[UDP] Could not connect to client at '%s:%s'", clientIP, clientState.Client.UDPPort)
 		return
 	}
+	defer clientConn.Close()
 
-	uh.connectionsMap.DeleteConnection(id)
-	uh.serverState.RemovePlayer(name)
+	if _, err := clientConn.Write(state.WithServerRefusingConnection(reason)); err != nil {
+		uh.logger.Errorf("[UDP] Could not tell client at %s why it was refused: %s", addr, err.Error())
+	}
+}
 
-	players := uh.serverState.GetPlayers()
+// route passes a packet from a client on to the room that it is in, or is joining.
+func (uh *UDPHandler) route(packet roomPacket) {
+	clientState := packet.message
 
-	for entry := range uh.connectionsMap.Iter() {
-		_, err := entry.Conn.Write(state.WithUpdatedPlayers(int(uh.updateID.Load()), players))
+	if clientState.Submessage == state.Submessages.CLIENT_SENDING_UDP_PORT {
+		room, err := uh.rooms.Redeem(clientState.Client.JoinTicket, packet.receivedAt)
 		if err != nil {
-			uh.logger.Errorf("[UDP: handleDisconnection] Could not update %s's version of currently connected players: %s", name, err.Error())
-			continue
+			uh.refuse(packet.addr, clientState, err.Error())
+			return
 		}
 
-		if len(uh.serverState.GetPlayers()) < 2 {
-			_, err := entry.Conn.Write(state.WithServerMakingPlayerUnableToMove())
-			if err != nil {
-				uh.logger.Errorf("[UDP: handleDisconnection] Could not make player unmovable: %s", err.Error())
-			}
+		if err := room.deliver(packet); err != nil {
+			room.joining.Add(-1)
+			uh.refuse(packet.addr, clientState, err.Error())
 		}
-	}
-}
 
-func (uh *UDPHandler) handleConnection(clientID string, clientState state.State) {
-	if uh.serverState.ContainsPlayer(clientState.Client.Player.Name) {
-		uh.serverState.UpdatePlayer(clientState.Client.Player.Name, clientState.Client.Player.Inner)
-	} else {
-		uh.serverState.AddPlayer(clientState.Client.Player.Name, clientState.Client.Player.Inner)
+		return
 	}
 
-	for entry := range uh.connectionsMap.Iter() {
-		if clientID == entry.ID {
-			continue
-		}
-
-		otherPlayers := uh.serverState.FilterPlayers(func(key string, _ ctypes.Player) bool {
-			return key != entry.ID // we want only ids that aren't the current entry's ID
-		})
+	id := clientState.Client.ID.UUID
 
-		_, err := entry.Conn.Write(state.WithUpdatedPlayers(int(uh.updateID.Load()), otherPlayers))
-		if err != nil {
-			uh.logger.Errorf("[UDP: handleConnection] Could not send other player state: %s", err.Error())
-		}
+	room, ok := uh.rooms.RoomOf(id)
+	if !ok {
+		uh.logger.Errorf("[UDP] Client with id '%s' not found", id)
+		return
+	}
 
-		if len(uh.serverState.GetPlayers()) >= 2 {
-			_, err := entry.Conn.Write(state.WithServerMakingPlayerAbleToMove())
-			if err != nil {
-				uh.logger.Errorf("[UDP: handleConnection] Could not make player movable: %s", err.Error())
-			}
-		}
+	if err := room.deliver(packet); err != nil {
+		uh.logger.Debugf("[UDP] Dropped packet from client with id '%s' for room %s: %s", id, room.id, err.Error())
 	}
 }
 
@@ -113,11 +107,6 @@ func (uh *UDPHandler) Handle() error {
 		uh.exitChannel <- true
 	}()
 
-	clientState := state.Empty()
-	var clientIP string
-	var clientPort string
-	connectedIDs := make(map[uuid.UUID]int)
-
 outer:
 	for {
 
@@ -129,7 +118,16 @@ outer:
 		default:
 		}
 
+		// The read times out every so often, so that the loop notices when to stop even
+		// when nobody is sending anything.
+		uh.socket.SetReadDeadline(time.Now().Add(readInterval))
+
+		// Every packet is handed to a room's tick loop, so each one is read into a State
+		// of its own.
+		clientState := state.Empty()
+
 		size, addr, err := uh.socket.ReadFrom(&clientState)
+		receivedAt := time.Now()
 
 		if addr == nil {
 			continue
@@ -150,96 +148,9 @@ outer:
 			continue
 		}
 
-		uh.updateID.Add(1)
-
 		if clientState.Message == state.Messages.FROM_CLIENT {
-			clientData := clientState.Client
-
-			switch clientState.Submessage {
-			case state.Submessages.CLIENT_SENDING_UDP_PORT:
-				portColonIndex := strings.LastIndex(addr.String(), ":")
-				clientIP = addr.String()[:portColonIndex]
-				clientPort = clientData.UDPPort
-
-				uh.logger.Debugf("[UDP] Initial connection with client at %s: %s", addr, clientState)
-				id, err := uuid.NewRandom()
-				if err != nil {
-					uh.logger.Errorf("[UDP] Could not pre-generate UUID: %s", err)
-				}
-				connectedIDs[id] = uh.connectedAmount
-
-				clientConn, err := typedsockets.DialUDP[state.State](clientIP, clientPort)
-				if err != nil {
-					uh.logger.Errorf("[UDP] Could not connect to client at '%s:%s'", clientIP, clientPort)
-					return err
-				}
-
-				uh.connectionsMap.UpdateConnection(id.String(), clientConn)
-				uh.logger.Infof("[UDP] Connected to client's UDP socket at %s:%s. Client ID: %s", clientIP, clientPort, id)
-
-				_, err = clientConn.Write(state.WithNewClientConnection(id, connectedIDs[id]))
-				if err != nil {
-					uh.logger.Errorf("[UDP] Couldn't send to client: %s", err.Error())
-					return err
-				}
-				uh.logger.Infof("[UDP] Sent initial data to client at %s:%s", clientIP, clientPort)
-
-				continue
-			case state.Submessages.CLIENT_SENDING_LOCAL_DATA:
-				uh.logger.Tracef("[UDP] Receiving client local data from: %s", clientData.ID.UUID.String())
-				fallthrough
-			case state.Submessages.CLIENT_READY:
-				id := clientData.ID.UUID.String()
-
-				if uh.connectionsMap.ContainsConnection(id) {
-					if _, ok := uh.connectionSlots[clientData.ID.UUID]; !ok && uh.connectedAmount <= 4 {
-						uh.connectionSlots[clientData.ID.UUID] = uh.connectedAmount
-						connectedIDs[clientData.ID.UUID] = uh.connectedAmount
-
-						uh.connectedAmount++
-					}
-
-					uh.logger.Tracef("[UDP] Handling connection for %s", id)
-					uh.handleConnection(id, clientState)
-				} else {
-					uh.logger.Errorf("[UDP] Client with id '%s' not found", clientState.Client.ID.UUID)
-					continue
-				}
-			case state.Submessages.CLIENT_REQUESTING_UPDATE_ID:
-				id := clientData.ID.UUID.String()
-				requestedUpdateID := clientData.UpdateID
-
-				if !uh.connectionsMap.ContainsConnection(id) {
-					uh.logger.Errorf("[UDP] Client with id '%s' not found", id)
-				}
-
-				prevState, ok := uh.updates[requestedUpdateID]
-
-				if !ok {
-					uh.logger.Errorf("[UDP] Requested update with id '%d' not found", requestedUpdateID)
-				}
-
-				prevState.SetAsResending()
-
-				conn := uh.connectionsMap.GetConnection(id)
-				_, err := conn.Write(prevState)
-				if err != nil {
-					uh.logger.Errorf("[UDP] Could not resend update with id '%d': %s", requestedUpdateID, err)
-				}
-			case state.Submessages.CLIENT_DISCONNECTING:
-				id := clientData.ID.UUID.String()
-				name := clientData.Player.Name
-
-				uh.connectedAmount--
-				delete(uh.connectionSlots, clientData.ID.UUID)
-
-				uh.handleDisconnection(id, name)
-
-				uh.logger.Infof("[UDP] Disconnected from client with id: %s", id)
-			}
+			uh.route(roomPacket{message: clientState, addr: addr, receivedAt: receivedAt})
 		}
-
-		uh.updates[uh.updateID.Load()] = uh.serverState.Copy()
 	}
 
 	uh.logger.Warn("[UDP] Closed")
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"fyp/common/ctypes/state"
+	"fyp/common/utils/logging"
+
+	"github.com/google/uuid"
+)
+
+// testRoom returns a room that packets can be delivered to, without a tick loop running it.
+func testRoom(id string) *RoomHandler {
+	return &RoomHandler{
+		id:    id,
+		inbox: make(chan roomPacket, roomInboxSize),
+		done:  make(chan struct{}),
+	}
+}
+
+/*
+TestStalledRoomDoesNotHoldUpOthers checks that a room whose tick loop has stopped taking
+packets only loses its own packets, and every other room keeps getting theirs.
+*/
+func TestStalledRoomDoesNotHoldUpOthers(t *testing.T) {
+	stalled := testRoom("stalled")
+	running := testRoom("running")
+
+	stalledClient := uuid.New()
+	runningClient := uuid.New()
+
+	manager := &RoomManager{
+		clients: map[uuid.UUID]*RoomHandler{stalledClient: stalled, runningClient: running},
+	}
+	handler := &UDPHandler{logger: logging.NewServer(), rooms: manager}
+
+	packetsEach := 4 * roomInboxSize
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9000}
+
+	packet := func(clientID uuid.UUID) roomPacket {
+		message := state.WithPlayerInputs(uuid.NullUUID{UUID: clientID, Valid: true}, nil)
+
+		return roomPacket{message: message, addr: addr, receivedAt: time.Now()}
+	}
+
+	routed := make(chan struct{})
+
+	go func() {
+		defer close(routed)
+
+		for range packetsEach {
+			handler.route(packet(stalledClient))
+			handler.route(packet(runningClient))
+
+			// The running room's tick loop keeps up, so gets every packet.
+			select {
+			case <-running.inbox:
+			default:
+				t.Error("a packet for the running room was dropped")
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-routed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("routing packets was held up by the stalled room")
+	}
+
+	if queued := len(stalled.inbox); queued != roomInboxSize {
+		t.Errorf("the stalled room has %d packets waiting, want %d", queued, roomInboxSize)
+	}
+
+	if dropped, want := stalled.dropped.Load(), uint64(packetsEach-roomInboxSize); dropped != want {
+		t.Errorf("the stalled room dropped %d packets, want %d", dropped, want)
+	}
+
+	if dropped := running.dropped.Load(); dropped != 0 {
+		t.Errorf("the running room dropped %d packets, want none", dropped)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"fyp/cmd/server/handlers"
+	"fyp/common/ctypes/state"
+	"fyp/common/maps"
+	"fyp/common/simulation"
 	"fyp/common/utils/env"
 	"fyp/common/utils/logging"
 	"fyp/internal/models"
@@ -14,6 +18,24 @@ import (
 
 var log = logging.NewServer()
 
+// How long a client can go without sending anything before its session is evicted.
+const defaultIdleTimeout = 10 * time.Second
+
+// How many players can be in a room at once. Every player gets a colour of its own.
+const defaultMaxPlayers = 4
+
+// How many clients can wait for a slot once a room is full, before any more are refused.
+const defaultJoinQueueSize = 8
+
+// How many rooms the server can host at once, including the default room.
+const defaultMaxRooms = 16
+
+// How long a room that a client created can be empty for before it is cleaned up.
+const defaultRoomEmptyTimeout = 30 * time.Second
+
+// The name of the room that clients join unless they pick another one.
+const defaultRoomName = "Default"
+
 // We use this later in the main function to run the UDP and TCP handlers parallel.
 func makeParallel(handles ...handlers.Handler) {
 	var group sync.WaitGroup
@@ -38,7 +60,6 @@ func main() {
 	}
 
 	var tcpPortStr, udpPortStr string
-	serverState, serverStateUpdatedChannel := models.NewServerState()
 	gracefulCloseChannel := make(chan any)
 
 	if _p, isPresent := os.LookupEnv("TCP_PORT"); isPresent {
@@ -63,6 +84,120 @@ func main() {
 		return
 	}
 
+	mapPath := maps.DefaultPath
+	if _p, isPresent := os.LookupEnv("MAP_PATH"); isPresent {
+		mapPath = _p
+	}
+	physics := simulation.DefaultPhysics()
+	physicsPath, isPresent := os.LookupEnv("PHYSICS_PATH")
+	if !isPresent {
+		physicsPath = simulation.DefaultPhysicsPath
+	}
+	if _, err := os.Stat(physicsPath); err == nil || isPresent {
+		physics, err = simulation.LoadPhysics(physicsPath)
+		if err != nil {
+			log.Errorf("Could not load physics: %s", err.Error())
+			return
+		}
+	}
+
+	pickups := models.DefaultPickups()
+	pickupsPath, isPresent := os.LookupEnv("PICKUPS_PATH")
+	if !isPresent {
+		pickupsPath = models.DefaultPickupsPath
+	}
+	if _, err := os.Stat(pickupsPath); err == nil || isPresent {
+		pickups, err = models.LoadPickups(pickupsPath)
+		if err != nil {
+			log.Errorf("Could not load pickups: %s", err.Error())
+			return
+		}
+	}
+
+	netcodeMode := state.NetcodeAuthoritative
+	if _p, isPresent := os.LookupEnv("NETCODE_MODE"); isPresent {
+		netcodeMode, err = state.ParseNetcodeMode(_p)
+		if err != nil {
+			log.Errorf("Could not parse NETCODE_MODE value: %s", err.Error())
+			return
+		}
+	}
+
+	idleTimeout := defaultIdleTimeout
+	if _p, isPresent := os.LookupEnv("SESSION_IDLE_TIMEOUT"); isPresent {
+		idleTimeout, err = time.ParseDuration(_p)
+		if err != nil {
+			log.Errorf("Could not parse SESSION_IDLE_TIMEOUT value, expected a duration such as 10s: %s", err.Error())
+			return
+		}
+	}
+
+	maxPlayers := defaultMaxPlayers
+	if _p, isPresent := os.LookupEnv("MAX_PLAYERS"); isPresent {
+		maxPlayers, err = strconv.Atoi(_p)
+		if err != nil {
+			log.Errorf("Could not parse MAX_PLAYERS value, expected a value convertable to an integer: %s", err.Error())
+			return
+		}
+
+		if maxPlayers < 1 || maxPlayers > models.MaxRoomPlayers {
+			log.Errorf("MAX_PLAYERS must be between 1 and %d, got %d", models.MaxRoomPlayers, maxPlayers)
+			return
+		}
+	}
+
+	joinQueueSize := defaultJoinQueueSize
+	if _p, isPresent := os.LookupEnv("JOIN_QUEUE_SIZE"); isPresent {
+		joinQueueSize, err = strconv.Atoi(_p)
+		if err != nil || joinQueueSize < 0 {
+			log.Errorf("Could not parse JOIN_QUEUE_SIZE value, expected a whole number, where 0 refuses every client once the server is full: %q", _p)
+			return
+		}
+	}
+
+	maxRooms := defaultMaxRooms
+	if _p, isPresent := os.LookupEnv("MAX_ROOMS"); isPresent {
+		maxRooms, err = strconv.Atoi(_p)
+		if err != nil || maxRooms < 1 {
+			log.Errorf("Could not parse MAX_ROOMS value, expected a whole number of at least 1: %q", _p)
+			return
+		}
+	}
+
+	gameMode := models.GameModeRace
+	if _p, isPresent := os.LookupEnv("GAME_MODE"); isPresent {
+		gameMode = models.GameModeName(_p)
+		if _, err := models.NewGameMode(gameMode); err != nil {
+			log.Errorf("Could not parse GAME_MODE value: %s", err.Error())
+			return
+		}
+	}
+
+	roomEmptyTimeout := defaultRoomEmptyTimeout
+	if _p, isPresent := os.LookupEnv("ROOM_EMPTY_TIMEOUT"); isPresent {
+		roomEmptyTimeout, err = time.ParseDuration(_p)
+		if err != nil {
+			log.Errorf("Could not parse ROOM_EMPTY_TIMEOUT value, expected a duration such as 30s: %s", err.Error())
+			return
+		}
+	}
+
+	defaultRoom := models.RoomConfig{
+		Name:        defaultRoomName,
+		MaxPlayers:  maxPlayers,
+		QueueSize:   joinQueueSize,
+		IdleTimeout: idleTimeout,
+		MapPath:     mapPath,
+		Mode:        gameMode,
+		Pickups:     pickups,
+	}
+
+	rooms, err := handlers.NewRoomManager(log, physics, netcodeMode, defaultRoom, maxRooms, roomEmptyTimeout, gracefulCloseChannel)
+	if err != nil {
+		log.Errorf("Could not start the default room: %s", err.Error())
+		return
+	}
+
 	tcpSocket, err := net.ListenTCP(
 		"tcp",
 		&net.TCPAddr{IP: net.IPv4(0, 0, 0, 0), Port: tcpPort},
@@ -80,10 +215,9 @@ func main() {
 		return
 	}
 
-	tcpHandler := handlers.NewTCPHandler(log, serverState, tcpSocket, tcpPort, gracefulCloseChannel)
-	udpHandler := handlers.NewUDPHandler(log, serverState, udpSocket, addr, udpPort, gracefulCloseChannel)
-	stateHandler := handlers.NewStateHandler(log, serverState, serverStateUpdatedChannel, gracefulCloseChannel)
-	handles := []handlers.Handler{tcpHandler, udpHandler, stateHandler}
+	tcpHandler := handlers.NewTCPHandler(log, rooms, tcpSocket, tcpPort, gracefulCloseChannel)
+	udpHandler := handlers.NewUDPHandler(log, rooms, udpSocket, addr, udpPort, gracefulCloseChannel)
+	handles := []handlers.Handler{tcpHandler, udpHandler, rooms}
 
 	signaler := handlers.NewSignalHandler(log, &handles, gracefulCloseChannel)
 
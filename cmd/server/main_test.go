@@ -0,0 +1,49 @@
+package main_test
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// graphicsDeps matches packages that need a display or a sound card.
+var graphicsDeps = regexp.MustCompile(`ebiten|oto|^fyp/resources`)
+
+// headlessEnv builds for a headless Linux box, which has no cgo toolchain.
+func headlessEnv() []string {
+	return append(os.Environ(), "GOOS=linux", "CGO_ENABLED=0")
+}
+
+/*
+TestHeadlessBuild checks that the dedicated server still builds without cgo, and doesn't
+import ebiten or anything else that needs a display or a sound card, so that it can run on
+headless Linux boxes.
+*/
+func TestHeadlessBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building the server is slow")
+	}
+
+	build := exec.Command("go", "build", "-o", os.DevNull, ".")
+	build.Env = headlessEnv()
+
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("cmd/server no longer builds with CGO_ENABLED=0: %v\n%s", err, output)
+	}
+
+	list := exec.Command("go", "list", "-deps", ".")
+	list.Env = headlessEnv()
+
+	output, err := list.Output()
+	if err != nil {
+		t.Fatalf("couldn't list the dependencies of cmd/server: %v", err)
+	}
+
+	for _, dep := range strings.Fields(string(output)) {
+		if graphicsDeps.MatchString(dep) {
+			t.Errorf("cmd/server depends on %s, which needs graphics or audio", dep)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package ctypes
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+/*
+PlayerColour picks the palette that a player is drawn in. It is sent as a plain integer,
+so every number means the same as it always has: the first four are the original colours
+and 4 is PlayerUnknown. Every colour after that is the next palette in the table. There
+is no last colour: past the end of the table, colours are generated, see
+PlayerColour.Palette.
+*/
+type PlayerColour int
+
+// The colours that players have always had. Every other colour is only data.
+const (
+	PlayerBlue PlayerColour = iota
+	PlayerGreen
+	PlayerPurple
+	PlayerOrange
+	// PlayerUnknown is the colour of a player that hasn't been given one yet.
+	PlayerUnknown
+)
+
+/*
+PlayerPalette is a named set of colours that a player's sprite is drawn in. Each colour
+replaces the colour at the same index in the base palette, see PlayerBasePalette.
+*/
+type PlayerPalette struct {
+	Name    string
+	Colours []color.NRGBA
+}
+
+func singleColour(name string, r, g, b uint8) PlayerPalette {
+	return PlayerPalette{Name: name, Colours: []color.NRGBA{{R: r, G: g, B: b, A: 0xff}}}
+}
+
+/*
+playerPalettes is the table of player colours, in the order they are handed out. The
+first four are the colours of the original player sprites, and the rest are picked to
+stay easy to tell apart from them and from each other.
+*/
+var playerPalettes = []PlayerPalette{
+	singleColour("Blue", 0x24, 0xa6, 0xdf),
+	singleColour("Green", 0x24, 0xdf, 0x55),
+	singleColour("Purple", 0x90, 0x4d, 0xcc),
+	singleColour("Orange", 0xcc, 0xa4, 0x4d),
+	singleColour("Red", 0xdf, 0x24, 0x44),
+	singleColour("Yellow", 0xe6, 0xdc, 0x3c),
+	singleColour("Pink", 0xe6, 0x5c, 0xb4),
+	singleColour("Cyan", 0x3c, 0xe6, 0xdc),
+	singleColour("White", 0xe6, 0xe6, 0xe6),
+	singleColour("Brown", 0x8c, 0x5a, 0x32),
+	singleColour("Lime", 0xa6, 0xe6, 0x3c),
+	singleColour("Navy", 0x32, 0x44, 0xa6),
+	singleColour("Grey", 0x8c, 0x8c, 0x8c),
+	singleColour("Teal", 0x24, 0xa6, 0x8c),
+	singleColour("Maroon", 0x8c, 0x24, 0x40),
+	singleColour("Olive", 0x8c, 0x8c, 0x32),
+}
+
+// PlayerBasePalette is the palette that the player sprites are drawn in on the spritesheet.
+func PlayerBasePalette() PlayerPalette {
+	return playerPalettes[PlayerBlue]
+}
+
+/*
+PlayerColourFromInt returns the colour at the given index in the order that colours are
+handed out, skipping over PlayerUnknown, or PlayerUnknown if the index is negative.
+*/
+func PlayerColourFromInt(i int) PlayerColour {
+	switch {
+	case i < 0:
+		return PlayerUnknown
+	case i < int(PlayerUnknown):
+		return PlayerColour(i)
+	default:
+		return PlayerColour(i + 1)
+	}
+}
+
+// paletteIndex returns where the colour's palette is in the table, or false for PlayerUnknown.
+func (colour PlayerColour) paletteIndex() (int, bool) {
+	switch {
+	case colour < 0 || colour == PlayerUnknown:
+		return 0, false
+	case colour < PlayerUnknown:
+		return int(colour), true
+	default:
+		return int(colour) - 1, true
+	}
+}
+
+/*
+Palette returns the palette for the colour. Colours past the end of the table are
+generated by stepping around the colour wheel by the golden angle, which keeps every
+new hue as far as possible from the ones before it. It returns false for PlayerUnknown,
+or any negative colour.
+*/
+func (colour PlayerColour) Palette() (PlayerPalette, bool) {
+	index, ok := colour.paletteIndex()
+	if !ok {
+		return PlayerPalette{}, false
+	}
+
+	if index < len(playerPalettes) {
+		return playerPalettes[index], true
+	}
+
+	generated := index - len(playerPalettes)
+	hue := math.Mod(float64(generated)*137.508, 360)
+	r, g, b := hsvToRGB(hue, 0.7, 0.85)
+
+	return singleColour(fmt.Sprintf("Colour %d", index+1), r, g, b), true
+}
+
+func (colour PlayerColour) String() string {
+	palette, ok := colour.Palette()
+	if !ok {
+		return "Unknown"
+	}
+
+	return palette.Name
+}
+
+// hsvToRGB converts a hue in degrees, and a saturation and value between 0 and 1.
+func hsvToRGB(hue, saturation, value float64) (uint8, uint8, uint8) {
+	chroma := value * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := value - chroma
+
+	var r, g, b float64
+
+	switch {
+	case hue < 60:
+		r, g, b = chroma, x, 0
+	case hue < 120:
+		r, g, b = x, chroma, 0
+	case hue < 180:
+		r, g, b = 0, chroma, x
+	case hue < 240:
+		r, g, b = 0, x, chroma
+	case hue < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+
+	return uint8(math.Round((r + m) * 255)), uint8(math.Round((g + m) * 255)), uint8(math.Round((b + m) * 255))
+}
@@ -1,5 +1,10 @@
+//go:generate go run ../../internal/gen/generate-tiles/main.go -f ../../internal/gen/generate-tiles/spritesheet_data.yml
+
 /*
 ctypes provides common types that are in use by cmd/client and cmd/server.
+
+Nothing in ctypes depends on ebiten, so that the server can be built without any
+graphics libraries. Drawing these types is done by the sprites package.
 */
 package ctypes
 
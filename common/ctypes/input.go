@@ -0,0 +1,9 @@
+package ctypes
+
+import "fyp/common/simulation"
+
+// PlayerInput and Ground are defined in the simulation package, see simulation.Input.
+type (
+	PlayerInput = simulation.Input
+	Ground      = simulation.Ground
+)
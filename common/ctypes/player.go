@@ -1,311 +1,83 @@
 package ctypes
 
 import (
-	"errors"
 	"fmt"
 	"time"
 
-	"github.com/hajimehoshi/ebiten/v2"
+	"fyp/common/simulation"
 )
 
-type (
-	PlayerFrameState int
-	PlayerColour     int
-	playerDirection  bool
-)
-
-const (
-	frameUpdateTimeMillis = 50
-	deltaX                = 2
-)
-
-// player frame states.
-const (
-	PlayerStanding PlayerFrameState = iota
-	PlayerRunningStage0
-	PlayerRunningStage1
-	PlayerRunningStage2
-	PlayerRunningStage4
-	PlayerJumping
-	PlayerCrouching
-	PlayerMinState = PlayerStanding
-	PlayerMaxState = PlayerCrouching
-)
-
-var playerRunningDecrease = false
-
-func (frame PlayerFrameState) IsRunning() bool {
-	return frame >= PlayerRunningStage0 && frame <= PlayerRunningStage4
-}
-
-func (frame *PlayerFrameState) Standing() {
-	playerRunningDecrease = false
-	*frame = PlayerStanding
-}
-
-func (frame *PlayerFrameState) Crouching() {
-	playerRunningDecrease = false
-	*frame = PlayerCrouching
-}
-
-func (frame *PlayerFrameState) Jumping() {
-	playerRunningDecrease = false
-	*frame = PlayerJumping
-}
-
-func (frame *PlayerFrameState) Running(lastUpdate *time.Time, changedDirection bool) {
-	if time.Since(*lastUpdate).Milliseconds() < frameUpdateTimeMillis && !changedDirection {
-		return
-	}
-
-	if *frame < PlayerRunningStage0 || *frame > PlayerRunningStage4 {
-		*frame = PlayerRunningStage0
-		return
-	}
-
-	if playerRunningDecrease {
-		if *frame > PlayerRunningStage0 {
-			*frame--
-		} else {
-			playerRunningDecrease = false
-		}
-	} else {
-		if *frame < PlayerRunningStage4 {
-			*frame++
-		} else {
-			playerRunningDecrease = true
-		}
-	}
-
-	*lastUpdate = time.Now()
-}
-
-// player colours/types.
-const (
-	PlayerBlue PlayerColour = iota
-	PlayerGreen
-	PlayerPurple
-	PlayerOrange
-	PlayerUnknown
-	PlayerMinColour = PlayerBlue
-	PlayerMaxColour = PlayerOrange
-)
-
-func PlayerColourFromInt(i int) PlayerColour {
-	var colour PlayerColour
-
-	switch i {
-	case 0:
-		colour = PlayerBlue
-	case 1:
-		colour = PlayerGreen
-	case 2:
-		colour = PlayerPurple
-	case 3:
-		colour = PlayerOrange
-	default:
-		colour = PlayerUnknown
-	}
-
-	return colour
-}
-
-func (colour *PlayerColour) String() string {
-	var str string
-
-	switch *colour {
-	case PlayerBlue:
-		str = "Blue"
-	case PlayerGreen:
-		str = "Green"
-	case PlayerPurple:
-		str = "Purple"
-	case PlayerOrange:
-		str = "Orange"
-	default:
-		str = "Unknown"
-	}
-
-	return str
-}
-
-const (
-	playerDirectionLeft  playerDirection = false
-	playerDirectionRight playerDirection = true
-)
-
-func (direction *playerDirection) String() string {
-	var str string
-
-	switch *direction {
-	case playerDirectionLeft:
-		str = "left"
-	case playerDirectionRight:
-		str = "right"
-	default:
-		str = "unknown"
-	}
-
-	return str
-}
-
-func (direction playerDirection) MarshalJSON() ([]byte, error) {
-	var str string
-
-	switch direction {
-	case playerDirectionLeft, playerDirectionRight:
-		str = fmt.Sprintf("%q", direction.String())
-	default:
-		return nil, fmt.Errorf("playerDirection marshal error: invalid input %s", direction.String())
-	}
-
-	return []byte(str), nil
-}
-
-func (direction *playerDirection) UnmarshalJSON(data []byte) error {
-	str := string(data)
-
-	switch str {
-	case "0", "false", "\"left\"":
-		*direction = playerDirectionLeft
-	case "1", "true", "\"right\"":
-		*direction = playerDirectionRight
-	default:
-		return fmt.Errorf("playerDirection unmarshal error: invalid input %s", str)
-	}
-
-	return nil
-}
+/*
+Player is a simulation.Body, along with the colour that it is drawn in and the name that
+is shown above it. Everything that moves the player is in the simulation package, and
+everything that draws it is in the sprites package.
 
+Players are identified by their client's ID, never by their colour or display name.
+*/
 type Player struct {
-	Position             Position        `json:"pos,omitempty"`
-	PlayerSpriteIndex    PlayerColour    `json:"sprite_index,omitempty"`
-	Facing               playerDirection `json:"facing,omitempty"`
-	lastFrameUpdate      time.Time
-	frames               []*ebiten.Image
-	playerAnimationFrame PlayerFrameState
-	geoMatrix            ebiten.GeoM
-	spritesheet          *Spritesheet
-}
-
-func getFrames(spritesheet *Spritesheet, spriteColour PlayerColour) ([]*ebiten.Image, error) {
-	return spritesheet.GetPlayer(spriteColour)
+	simulation.Body
+	PlayerSpriteIndex PlayerColour `json:"sprite_index,omitempty"`
+	DisplayName       string       `json:"display_name,omitempty"`
+	// Score is what the player has collected in pickups this match, as decided by the server.
+	Score int `json:"score,omitempty"`
+	// Health is how many more times the player can be hurt before they die, as decided by the server.
+	Health int `json:"health"`
+	// InvulnerableUntil is when the player can next be hurt, as a Unix time in nanoseconds on
+	// the server's clock, e.g. just after they respawn.
+	InvulnerableUntil int64 `json:"invulnerable_until,omitempty"`
+	// Deaths counts how many times the player has died this match.
+	Deaths int `json:"deaths,omitempty"`
+	// Checkpoint is where the player respawns, once they have reached a checkpoint this match.
+	Checkpoint *Position `json:"checkpoint,omitempty"`
+}
+
+// IsInvulnerable reports whether the player can't be hurt at the given time, on the server's clock.
+func (p *Player) IsInvulnerable(at time.Time) bool {
+	return at.UnixNano() < p.InvulnerableUntil
 }
 
 /*
 NewPlayer creates a new Player struct.
 
-spritesheet is a non-optional parameter, and a nil value being passed in will result in
-an error.
-
-spriteIndex refers to the amount player sprite types available in the tilemap at
-resources/images/tilemap_transparent_packed.png.
+spriteColour has to be a known player colour, see PlayerColour.Palette, or an error is
+returned.
 */
-func NewPlayer(spriteColour PlayerColour, spritesheet *Spritesheet, position Position) (*Player, error) {
-	if spritesheet == nil {
-		return nil, errors.New("spritesheet must not be nil")
-	}
-
-	frames, err := getFrames(spritesheet, spriteColour)
-	if err != nil {
-		return nil, err
+func NewPlayer(spriteColour PlayerColour, position Position) (*Player, error) {
+	if _, ok := spriteColour.Palette(); !ok {
+		return nil, fmt.Errorf("colour must be a known player colour, from %d upwards but not %d, got %d", PlayerBlue, PlayerUnknown, spriteColour)
 	}
 
-	matrix := ebiten.GeoM{}
-	matrix.Translate(position.X, position.Y)
-
 	return &Player{
-		Position:          position,
+		Body:              simulation.NewBody(position),
 		PlayerSpriteIndex: spriteColour,
-		Facing:            playerDirectionRight,
-		frames:            frames,
-		geoMatrix:         matrix,
-		spritesheet:       spritesheet,
 	}, nil
 }
 
-func (p *Player) Update() {
-	var didMove bool
-
-	switch {
-	case ebiten.IsKeyPressed(ebiten.KeyA):
-		p.Position.AffectX(-deltaX)
-		p.geoMatrix.Reset()
-
-		p.geoMatrix.Scale(-1, 1)
-		p.geoMatrix.Translate(SpriteSize, 0)
-
-		if p.Facing == playerDirectionRight {
-			p.Facing = playerDirectionLeft
-			p.playerAnimationFrame.Running(&p.lastFrameUpdate, true)
-		} else {
-			p.playerAnimationFrame.Running(&p.lastFrameUpdate, false)
-		}
-
-		didMove = true
-	case ebiten.IsKeyPressed(ebiten.KeyD):
-		p.Position.AffectX(deltaX)
-		p.geoMatrix.Reset()
-
-		if p.Facing == playerDirectionLeft {
-			p.Facing = playerDirectionRight
-			p.playerAnimationFrame.Running(&p.lastFrameUpdate, true)
-		} else {
-			p.playerAnimationFrame.Running(&p.lastFrameUpdate, false)
-		}
-
-		didMove = true
-	case ebiten.IsKeyPressed(ebiten.KeyS):
-		p.playerAnimationFrame.Crouching()
-	default:
-		p.playerAnimationFrame.Standing()
-	}
-
-	if ebiten.IsKeyPressed(ebiten.KeyW) {
-		p.Position.AffectY(deltaX)
-		p.geoMatrix.Reset()
-
-		p.playerAnimationFrame.Jumping()
-
-		didMove = true
-	}
-
-	if didMove {
-		p.geoMatrix.Translate(p.Position.X, p.Position.Y)
-	}
-}
-
-func (p *Player) TickPhysics() {
-	p.Position.AffectY(-4)
-	p.geoMatrix.Reset()
-
-	p.playerAnimationFrame.Jumping()
-	p.geoMatrix.Translate(p.Position.X, p.Position.Y)
+// Simulate runs a single tick of movement for the player, see simulation.Body.Step.
+func (p *Player) Simulate(input PlayerInput, ground Ground, physics *simulation.Physics) {
+	p.Step(input, ground, physics)
 }
 
-func (p *Player) InitFrames(spritesheet *Spritesheet) {
-	if p.frames == nil {
-		frames, err := getFrames(spritesheet, p.PlayerSpriteIndex)
-		if err != nil {
-			return
-		}
-
-		p.frames = frames
-		p.spritesheet = spritesheet
+/*
+RespawnPoint returns where the player comes back after dying, which is their checkpoint if
+they have reached one, or otherwise the given spawn point.
+*/
+func (p *Player) RespawnPoint(spawn Position) Position {
+	if p.Checkpoint != nil {
+		return *p.Checkpoint
 	}
-}
 
-func (p *Player) RemoteUpdatePosition() {
-	p.geoMatrix.Reset()
-
-	p.geoMatrix.Scale(-1, 1)
-	p.geoMatrix.Translate(SpriteSize, 0)
-	p.geoMatrix.Translate(p.Position.X, p.Position.Y)
+	return spawn
 }
 
-func (p *Player) Draw(screen *ebiten.Image) {
-	if p.frames != nil {
-		op := &ebiten.DrawImageOptions{GeoM: p.geoMatrix}
-		screen.DrawImage(p.frames[p.playerAnimationFrame], op)
-	}
+/*
+SetPosition moves the player straight to the given position at a standstill, as if they
+had just spawned there, e.g. when they are respawned. Their last input is kept, so that
+inputs sent before they moved are still ignored.
+*/
+func (p *Player) SetPosition(position Position) {
+	lastInputSequence := p.LastInputSequence
+
+	p.Body = simulation.NewBody(position)
+	p.LastInputSequence = lastInputSequence
 }
@@ -1,22 +1,10 @@
 package ctypes
 
-type Position struct {
-	X float64 `json:"x"`
-	Y float64 `json:"y"`
-}
-
-func NewPosition(x, y float64) Position {
-	return Position{X: x, Y: y}
-}
+import "fyp/common/simulation"
 
-// AffectX affects x in the rightwards (positive) direction, as ebiten's origin (0,0) is
-// at the top left of the window.
-func (p *Position) AffectX(dx float64) {
-	p.X += dx
-}
+// Position is defined in the simulation package, which can't import anything that draws.
+type Position = simulation.Position
 
-// AffectY affects y in the downwards (negative) direction, as ebiten's origin (0,0) is
-// at the top left of the window.
-func (p *Position) AffectY(dy float64) {
-	p.Y -= dy
+func NewPosition(x, y float64) Position {
+	return simulation.NewPosition(x, y)
 }
@@ -0,0 +1,83 @@
+package sprites
+
+import (
+	"fyp/common/ctypes"
+	"fyp/common/simulation"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+)
+
+/*
+Players holds the animation frames for every player colour that has been drawn, so that
+any ctypes.Player can be drawn without having to keep its own sprites. Frames for a
+colour are made the first time a player in that colour is drawn.
+*/
+type Players struct {
+	sheet  *Spritesheet
+	frames map[ctypes.PlayerColour][]*ebiten.Image
+}
+
+// How many colours are made up front, which covers every player in a full default game.
+const preloadedColours = 4
+
+/*
+NewPlayers loads the frames for the first few player colours from the spritesheet, which
+has to have been loaded already.
+*/
+func NewPlayers(sheet *Spritesheet) (Players, error) {
+	players := Players{sheet: sheet, frames: make(map[ctypes.PlayerColour][]*ebiten.Image)}
+
+	for colour := ctypes.PlayerBlue; colour < preloadedColours; colour++ {
+		if _, err := players.framesFor(colour); err != nil {
+			return players, err
+		}
+	}
+
+	return players, nil
+}
+
+func (p *Players) framesFor(colour ctypes.PlayerColour) ([]*ebiten.Image, error) {
+	if frames, ok := p.frames[colour]; ok {
+		return frames, nil
+	}
+
+	frames, err := p.sheet.GetPlayer(colour)
+	if err != nil {
+		return nil, err
+	}
+
+	p.frames[colour] = frames
+
+	return frames, nil
+}
+
+func (p *Players) Draw(screen *ebiten.Image, player *ctypes.Player) {
+	p.DrawWithOffset(screen, player, 0, 0)
+}
+
+/*
+DrawWithOffset draws the player offset from its position by (dx, dy), without moving the
+player itself, with its display name above it. Players with an unknown colour aren't
+drawn.
+*/
+func (p *Players) DrawWithOffset(screen *ebiten.Image, player *ctypes.Player, dx, dy float64) {
+	frames, err := p.framesFor(player.PlayerSpriteIndex)
+	if err != nil {
+		return
+	}
+
+	op := &ebiten.DrawImageOptions{}
+
+	if player.Facing == simulation.FacingLeft {
+		op.GeoM.Scale(-1, 1)
+		op.GeoM.Translate(SpriteSize, 0)
+	}
+
+	op.GeoM.Translate(player.Position.X+dx, player.Position.Y+dy)
+	screen.DrawImage(frames[player.Animation()], op)
+
+	if player.DisplayName != "" {
+		ebitenutil.DebugPrintAt(screen, player.DisplayName, int(player.Position.X+dx), int(player.Position.Y+dy)-SpriteSize)
+	}
+}
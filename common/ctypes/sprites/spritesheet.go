@@ -0,0 +1,104 @@
+package sprites
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"fyp/common/ctypes"
+	"fyp/resources"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const (
+	SpriteSize  = 16
+	SpriteSizeF = float64(SpriteSize)
+)
+
+// Where the player sprites are on the spritesheet, drawn in ctypes.PlayerBasePalette.
+var playerRow = image.Rect(0, 192, 112, 192+SpriteSize)
+
+type Spritesheet struct {
+	image    *ebiten.Image
+	source   image.Image
+	isLoaded bool
+}
+
+func (sheet *Spritesheet) Load() error {
+	img, source, err := resources.GetImgTilemap()
+	if err != nil {
+		return err
+	}
+
+	sheet.image = img
+	sheet.source = source
+	sheet.isLoaded = true
+
+	return nil
+}
+
+func (sheet *Spritesheet) Sheet() (*ebiten.Image, error) {
+	if !sheet.isLoaded {
+		return nil, errors.New("spritesheet isn't loaded")
+	}
+
+	return sheet.image, nil
+}
+
+/*
+GetPlayer returns the frames of the player sprite in the given colour. The frames are
+recoloured from the base player sprite when they are asked for, see Recolour, so any
+colour with a palette can be drawn.
+*/
+func (sheet *Spritesheet) GetPlayer(colour ctypes.PlayerColour) ([]*ebiten.Image, error) {
+	if !sheet.isLoaded {
+		return nil, errors.New("spritesheet isn't loaded")
+	}
+
+	palette, ok := colour.Palette()
+	if !ok {
+		return nil, fmt.Errorf("unknown player colour %d", colour)
+	}
+
+	row := ebiten.NewImageFromImage(Recolour(sheet.source, playerRow, ctypes.PlayerBasePalette(), palette))
+
+	images := []*ebiten.Image{}
+
+	for x := 0; x < playerRow.Dx(); x += SpriteSize {
+		rect := image.Rect(x, 0, x+SpriteSize, SpriteSize)
+
+		if subimage, ok := row.SubImage(rect).(*ebiten.Image); ok && subimage != nil {
+			images = append(images, subimage)
+		}
+	}
+
+	return images, nil
+}
+
+/*
+Recolour copies the given part of src, replacing every pixel in a colour from the from
+palette with the colour at the same index in the to palette. Every other pixel, e.g. an
+outline, is copied as it is. The copy starts at (0, 0).
+*/
+func Recolour(src image.Image, rect image.Rectangle, from, to ctypes.PlayerPalette) *image.NRGBA {
+	recoloured := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+
+			for i, original := range from.Colours {
+				if pixel == original && i < len(to.Colours) {
+					pixel = to.Colours[i]
+					break
+				}
+			}
+
+			recoloured.SetNRGBA(x-rect.Min.X, y-rect.Min.Y, pixel)
+		}
+	}
+
+	return recoloured
+}
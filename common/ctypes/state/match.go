@@ -0,0 +1,57 @@
+package state
+
+import "fyp/common/ctypes"
+
+// MatchPhase is which part of a match a room is in. Phases always go in the order below.
+type MatchPhase string
+
+const (
+	// MatchWaiting is while there aren't enough players in the room to start a match.
+	MatchWaiting MatchPhase = "waiting"
+	// MatchReadyCheck is while there are enough players, until every one of them is ready.
+	MatchReadyCheck MatchPhase = "ready_check"
+	// MatchCountdown is the countdown to the match, which every client shows in sync.
+	MatchCountdown MatchPhase = "countdown"
+	// MatchPlaying is the match itself, and the only phase in which players can move.
+	MatchPlaying MatchPhase = "playing"
+	// MatchResults shows who finished where, before the room goes back to the ready check.
+	MatchResults MatchPhase = "results"
+)
+
+// GameModeName is the name of a way to play, which a room is created with.
+type GameModeName string
+
+const (
+	// GameModeRace is won by the first player to reach the door.
+	GameModeRace GameModeName = "race"
+	// GameModeCoins is won by whoever scores the most from pickups before the time runs out.
+	GameModeCoins GameModeName = "coins"
+	// GameModeCoop is won by everyone together, if everyone reaches the door in time.
+	GameModeCoop GameModeName = "coop"
+)
+
+/*
+MatchInfo describes a room's match, as it is sent to clients whenever it changes. Players
+are given by the ID of their client.
+*/
+type MatchInfo struct {
+	Phase MatchPhase   `json:"phase"`
+	Mode  GameModeName `json:"mode"`
+	// Round counts the matches that have been started in the room.
+	Round int `json:"round,omitempty"`
+	// EndsAt is when the phase ends, as a Unix time in nanoseconds on the server's clock,
+	// or 0 if it doesn't end at a set time.
+	EndsAt int64 `json:"ends_at,omitempty"`
+	// Ready is every player that is ready for the next match.
+	Ready []string `json:"ready,omitempty"`
+	// Finished is every player that has finished the level, in the order they finished.
+	Finished []string `json:"finished,omitempty"`
+	// Scores is how many points each player has scored in the match, in modes that keep score.
+	Scores map[string]int `json:"scores,omitempty"`
+	// Collected is every pickup that has been collected, and hasn't come back yet.
+	Collected []ctypes.Position `json:"collected,omitempty"`
+	// Standings is every player that placed, best first, once the match is over.
+	Standings []string `json:"standings,omitempty"`
+	// Summary describes how the match ended, once it is over.
+	Summary string `json:"summary,omitempty"`
+}
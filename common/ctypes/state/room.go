@@ -0,0 +1,34 @@
+package state
+
+/*
+RoomSettings is what a client asks for when it creates a room. Anything left as its zero
+value is given the server's default, e.g. a MaxPlayers of 0 or an empty Map.
+*/
+type RoomSettings struct {
+	Name string `json:"name,omitempty"`
+	// Private rooms aren't listed, and can only be joined by their code.
+	Private bool `json:"private,omitempty"`
+	// Password has to be given by everyone who joins the room, if it isn't empty.
+	Password   string `json:"password,omitempty"`
+	MaxPlayers int    `json:"max_players,omitempty"`
+	// Map is the name of one of the maps in resources/maps, without its extension.
+	Map string `json:"map,omitempty"`
+	// Mode is how the room's matches are played.
+	Mode GameModeName `json:"mode,omitempty"`
+}
+
+/*
+RoomInfo describes a room, as it is listed to clients. ID is the code that the room is
+joined by.
+*/
+type RoomInfo struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Map         string       `json:"map"`
+	Mode        GameModeName `json:"mode"`
+	Players     int          `json:"players"`
+	MaxPlayers  int          `json:"max_players"`
+	Queued      int          `json:"queued,omitempty"`
+	Private     bool         `json:"private,omitempty"`
+	HasPassword bool         `json:"has_password,omitempty"`
+}
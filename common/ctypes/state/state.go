@@ -10,10 +10,12 @@ TODO: Finish documentation.
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/goccy/go-json"
 
 	"fyp/common/ctypes"
+	"fyp/common/simulation"
 	typedsockets "fyp/common/utils/net/typed-sockets"
 
 	"github.com/google/uuid"
@@ -21,25 +23,80 @@ import (
 
 var UnknownClientID = uuid.NullUUID{Valid: false}
 
+/*
+NetcodeMode is how player movement is kept in agreement between the clients. The server
+picks the mode, and tells each client when it first connects.
+*/
+type NetcodeMode string
+
+const (
+	// The server simulates every player from their inputs, see ctypes.PlayerInput.
+	NetcodeAuthoritative NetcodeMode = "authoritative"
+
+	// Every client simulates every player, and the server only relays inputs between
+	// them, see the rollback package.
+	NetcodeRollback NetcodeMode = "rollback"
+)
+
+// ParseNetcodeMode parses a NetcodeMode from its string form.
+func ParseNetcodeMode(s string) (NetcodeMode, error) {
+	switch mode := NetcodeMode(s); mode {
+	case NetcodeAuthoritative, NetcodeRollback:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown netcode mode %q, expected %q or %q", s, NetcodeAuthoritative, NetcodeRollback)
+	}
+}
+
 type playerFields struct {
 	Name  string        `json:"name,omitempty"`
 	Inner ctypes.Player `json:"inner,omitempty"`
 }
 
 type clientFields struct {
-	UDPPort         string              `json:"udp_port,omitempty"`
-	ID              uuid.NullUUID       `json:"id,omitempty"`
-	Slot            int                 `json:"slot,omitempty"`
-	InitialPosition ctypes.Position     `json:"initial_position,omitempty"`
-	Colour          ctypes.PlayerColour `json:"player_colour"`
-	Player          playerFields        `json:"player,omitempty"`
-	UpdateID        uint64              `json:"update_id,omitempty"`
+	UDPPort         string               `json:"udp_port,omitempty"`
+	ID              uuid.NullUUID        `json:"id,omitempty"`
+	Slot            int                  `json:"slot,omitempty"`
+	InitialPosition ctypes.Position      `json:"initial_position,omitempty"`
+	Colour          ctypes.PlayerColour  `json:"player_colour"`
+	Player          playerFields         `json:"player,omitempty"`
+	Input           ctypes.PlayerInput   `json:"input,omitempty"`
+	Inputs          []ctypes.PlayerInput `json:"inputs,omitempty"`
+	NetcodeMode     NetcodeMode          `json:"netcode_mode,omitempty"`
+	Physics         *simulation.Physics  `json:"physics,omitempty"`
+	UpdateID        uint64               `json:"update_id,omitempty"`
+	RoomID          string               `json:"room_id,omitempty"`
+	RoomPassword    string               `json:"room_password,omitempty"`
+	RoomSettings    *RoomSettings        `json:"room_settings,omitempty"`
+	JoinTicket      string               `json:"join_ticket,omitempty"`
+	Ready           bool                 `json:"ready,omitempty"`
+	RollbackSession uint64               `json:"rollback_session,omitempty"`
 }
 
 type serverFields struct {
-	Players        map[string]ctypes.Player `json:"players,omitempty"`
-	UpdateID       int                      `json:"update_id,omitempty"`
-	PriorityUpdate bool                     `json:"priority_update,omitempty"`
+	Players         map[string]ctypes.Player        `json:"players,omitempty"`
+	UpdateID        int                             `json:"update_id,omitempty"`
+	PriorityUpdate  bool                            `json:"priority_update,omitempty"`
+	Time            int64                           `json:"time,omitempty"`
+	RelayedInputs   map[string][]ctypes.PlayerInput `json:"relayed_inputs,omitempty"`
+	RollbackSession uint64                          `json:"rollback_session,omitempty"`
+	Reason          string                          `json:"reason,omitempty"`
+	QueuePosition   int                             `json:"queue_position,omitempty"`
+	Rooms           []RoomInfo                      `json:"rooms,omitempty"`
+	Room            *RoomInfo                       `json:"room,omitempty"`
+	JoinTicket      string                          `json:"join_ticket,omitempty"`
+	Match           *MatchInfo                      `json:"match,omitempty"`
+}
+
+/*
+timeSyncFields holds the timestamps of an NTP-style clock synchronisation exchange, as
+Unix times in nanoseconds. ClientSentAt is set by the client, and echoed back by the
+server along with ServerReceivedAt and ServerSentAt.
+*/
+type timeSyncFields struct {
+	ClientSentAt     int64 `json:"client_sent_at,omitempty"`
+	ServerReceivedAt int64 `json:"server_received_at,omitempty"`
+	ServerSentAt     int64 `json:"server_sent_at,omitempty"`
 }
 
 /*
@@ -49,10 +106,11 @@ that the specific (de)serialisation format is changed in the future without chan
 state.State API.
 */
 type State struct {
-	Message    Message      `json:"message"`
-	Submessage Submessage   `json:"sub_message"`
-	Client     clientFields `json:"client,omitempty"`
-	Server     serverFields `json:"server,omitempty"`
+	Message    Message        `json:"message"`
+	Submessage Submessage     `json:"sub_message"`
+	Client     clientFields   `json:"client,omitempty"`
+	Server     serverFields   `json:"server,omitempty"`
+	TimeSync   timeSyncFields `json:"time_sync,omitempty"`
 }
 
 func WithUpdatedPlayers(serverUpdateID int, playersMap map[string]ctypes.Player) State {
@@ -63,36 +121,46 @@ func WithUpdatedPlayers(serverUpdateID int, playersMap map[string]ctypes.Player)
 			UpdateID:       serverUpdateID,
 			Players:        playersMap,
 			PriorityUpdate: true,
+			Time:           time.Now().UnixNano(),
 		},
 	}
 }
 
 /*
-WithUpdatedPlayerState returns a state.State that contains the player data from
-ctypes.Player so that it can be used to update the server's version of this client.
+WithPlayerInputs returns a state.State that contains the client's latest input commands,
+oldest first, so that the server can simulate this client's player with them. Several
+inputs are sent at once so that a lost packet doesn't lose an input, and the server skips
+any that it has already simulated. The latest input is also sent on its own as Input.
 */
-func WithUpdatedPlayerState(clientID uuid.NullUUID, playerState ctypes.Player) State {
-	clientPlayer := playerFields{
-		Name:  playerState.PlayerSpriteIndex.String(),
-		Inner: playerState,
+func WithPlayerInputs(clientID uuid.NullUUID, inputs []ctypes.PlayerInput) State {
+	var latest ctypes.PlayerInput
+	if len(inputs) > 0 {
+		latest = inputs[len(inputs)-1]
 	}
 
 	return State{
 		Message:    Messages.FROM_CLIENT,
-		Submessage: Submessages.CLIENT_SENDING_LOCAL_DATA,
+		Submessage: Submessages.CLIENT_SENDING_INPUT,
 		Client: clientFields{
 			ID:     clientID,
-			Player: clientPlayer,
+			Input:  latest,
+			Inputs: inputs,
 		},
 	}
 }
 
-func WithClientUDPPort(clientUDPPort string) State {
+/*
+WithClientUDPPort returns a state.State that tells the server which port the client
+listens on, along with the ticket for the room that the client joined over TCP, see
+WithServerJoiningRoom. Without a ticket, the client joins the server's default room.
+*/
+func WithClientUDPPort(clientUDPPort, joinTicket string) State {
 	return State{
 		Message:    Messages.FROM_CLIENT,
 		Submessage: Submessages.CLIENT_SENDING_UDP_PORT,
 		Client: clientFields{
-			UDPPort: clientUDPPort,
+			UDPPort:    clientUDPPort,
+			JoinTicket: joinTicket,
 		},
 	}
 }
@@ -113,18 +181,22 @@ func WithServerMakingPlayerUnableToMove() State {
 	}
 }
 
-func WithClientDisconnecting(clientID uuid.NullUUID, playerName string) State {
+func WithClientDisconnecting(clientID uuid.NullUUID) State {
 	return State{
 		Message:    Messages.FROM_CLIENT,
 		Submessage: Submessages.CLIENT_DISCONNECTING,
 		Client: clientFields{
-			ID:     clientID,
-			Player: playerFields{Name: playerName},
+			ID: clientID,
 		},
 	}
 }
 
-func WithNewClientConnection(clientID uuid.UUID, slot int) State {
+/*
+WithNewClientConnection returns a state.State that tells a newly connected client
+everything it needs to join the game, including the physics that it has to simulate
+with.
+*/
+func WithNewClientConnection(clientID uuid.UUID, slot int, netcodeMode NetcodeMode, physics simulation.Physics) State {
 	return State{
 		Message:    Messages.FROM_SERVER,
 		Submessage: Submessages.SERVER_FIRST_CLIENT_CONNECTION_INFORMATION,
@@ -133,25 +205,277 @@ func WithNewClientConnection(clientID uuid.UUID, slot int) State {
 			ID:              uuid.NullUUID{UUID: clientID, Valid: true},
 			InitialPosition: ctypes.NewPosition(100, 100),
 			Colour:          ctypes.PlayerColourFromInt(slot),
+			NetcodeMode:     netcodeMode,
+			Physics:         &physics,
 		},
 		Server: serverFields{PriorityUpdate: true},
 	}
 }
 
-func WithClientReady(clientID uuid.UUID, player ctypes.Player) State {
+/*
+WithClientReady returns a state.State that tells the server that the client is ready to
+join the game as the given player, under the display name that the user picked.
+*/
+func WithClientReady(clientID uuid.UUID, displayName string, player ctypes.Player) State {
 	return State{
 		Message:    Messages.FROM_CLIENT,
 		Submessage: Submessages.CLIENT_READY,
 		Client: clientFields{
 			ID: uuid.NullUUID{UUID: clientID, Valid: true},
 			Player: playerFields{
-				Name:  player.PlayerSpriteIndex.String(),
+				Name:  displayName,
 				Inner: player,
 			},
 		},
 	}
 }
 
+/*
+WithServerRejectingName returns a state.State that tells a client that it can't join the
+game under the display name it picked, and why.
+*/
+func WithServerRejectingName(reason string) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_REJECTING_NAME,
+		Server:     serverFields{PriorityUpdate: true, Reason: reason},
+	}
+}
+
+/*
+WithServerRefusingConnection returns a state.State that tells a client that it can't join
+the game at all, e.g. because the server and its queue are full, and why.
+*/
+func WithServerRefusingConnection(reason string) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_REFUSING_CONNECTION,
+		Server:     serverFields{PriorityUpdate: true, Reason: reason},
+	}
+}
+
+/*
+WithServerQueueingClient returns a state.State that tells a client that the server is
+full, and where it is in the queue to join, starting from 1. The client is sent its
+initial state as usual once it is let in, see WithNewClientConnection.
+*/
+func WithServerQueueingClient(clientID uuid.UUID, position int) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_QUEUEING_CLIENT,
+		Client:     clientFields{ID: uuid.NullUUID{UUID: clientID, Valid: true}},
+		Server:     serverFields{PriorityUpdate: true, QueuePosition: position},
+	}
+}
+
+/*
+WithClientKeepingAlive returns a state.State that only tells the server that the client
+is still there, while it has nothing else to send.
+*/
+func WithClientKeepingAlive(clientID uuid.NullUUID) State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_KEEPING_ALIVE,
+		Client:     clientFields{ID: clientID},
+	}
+}
+
+/*
+WithClientRequestingTimeSync returns a state.State that asks the server for its current
+time, as part of estimating the offset between the client's and the server's clocks.
+*/
+func WithClientRequestingTimeSync(sentAt time.Time) State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_REQUESTING_TIME_SYNC,
+		TimeSync:   timeSyncFields{ClientSentAt: sentAt.UnixNano()},
+	}
+}
+
+/*
+WithServerTimeSync returns a state.State that answers a client's time sync request. The
+time the request was sent by the client is echoed back, so that the client doesn't need
+to keep track of its outstanding requests.
+*/
+func WithServerTimeSync(request State, receivedAt time.Time) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_TIME_SYNC,
+		TimeSync: timeSyncFields{
+			ClientSentAt:     request.TimeSync.ClientSentAt,
+			ServerReceivedAt: receivedAt.UnixNano(),
+			ServerSentAt:     time.Now().UnixNano(),
+		},
+	}
+}
+
+/*
+WithRollbackInputs returns a state.State that contains the client's latest inputs in
+rollback mode, each one tagged with the frame it is for, in the rollback session that
+they are for. Frames start again from 0 in every session, so a frame alone doesn't say
+which session an input belongs to. Several inputs are sent at once so that a lost packet
+doesn't lose an input.
+*/
+func WithRollbackInputs(clientID uuid.NullUUID, session uint64, inputs []ctypes.PlayerInput) State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_SENDING_ROLLBACK_INPUTS,
+		Client: clientFields{
+			ID:              clientID,
+			Inputs:          inputs,
+			RollbackSession: session,
+		},
+	}
+}
+
+/*
+WithRelayedRollbackInputs returns a state.State that passes a client's rollback inputs
+on to the other clients, keyed by the sending client's ID, along with the rollback
+session that they are for.
+*/
+func WithRelayedRollbackInputs(playerID string, session uint64, inputs []ctypes.PlayerInput) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_RELAYING_ROLLBACK_INPUTS,
+		Server: serverFields{
+			RelayedInputs:   map[string][]ctypes.PlayerInput{playerID: inputs},
+			RollbackSession: session,
+		},
+	}
+}
+
+// WithClientListingRooms returns a state.State that asks the server for its public rooms.
+func WithClientListingRooms() State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_LISTING_ROOMS,
+	}
+}
+
+// WithServerListingRooms returns a state.State that lists the server's public rooms.
+func WithServerListingRooms(rooms []RoomInfo) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_LISTING_ROOMS,
+		Server:     serverFields{Rooms: rooms},
+	}
+}
+
+/*
+WithClientCreatingRoom returns a state.State that asks the server to create a room with
+the given settings. The client joins the room that it creates, see WithServerJoiningRoom.
+*/
+func WithClientCreatingRoom(settings RoomSettings) State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_CREATING_ROOM,
+		Client:     clientFields{RoomSettings: &settings},
+	}
+}
+
+/*
+WithClientJoiningRoom returns a state.State that asks to join the room with the given
+code, and password if it has one. An empty code joins the server's default room.
+*/
+func WithClientJoiningRoom(roomID, password string) State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_JOINING_ROOM,
+		Client: clientFields{
+			RoomID:       roomID,
+			RoomPassword: password,
+		},
+	}
+}
+
+/*
+WithServerJoiningRoom returns a state.State that lets a client into a room. The client
+sends the ticket with its UDP port, see WithClientUDPPort, and it can only be used once.
+*/
+func WithServerJoiningRoom(room RoomInfo, joinTicket string) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_JOINING_ROOM,
+		Server: serverFields{
+			Room:       &room,
+			JoinTicket: joinTicket,
+		},
+	}
+}
+
+/*
+WithServerRefusingRoom returns a state.State that tells a client why a room couldn't be
+created or joined, e.g. because the password was wrong.
+*/
+func WithServerRefusingRoom(reason string) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_REFUSING_ROOM,
+		Server:     serverFields{Reason: reason},
+	}
+}
+
+/*
+WithClientReadyingUp returns a state.State that tells the server whether the client's
+player is ready for the next match, see MatchReadyCheck.
+*/
+func WithClientReadyingUp(clientID uuid.NullUUID, ready bool) State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_READYING_UP,
+		Client: clientFields{
+			ID:    clientID,
+			Ready: ready,
+		},
+	}
+}
+
+/*
+WithClientFinishingLevel returns a state.State that tells the server that the client's
+player has reached the open door. The server only takes the client's word for it in
+rollback mode, as otherwise it simulates every player itself.
+*/
+func WithClientFinishingLevel(clientID uuid.NullUUID) State {
+	return State{
+		Message:    Messages.FROM_CLIENT,
+		Submessage: Submessages.CLIENT_HAS_FINISHED_LEVEL,
+		Client:     clientFields{ID: clientID},
+	}
+}
+
+// WithServerUpdatingMatch returns a state.State that tells a client how the match has changed.
+func WithServerUpdatingMatch(match MatchInfo) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_UPDATING_MATCH,
+		Server:     serverFields{PriorityUpdate: true, Match: &match},
+	}
+}
+
+/*
+WithServerPlayersHaveFinished returns a state.State that tells a client that the match is
+over, with every player that finished in the order they finished.
+*/
+func WithServerPlayersHaveFinished(match MatchInfo) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_PLAYERS_HAVE_FINISHED,
+		Server:     serverFields{PriorityUpdate: true, Match: &match},
+	}
+}
+
+/*
+WithServerReachingCheckpoint returns a state.State that tells a client that the player with
+the given ID has reached a checkpoint, see ctypes.Player.Checkpoint. Only that player is sent.
+*/
+func WithServerReachingCheckpoint(id string, player ctypes.Player) State {
+	return State{
+		Message:    Messages.FROM_SERVER,
+		Submessage: Submessages.SERVER_REACHING_CHECKPOINT,
+		Server:     serverFields{PriorityUpdate: true, Players: map[string]ctypes.Player{id: player}},
+	}
+}
+
 func WithServerPing() State {
 	return State{
 		Message:    Messages.FROM_SERVER,
@@ -8,10 +8,17 @@ const (
 	submessage_none submessage = iota
 	client_sending_udp_port
 	client_ready
-	client_sending_local_data
+	client_sending_input
 	client_disconnecting
 	client_requesting_update_id
 	client_has_finished_level
+	client_requesting_time_sync
+	client_sending_rollback_inputs
+	client_keeping_alive
+	client_listing_rooms
+	client_creating_room
+	client_joining_room
+	client_readying_up
 
 	server_ping
 	server_first_client_connection_information
@@ -20,4 +27,14 @@ const (
 	server_this_client_can_move
 	server_this_client_cannot_move
 	server_players_have_finished
+	server_time_sync
+	server_relaying_rollback_inputs
+	server_rejecting_name
+	server_refusing_connection
+	server_queueing_client
+	server_listing_rooms
+	server_joining_room
+	server_refusing_room
+	server_updating_match
+	server_reaching_checkpoint
 )
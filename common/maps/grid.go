@@ -0,0 +1,179 @@
+package maps
+
+import (
+	"image"
+	"math"
+
+	"fyp/common/ctypes/tiles"
+	"fyp/common/simulation"
+)
+
+/*
+grid holds the tile in each cell of a map, so that queries only have to look at the cells
+that they overlap, rather than at every tile in the map. Cells are TileSize pixels
+square, with cell (0, 0) at the top left of the map, and each cell holds at most one tile.
+
+Each cell only holds an index into types, offset by one so that the zero value is an
+empty cell, which keeps large maps small.
+*/
+type grid struct {
+	columns, rows int
+	cells         []uint8
+	types         []tiles.Types
+}
+
+// cellOf returns the column or row that the pixel coordinate v is in.
+func cellOf(v float64) int {
+	return int(math.Floor(v / tileSizeF))
+}
+
+// set places tile in the given cell, growing the grid if the cell is outside of it.
+func (g *grid) set(column, row int, tile tiles.Types) {
+	if column < 0 || row < 0 {
+		return
+	}
+
+	if column >= g.columns || row >= g.rows {
+		g.resize(max(g.columns, column+1), max(g.rows, row+1))
+	}
+
+	g.cells[row*g.columns+column] = g.index(tile)
+}
+
+// index returns the index of tile in types, adding it if it isn't there yet.
+func (g *grid) index(tile tiles.Types) uint8 {
+	for i, t := range g.types {
+		if t == tile {
+			return uint8(i + 1)
+		}
+	}
+
+	g.types = append(g.types, tile)
+
+	return uint8(len(g.types))
+}
+
+func (g *grid) resize(columns, rows int) {
+	cells := make([]uint8, columns*rows)
+
+	for row := 0; row < g.rows; row++ {
+		copy(cells[row*columns:], g.cells[row*g.columns:(row+1)*g.columns])
+	}
+
+	g.columns, g.rows, g.cells = columns, rows, cells
+}
+
+// at returns the tile in the given cell, if there is one.
+func (g *grid) at(column, row int) (tiles.Types, bool) {
+	if column < 0 || row < 0 || column >= g.columns || row >= g.rows {
+		return tiles.Types{}, false
+	}
+
+	index := g.cells[row*g.columns+column]
+	if index == 0 {
+		return tiles.Types{}, false
+	}
+
+	return g.types[index-1], true
+}
+
+/*
+each calls visit for every tile in a cell that rect overlaps, in row order, until visit
+returns false.
+*/
+func (g *grid) each(rect image.Rectangle, visit func(tile PlacedTile) bool) {
+	// Max is exclusive, so a rectangle that ends on a cell boundary doesn't reach into
+	// the next cell.
+	minColumn, minRow := max(0, cellOf(float64(rect.Min.X))), max(0, cellOf(float64(rect.Min.Y)))
+	maxColumn := min(g.columns-1, cellOf(float64(rect.Max.X-1)))
+	maxRow := min(g.rows-1, cellOf(float64(rect.Max.Y-1)))
+
+	for row := minRow; row <= maxRow; row++ {
+		for column := minColumn; column <= maxColumn; column++ {
+			index := g.cells[row*g.columns+column]
+			if index == 0 {
+				continue
+			}
+
+			if !visit(PlacedTile{Type: g.types[index-1], Position: cellPosition(column, row)}) {
+				return
+			}
+		}
+	}
+}
+
+func cellPosition(column, row int) simulation.Position {
+	return simulation.NewPosition(float64(column)*tileSizeF, float64(row)*tileSizeF)
+}
+
+func cellRect(column, row int) image.Rectangle {
+	x, y := column*TileSize, row*TileSize
+
+	return image.Rect(x, y, x+TileSize, y+TileSize)
+}
+
+/*
+raycast walks the cells along the line from (x0, y0) to (x1, y1) in the order that the
+line passes through them, and stops at the first tile that hits returns true for. It
+returns the tile, and the point at which the line enters its cell.
+*/
+func (g *grid) raycast(x0, y0, x1, y1 float64, hits func(tiles.Types) bool) (PlacedTile, simulation.Position, bool) {
+	column, row := cellOf(x0), cellOf(y0)
+	endColumn, endRow := cellOf(x1), cellOf(y1)
+	dx, dy := x1-x0, y1-y0
+
+	// How far along the line, from 0 to 1, the next column and row boundaries are, and
+	// how far apart they are.
+	stepColumn, nextX, deltaX := boundaries(x0, dx)
+	stepRow, nextY, deltaY := boundaries(y0, dy)
+
+	var along float64
+
+	for {
+		if tile, ok := g.at(column, row); ok && hits(tile) {
+			point := simulation.NewPosition(x0+dx*along, y0+dy*along)
+
+			return PlacedTile{Type: tile, Position: cellPosition(column, row)}, point, true
+		}
+
+		if column == endColumn && row == endRow {
+			break
+		}
+
+		// Once the line has left the grid, there is nothing else for it to hit.
+		if (stepColumn > 0 && column >= g.columns) || (stepColumn < 0 && column < 0) ||
+			(stepRow > 0 && row >= g.rows) || (stepRow < 0 && row < 0) {
+			break
+		}
+
+		if nextX < nextY {
+			along, column = nextX, column+stepColumn
+			nextX += deltaX
+		} else {
+			along, row = nextY, row+stepRow
+			nextY += deltaY
+		}
+
+		if along > 1 {
+			break
+		}
+	}
+
+	return PlacedTile{}, simulation.Position{}, false
+}
+
+/*
+boundaries returns the direction to step in along one axis, how far along the line the
+first cell boundary is, and how far along the line each cell is, for a line starting at
+v and moving by d.
+*/
+func boundaries(v, d float64) (step int, next, delta float64) {
+	switch {
+	case d > 0:
+		return 1, ((math.Floor(v/tileSizeF)+1)*tileSizeF - v) / d, tileSizeF / d
+	case d < 0:
+		return -1, (v - math.Floor(v/tileSizeF)*tileSizeF) / -d, tileSizeF / -d
+	default:
+		return 0, math.Inf(1), math.Inf(1)
+	}
+}
@@ -0,0 +1,390 @@
+/*
+maps provides the Map type that is loaded from the ".map" files in resources/maps, and is
+used by both cmd/client and cmd/server. It has no graphics dependencies, so that the
+server can load and simulate maps without a display; cmd/client draws them itself.
+*/
+package maps
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fyp/common/ctypes/tiles"
+	"fyp/common/simulation"
+)
+
+const (
+	maxMapWidth  = 40
+	maxMapHeight = 30
+)
+
+// TileSize is the width and height of every tile, in pixels.
+const TileSize = 16
+
+const tileSizeF = float64(TileSize)
+
+// Directory is where maps are loaded from by name, see PathOf.
+const Directory = "resources/maps"
+
+// Extension is the file extension of every map.
+const Extension = ".map"
+
+// DefaultPath is the path of the map that is loaded when no other map is specified.
+const DefaultPath = "resources/maps/01_start.map"
+
+/*
+Map is a level made of tiles. Every tile's position is kept by type, and in a grid of
+cells, so that collision queries only look at the tiles near to them.
+*/
+type Map struct {
+	positions map[tiles.Types][]simulation.Position
+	grid      grid
+}
+
+/*
+NewMapFromTiles creates a map from tiles that have already been placed, e.g. a map that
+is generated rather than loaded from a file. Tiles have to be placed on the TileSize
+grid, and a tile placed in the same cell as an earlier one replaces it in collision
+queries.
+*/
+func NewMapFromTiles(placed []PlacedTile) *Map {
+	m := &Map{positions: make(map[tiles.Types][]simulation.Position)}
+
+	for _, tile := range placed {
+		m.add(tile.Type, tile.Position)
+	}
+
+	return m
+}
+
+/*
+PathOf returns the path of the map with the given name in Directory, e.g. "01_start". Names
+can only contain letters, digits, underscores and hyphens, so that a name sent by a client
+can never point outside of Directory.
+*/
+func PathOf(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("map name can't be empty")
+	}
+
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			continue
+		}
+
+		return "", fmt.Errorf("map name %q can only contain letters, digits, underscores and hyphens", name)
+	}
+
+	return filepath.Join(Directory, name+Extension), nil
+}
+
+// NameOf returns the name of the map at path, i.e. its file name without the extension.
+func NameOf(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), Extension)
+}
+
+func LoadMapFromFile(path string) (*Map, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not load map from file \"%s\": %w", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not load map from file \"%s\": %w", path, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("could not load map from file \"%s\": not a file", path)
+	}
+
+	var m Map
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not load map from file \"%s\": %w", path, err)
+	}
+
+	m.positions = make(map[tiles.Types][]simulation.Position)
+	m.fillPositions(string(content))
+
+	return &m, nil
+}
+
+func (m *Map) fillPositions(content string) {
+	for line, tileString := range strings.Split(content, "\n") {
+		if line == maxMapHeight {
+			break
+		}
+
+		for i, tileRune := range tileString {
+			if i != 0 && i%maxMapWidth == 0 {
+				line++
+				i = 0
+			}
+
+			x := float64(i) * tileSizeF
+			y := float64(line) * tileSizeF
+			position := simulation.NewPosition(x, y)
+
+			for _, t := range tiles.Typeses.All() {
+				if t.Symbol != string(tileRune) {
+					continue
+				}
+
+				m.add(t, position)
+
+				break
+			}
+		}
+	}
+}
+
+func (m *Map) add(tile tiles.Types, position simulation.Position) {
+	m.positions[tile] = append(m.positions[tile], position)
+	m.grid.set(cellOf(position.X), cellOf(position.Y), tile)
+}
+
+func (m *Map) GetSpawnPoint() (x, y float64) {
+	if positions, ok := m.positions[tiles.Typeses.SPAWNPOINT_TILE]; ok {
+		pos := positions[0]
+
+		return pos.X, pos.Y
+	}
+
+	return 0, 0
+}
+
+/*
+TileAt returns the tile at the point (x, y), if there is one.
+*/
+func (m *Map) TileAt(x, y float64) (PlacedTile, bool) {
+	column, row := cellOf(x), cellOf(y)
+
+	tile, ok := m.grid.at(column, row)
+	if !ok {
+		return PlacedTile{}, false
+	}
+
+	return PlacedTile{Type: tile, Position: cellPosition(column, row)}, true
+}
+
+/*
+TilesIn returns every tile that overlaps rect, in row order.
+*/
+func (m *Map) TilesIn(rect image.Rectangle) []PlacedTile {
+	placed := []PlacedTile{}
+
+	m.grid.each(rect, func(tile PlacedTile) bool {
+		placed = append(placed, tile)
+		return true
+	})
+
+	return placed
+}
+
+/*
+Raycast follows the line from `from` to `to`, and returns the first tile along it that
+hits returns true for, along with the point at which the line reaches that tile. A tile
+that `from` is already inside of is hit at `from`.
+*/
+func (m *Map) Raycast(from, to simulation.Position, hits func(tiles.Types) bool) (PlacedTile, simulation.Position, bool) {
+	return m.grid.raycast(from.X, from.Y, to.X, to.Y, hits)
+}
+
+func (m *Map) checkPlayerRectAgainstTiles(playerRect image.Rectangle, check func(playerRect, tileRect image.Rectangle, tile tiles.Types) bool) (bool, *tiles.Types) {
+	var found *tiles.Types
+
+	m.grid.each(playerRect, func(placed PlacedTile) bool {
+		if !placed.Type.Collidable && !placed.Type.Touchable {
+			return true
+		}
+
+		if check(playerRect, placedRect(placed), placed.Type) {
+			found = &placed.Type
+			return false
+		}
+
+		return true
+	})
+
+	return found != nil, found
+}
+
+/*
+IsColliding reports whether a player at (x, y) is inside a collidable tile. One-way tiles
+never collide, and slopes only collide below their surface, see simulation.Solid.
+*/
+func (m *Map) IsColliding(x, y int) (bool, *tiles.Types) {
+	playerRect := image.Rect(x, y, x+simulation.PlayerSize, y+simulation.PlayerSize)
+
+	return m.checkPlayerRectAgainstTiles(playerRect, func(playerRect, tileRect image.Rectangle, tile tiles.Types) bool {
+		if !tile.Collidable {
+			return false
+		}
+
+		return solidFromTile(tile, tileRect).Blocks(rectFromImage(playerRect))
+	})
+}
+
+func (m *Map) IsTouching(x, y int) (bool, *tiles.Types) {
+	playerRect := image.Rect(x, y, x+simulation.PlayerSize, y+simulation.PlayerSize)
+
+	return m.checkPlayerRectAgainstTiles(playerRect, func(playerRect, tileRect image.Rectangle, tile tiles.Types) bool {
+		if !tile.Touchable {
+			return false
+		}
+
+		return playerRect.Overlaps(tileRect)
+	})
+}
+
+/*
+PlacedTile is a single tile in a map, along with its position.
+*/
+type PlacedTile struct {
+	Type     tiles.Types
+	Position simulation.Position
+}
+
+func placedRect(placed PlacedTile) image.Rectangle {
+	return cellRect(cellOf(placed.Position.X), cellOf(placed.Position.Y))
+}
+
+// ReachedExit reports whether a player at (x, y) is touching an open door, which finishes the level.
+func (m *Map) ReachedExit(x, y int) bool {
+	return m.isTouchingAny(x, y, tiles.Typeses.DOOR_OPENED_TILE)
+}
+
+// TouchingHazard reports whether a player at (x, y) is touching spikes, which hurt.
+func (m *Map) TouchingHazard(x, y int) bool {
+	return m.isTouchingAny(x, y, tiles.Typeses.SPIKE_TILE)
+}
+
+/*
+TouchingCheckpoint returns the checkpoint that a player at (x, y) is touching, if any.
+Checkpoints are placed inactive, and are only drawn as active for whoever reached them.
+*/
+func (m *Map) TouchingCheckpoint(x, y int) (PlacedTile, bool) {
+	for _, touching := range m.TouchingTiles(x, y) {
+		if touching.Type == tiles.Typeses.CHECKPOINT_INACTIVE_TILE {
+			return touching, true
+		}
+	}
+
+	return PlacedTile{}, false
+}
+
+func (m *Map) isTouchingAny(x, y int, tile tiles.Types) bool {
+	for _, touching := range m.TouchingTiles(x, y) {
+		if touching.Type == tile {
+			return true
+		}
+	}
+
+	return false
+}
+
+// KillPlaneMargin is how far below the bottom of the map a player can fall before they die.
+const KillPlaneMargin = 4 * TileSize
+
+/*
+FellOut reports whether a player whose top is at y has fallen out of the bottom of the
+map, by more than KillPlaneMargin.
+*/
+func (m *Map) FellOut(y float64) bool {
+	return y > float64(m.grid.rows*TileSize+KillPlaneMargin)
+}
+
+/*
+TouchingTiles returns every touchable tile that a player at (x, y) is overlapping, unlike
+IsTouching which only returns the type of the first one found.
+*/
+func (m *Map) TouchingTiles(x, y int) []PlacedTile {
+	playerRect := image.Rect(x, y, x+simulation.PlayerSize, y+simulation.PlayerSize)
+	touching := []PlacedTile{}
+
+	m.grid.each(playerRect, func(placed PlacedTile) bool {
+		if placed.Type.Touchable {
+			touching = append(touching, placed)
+		}
+
+		return true
+	})
+
+	return touching
+}
+
+/*
+Solids returns every collidable tile that overlaps the given area. Implements
+simulation.Ground.
+*/
+func (m *Map) Solids(area simulation.Rect) []simulation.Solid {
+	solids := []simulation.Solid{}
+
+	// Round outwards, so that every tile that the area overlaps even slightly is found.
+	rect := image.Rect(
+		int(math.Floor(area.X)), int(math.Floor(area.Y)),
+		int(math.Ceil(area.Right())), int(math.Ceil(area.Bottom())),
+	)
+
+	m.grid.each(rect, func(placed PlacedTile) bool {
+		if !placed.Type.Collidable {
+			return true
+		}
+
+		if solid := solidFromTile(placed.Type, placedRect(placed)); solid.Overlaps(area) {
+			solids = append(solids, solid)
+		}
+
+		return true
+	})
+
+	return solids
+}
+
+func solidFromTile(tile tiles.Types, tileRect image.Rectangle) simulation.Solid {
+	return simulation.Solid{Rect: rectFromImage(tileRect), OneWay: tile.OneWay, Slope: tile.Slope}
+}
+
+func rectFromImage(rect image.Rectangle) simulation.Rect {
+	return simulation.NewRect(float64(rect.Min.X), float64(rect.Min.Y), float64(rect.Dx()), float64(rect.Dy()))
+}
+
+/*
+EachTile calls visit for every tile in the map, e.g. to draw them.
+*/
+func (m *Map) EachTile(visit func(tile PlacedTile)) {
+	for tile, positions := range m.positions {
+		for _, position := range positions {
+			visit(PlacedTile{Type: tile, Position: position})
+		}
+	}
+}
+
+/*
+Collectables returns the position of every pickup that a player at (x, y) is touching.
+Implements simulation.World.
+*/
+func (m *Map) Collectables(x, y int) []simulation.Position {
+	collectables := []simulation.Position{}
+
+	for _, tile := range m.TouchingTiles(x, y) {
+		switch tile.Type {
+		case tiles.Typeses.COIN_TILE,
+			tiles.Typeses.DIAMOND_TILE,
+			tiles.Typeses.EMERALD_TILE,
+			tiles.Typeses.HEART_TILE:
+			collectables = append(collectables, tile.Position)
+		}
+	}
+
+	return collectables
+}
+
+// Check that `Map` correctly implements `simulation.World`.
+var _ simulation.World = &Map{}
@@ -0,0 +1,115 @@
+package maps_test
+
+import (
+	"fmt"
+	"image"
+	"testing"
+
+	"fyp/common/ctypes/tiles"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+)
+
+/*
+generate creates a map that is columns by rows tiles, with a floor along the bottom and a
+platform with a coin above it every few tiles, which is roughly as dense as the maps in
+resources/maps.
+*/
+func generate(columns, rows int) *maps.Map {
+	placed := []maps.PlacedTile{}
+	random := simulation.NewRandom(uint64(columns * rows))
+
+	place := func(tile tiles.Types, column, row int) {
+		position := simulation.NewPosition(float64(column*maps.TileSize), float64(row*maps.TileSize))
+		placed = append(placed, maps.PlacedTile{Type: tile, Position: position})
+	}
+
+	for column := 0; column < columns; column++ {
+		place(tiles.Typeses.GROUND_MM_TILE, column, rows-1)
+	}
+
+	for row := 3; row < rows-1; row += 4 {
+		for column := random.Intn(8); column < columns-3; column += 4 + random.Intn(8) {
+			place(tiles.Typeses.GROUND_BL_TILE, column, row)
+			place(tiles.Typeses.GROUND_BM_TILE, column+1, row)
+			place(tiles.Typeses.GROUND_BR_TILE, column+2, row)
+			place(tiles.Typeses.COIN_TILE, column+1, row-1)
+		}
+	}
+
+	return maps.NewMapFromTiles(placed)
+}
+
+type query struct {
+	name string
+	run  func(m *maps.Map, x, y int)
+}
+
+var queries = []query{
+	{
+		name: "IsColliding",
+		run: func(m *maps.Map, x, y int) {
+			m.IsColliding(x, y)
+		},
+	},
+	{
+		name: "IsTouching",
+		run: func(m *maps.Map, x, y int) {
+			m.IsTouching(x, y)
+		},
+	},
+	{
+		name: "Solids",
+		run: func(m *maps.Map, x, y int) {
+			m.Solids(simulation.NewRect(float64(x), float64(y), 24, 24))
+		},
+	},
+	{
+		name: "TilesIn",
+		run: func(m *maps.Map, x, y int) {
+			m.TilesIn(image.Rect(x, y, x+64, y+64))
+		},
+	},
+	{
+		name: "Raycast",
+		run: func(m *maps.Map, x, y int) {
+			from := simulation.NewPosition(float64(x), float64(y))
+			to := simulation.NewPosition(float64(x+160), float64(y+80))
+
+			m.Raycast(from, to, func(tile tiles.Types) bool { return tile.Collidable })
+		},
+	},
+}
+
+// The sizes of map to benchmark, in tiles. The first is the largest map that can be
+// loaded from a file.
+var sizes = [][2]int{{40, 30}, {160, 120}, {640, 480}, {2560, 1920}}
+
+/*
+BenchmarkCollisionQueries runs every collision query on maps of increasing size, at random
+points across the whole map. The cost of a query should depend on how much of the map it
+covers and not on how large the map is, so ns/op should stay about the same across sizes:
+
+	go test ./common/maps -bench CollisionQueries -benchmem
+*/
+func BenchmarkCollisionQueries(b *testing.B) {
+	generated := make([]*maps.Map, len(sizes))
+	for i, size := range sizes {
+		generated[i] = generate(size[0], size[1])
+	}
+
+	for _, q := range queries {
+		for i, size := range sizes {
+			m := generated[i]
+			width, height := size[0]*maps.TileSize, size[1]*maps.TileSize
+
+			b.Run(fmt.Sprintf("%s/%dx%d", q.name, size[0], size[1]), func(b *testing.B) {
+				random := simulation.NewRandom(0)
+
+				for n := 0; n < b.N; n++ {
+					q.run(m, random.Intn(width), random.Intn(height))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,299 @@
+/*
+rollback implements GGPO-style rollback netcode on top of simulation.Simulation, as an
+alternative to the server being authoritative over every player's position.
+
+Every peer runs the whole simulation, and exchanges only its inputs, one per frame. When
+a remote player's input for a frame hasn't arrived yet, their last known input is
+repeated. If the input that arrives later turns out to be different, the simulation is
+restored to the frame before the misprediction and resimulated up to the current frame,
+so that every peer agrees on every frame.
+*/
+package rollback
+
+import (
+	"sort"
+
+	"fyp/common/ctypes"
+	"fyp/common/simulation"
+)
+
+const (
+	// MaxPredictionFrames is how many frames the session will run ahead of the first input
+	// still missing from each remote player. Past this, Advance stalls until the remote
+	// player catches up, as resimulating any more frames at once would be too noticeable.
+	MaxPredictionFrames = 8
+
+	// DefaultInputDelay is how many frames in the future local inputs are scheduled for. A
+	// small delay gives remote inputs time to arrive, which makes rollbacks less frequent.
+	DefaultInputDelay = 2
+
+	// RedundantInputs is how many of the latest local inputs are resent in every packet, so
+	// that a lost packet doesn't cause a stall.
+	RedundantInputs = 8
+)
+
+/*
+Session is a single peer's view of a rollback game. Inputs are identified by the frame
+they are for, which is carried in ctypes.PlayerInput's Tick.
+
+Every peer has to start its session from the same simulation state at the same frame, so
+when players join or leave, every peer starts a new session rather than changing the
+players in the current one.
+*/
+type Session struct {
+	simulation *simulation.Simulation
+	local      string
+	inputDelay uint64
+
+	inputs    map[string]map[uint64]ctypes.PlayerInput
+	missing   map[string]uint64
+	used      map[uint64]map[string]ctypes.PlayerInput
+	snapshots map[uint64]simulation.State
+
+	needsRollback bool
+	rollbackTo    uint64
+
+	// Rollbacks is the amount of times the session has had to resimulate, for debugging.
+	Rollbacks int
+}
+
+/*
+NewSession creates a new Session for the given simulation, where local is the name of
+the player controlled by this peer. The local player has no input for the first
+inputDelay frames, so these are filled in as empty inputs.
+*/
+func NewSession(sim *simulation.Simulation, local string, inputDelay uint64) *Session {
+	session := &Session{
+		simulation: sim,
+		local:      local,
+		inputDelay: inputDelay,
+		inputs:     make(map[string]map[uint64]ctypes.PlayerInput),
+		missing:    make(map[string]uint64),
+		used:       make(map[uint64]map[string]ctypes.PlayerInput),
+		snapshots:  make(map[uint64]simulation.State),
+	}
+
+	for _, name := range sim.Players() {
+		session.missing[name] = sim.Frame()
+	}
+
+	for frame := sim.Frame(); frame < sim.Frame()+inputDelay; frame++ {
+		session.store(local, ctypes.PlayerInput{Tick: frame})
+	}
+
+	return session
+}
+
+func (s *Session) Simulation() *simulation.Simulation {
+	return s.simulation
+}
+
+/*
+AddLocalInput schedules an input from the local player for inputDelay frames from now,
+and returns the input scheduled for that frame. Once a frame has an input it never
+changes, as it may already have been sent to the other peers, who only ever take the
+first input they get for a frame. So while Advance is stalled, and the frame doesn't
+move on, the input that was scheduled first is kept and this one is dropped.
+*/
+func (s *Session) AddLocalInput(input ctypes.PlayerInput) ctypes.PlayerInput {
+	frame := s.simulation.Frame() + s.inputDelay
+	if scheduled, ok := s.inputs[s.local][frame]; ok {
+		return scheduled
+	}
+
+	input.Tick = frame
+	s.store(s.local, input)
+
+	return input
+}
+
+/*
+LocalInputs returns the latest local inputs, oldest first, to be sent to the other
+peers. Several inputs are sent at once so that a single lost packet doesn't lose an
+input.
+*/
+func (s *Session) LocalInputs() []ctypes.PlayerInput {
+	frames := make([]uint64, 0, len(s.inputs[s.local]))
+	for frame := range s.inputs[s.local] {
+		frames = append(frames, frame)
+	}
+
+	sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+
+	if len(frames) > RedundantInputs {
+		frames = frames[len(frames)-RedundantInputs:]
+	}
+
+	inputs := make([]ctypes.PlayerInput, 0, len(frames))
+	for _, frame := range frames {
+		inputs = append(inputs, s.inputs[s.local][frame])
+	}
+
+	return inputs
+}
+
+/*
+AddRemoteInput records an input received from a remote player. If the frame has already
+been simulated with a different, predicted input, the session will roll back to that
+frame on the next Advance.
+*/
+func (s *Session) AddRemoteInput(name string, input ctypes.PlayerInput) {
+	if name == s.local {
+		return
+	}
+
+	if _, ok := s.inputs[name][input.Tick]; ok {
+		return
+	}
+
+	s.store(name, input)
+
+	used, ok := s.used[input.Tick][name]
+	if !ok || sameButtons(used, input) {
+		return
+	}
+
+	if !s.needsRollback || input.Tick < s.rollbackTo {
+		s.rollbackTo = input.Tick
+	}
+
+	s.needsRollback = true
+}
+
+func (s *Session) store(name string, input ctypes.PlayerInput) {
+	if _, ok := s.inputs[name]; !ok {
+		s.inputs[name] = make(map[uint64]ctypes.PlayerInput)
+	}
+
+	s.inputs[name][input.Tick] = input
+
+	// missing is the first frame that the player's input hasn't arrived for yet.
+	for {
+		if _, ok := s.inputs[name][s.missing[name]]; !ok {
+			break
+		}
+
+		s.missing[name]++
+	}
+}
+
+/*
+predict returns the input to use for a player on a frame: the real input if it has
+arrived, otherwise the latest input before that frame with its buttons held.
+*/
+func (s *Session) predict(name string, frame uint64) ctypes.PlayerInput {
+	if input, ok := s.inputs[name][frame]; ok {
+		return input
+	}
+
+	var (
+		best      ctypes.PlayerInput
+		bestFrame uint64
+		found     bool
+	)
+
+	for inputFrame, input := range s.inputs[name] {
+		if inputFrame < frame && (!found || inputFrame > bestFrame) {
+			best, bestFrame, found = input, inputFrame, true
+		}
+	}
+
+	best.Tick = frame
+
+	return best
+}
+
+/*
+CanAdvance reports whether the session is within MaxPredictionFrames of the first
+missing input of every remote player.
+*/
+func (s *Session) CanAdvance() bool {
+	frame := s.simulation.Frame()
+
+	for name, missing := range s.missing {
+		if name != s.local && frame >= missing+MaxPredictionFrames {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+Advance resimulates from the earliest mispredicted frame if needed, and then simulates
+the current frame. It returns false if the session is too far ahead of a remote player to
+advance, in which case only the rollback (if any) was done.
+*/
+func (s *Session) Advance() bool {
+	if s.needsRollback {
+		s.needsRollback = false
+		current := s.simulation.Frame()
+
+		if snapshot, ok := s.snapshots[s.rollbackTo]; ok {
+			s.simulation.Restore(snapshot)
+
+			for s.simulation.Frame() < current {
+				s.step()
+			}
+
+			s.Rollbacks++
+		}
+	}
+
+	if !s.CanAdvance() {
+		return false
+	}
+
+	s.step()
+	s.prune()
+
+	return true
+}
+
+func (s *Session) step() {
+	frame := s.simulation.Frame()
+	s.snapshots[frame] = s.simulation.Snapshot()
+
+	inputs := make(map[string]ctypes.PlayerInput)
+	for _, name := range s.simulation.Players() {
+		inputs[name] = s.predict(name, frame)
+	}
+
+	s.used[frame] = inputs
+	s.simulation.Step(inputs)
+}
+
+/*
+prune drops the snapshots and inputs that can no longer be rolled back to, which are
+those before the earliest frame that a remote player's input is still missing for.
+*/
+func (s *Session) prune() {
+	oldest := s.simulation.Frame()
+
+	for name, missing := range s.missing {
+		if name != s.local {
+			oldest = min(oldest, missing)
+		}
+	}
+
+	for frame := range s.snapshots {
+		if frame < oldest {
+			delete(s.snapshots, frame)
+			delete(s.used, frame)
+		}
+	}
+
+	// Inputs are kept a while longer than the snapshots, as they are also used for
+	// prediction and resent to the other peers.
+	for name, inputs := range s.inputs {
+		for frame := range inputs {
+			if frame+RedundantInputs < oldest {
+				delete(s.inputs[name], frame)
+			}
+		}
+	}
+}
+
+func sameButtons(a, b ctypes.PlayerInput) bool {
+	return a.Left == b.Left && a.Right == b.Right && a.Jump == b.Jump && a.Crouch == b.Crouch
+}
@@ -0,0 +1,350 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+type (
+	AnimationFrame int
+	Direction      bool
+)
+
+const (
+	// PlayerSize is the width and height of a player, in pixels.
+	PlayerSize = 16
+
+	// How many ticks each running frame is shown for, which is 50ms.
+	runningFrameTicks = TickRate / 20
+)
+
+// player animation frames, in the order they appear in the spritesheet.
+const (
+	AnimationStanding AnimationFrame = iota
+	AnimationRunningStage0
+	AnimationRunningStage1
+	AnimationRunningStage2
+	AnimationRunningStage4
+	AnimationJumping
+	AnimationCrouching
+	AnimationMinFrame = AnimationStanding
+	AnimationMaxFrame = AnimationCrouching
+)
+
+func (frame AnimationFrame) IsRunning() bool {
+	return frame >= AnimationRunningStage0 && frame <= AnimationRunningStage4
+}
+
+const (
+	FacingLeft  Direction = false
+	FacingRight Direction = true
+)
+
+func (direction *Direction) String() string {
+	var str string
+
+	switch *direction {
+	case FacingLeft:
+		str = "left"
+	case FacingRight:
+		str = "right"
+	default:
+		str = "unknown"
+	}
+
+	return str
+}
+
+func (direction Direction) MarshalJSON() ([]byte, error) {
+	var str string
+
+	switch direction {
+	case FacingLeft, FacingRight:
+		str = fmt.Sprintf("%q", direction.String())
+	default:
+		return nil, fmt.Errorf("Direction marshal error: invalid input %s", direction.String())
+	}
+
+	return []byte(str), nil
+}
+
+func (direction *Direction) UnmarshalJSON(data []byte) error {
+	str := string(data)
+
+	switch str {
+	case "0", "false", "\"left\"":
+		*direction = FacingLeft
+	case "1", "true", "\"right\"":
+		*direction = FacingRight
+	default:
+		return fmt.Errorf("Direction unmarshal error: invalid input %s", str)
+	}
+
+	return nil
+}
+
+/*
+Body is everything about a player that the simulation changes: where they are, how fast
+they are moving, which way they are facing, and which animation frame they are on. Time
+is only ever measured in ticks, so stepping a Body with the same inputs always gives the
+same result, no matter how quickly the ticks are run.
+
+Everything that affects how the body moves is exported, so that the server can send it to
+the client, and the client can replay its inputs on top of it.
+*/
+type Body struct {
+	Position          Position  `json:"pos,omitempty"`
+	Velocity          Position  `json:"vel,omitempty"`
+	Facing            Direction `json:"facing,omitempty"`
+	LastInputSequence uint64    `json:"last_input_seq,omitempty"`
+
+	Grounded    bool `json:"grounded,omitempty"`
+	Rising      bool `json:"rising,omitempty"`
+	JumpHeld    bool `json:"jump_held,omitempty"`
+	CoyoteTicks int  `json:"coyote,omitempty"`
+	JumpBuffer  int  `json:"jump_buffer,omitempty"`
+
+	animation       AnimationFrame
+	ticks           uint64
+	lastAnimated    uint64
+	runningDecrease bool
+}
+
+func NewBody(position Position) Body {
+	return Body{Position: position, Facing: FacingRight}
+}
+
+/*
+ResetTo copies everything that affects movement from other, e.g. when the server has
+corrected the client's copy of a body. The animation is kept, as it isn't sent over the
+network.
+*/
+func (b *Body) ResetTo(other Body) {
+	b.Position = other.Position
+	b.Velocity = other.Velocity
+	b.Facing = other.Facing
+	b.LastInputSequence = other.LastInputSequence
+	b.Grounded = other.Grounded
+	b.Rising = other.Rising
+	b.JumpHeld = other.JumpHeld
+	b.CoyoteTicks = other.CoyoteTicks
+	b.JumpBuffer = other.JumpBuffer
+}
+
+// Rect returns the area that the body takes up.
+func (b *Body) Rect() Rect {
+	return NewRect(b.Position.X, b.Position.Y, PlayerSize, PlayerSize)
+}
+
+// Animation returns the animation frame that the body should be drawn with.
+func (b *Body) Animation() AnimationFrame {
+	return b.animation
+}
+
+/*
+Step runs a single tick of movement from the given input: the body is accelerated by the
+input and by gravity, and then moved along the x axis and the y axis separately, stopping
+at anything solid in the ground. The input's sequence number is recorded so that the
+server can tell the client which inputs it has processed.
+
+While on the ground, the body follows it up and down by at most StepHeight, so that it
+can walk up and down slopes and small steps. Crouching drops through one-way solids.
+*/
+func (b *Body) Step(input Input, ground Ground, physics *Physics) {
+	b.ticks++
+
+	wasGrounded := b.Grounded
+	direction := b.run(input, physics)
+	b.jump(input, physics)
+
+	b.Velocity.Y = min(b.Velocity.Y+physics.Gravity, physics.TerminalVelocity)
+
+	// How far the body can be lifted or lowered to follow the ground this tick. Moving
+	// between a slope and a flat tile next to it can take another tick of running on top
+	// of StepHeight.
+	follow := physics.StepHeight + math.Abs(b.Velocity.X)
+
+	b.moveX(ground, wasGrounded && !b.Rising, follow)
+
+	var hitY bool
+
+	b.Position.Y, hitY = sweepY(b.Rect(), b.Velocity.Y, ground, input.Crouch, physics.StepHeight)
+
+	// Stay on the ground when walking down a slope or off a small step, rather than
+	// falling a little way every tick.
+	if !hitY && wasGrounded && !b.Rising {
+		if y, hit := sweepY(b.Rect(), follow, ground, input.Crouch, physics.StepHeight); hit {
+			b.Position.Y, hitY = y, true
+		}
+	}
+
+	if hitY {
+		b.Grounded = b.Velocity.Y > 0
+		b.Velocity.Y = 0
+		b.Rising = false
+	} else {
+		b.Grounded = false
+	}
+
+	b.animate(input, direction)
+
+	if input.Sequence > b.LastInputSequence {
+		b.LastInputSequence = input.Sequence
+	}
+}
+
+/*
+moveX moves the body along the x axis. If the body is on the ground and is stopped by a
+solid that it could step up onto, it is lifted up by at most lift and moved again, and
+will settle back onto the solid when it is moved along the y axis.
+*/
+func (b *Body) moveX(ground Ground, canStep bool, lift float64) {
+	x, hit := sweepX(b.Rect(), b.Velocity.X, ground)
+
+	if hit && canStep && ground != nil && canStepUp(b.Rect(), lift, ground) {
+		lifted := b.Rect()
+		lifted.Y -= lift
+
+		if steppedX, steppedHit := sweepX(lifted, b.Velocity.X, ground); steppedX != x {
+			x, hit = steppedX, steppedHit
+			b.Position.Y = lifted.Y
+			b.Velocity.Y = max(b.Velocity.Y, lift)
+		}
+	}
+
+	b.Position.X = x
+	if hit {
+		b.Velocity.X = 0
+	}
+}
+
+// run accelerates the body along the x axis, and returns the direction it's being moved.
+func (b *Body) run(input Input, physics *Physics) float64 {
+	var direction float64
+
+	switch {
+	case input.Left:
+		direction = -1
+	case input.Right:
+		direction = 1
+	}
+
+	acceleration, deceleration := physics.RunAcceleration, physics.RunDeceleration
+	if !b.Grounded {
+		acceleration *= physics.AirControl
+		deceleration *= physics.AirControl
+	}
+
+	if direction == 0 {
+		b.Velocity.X = approach(b.Velocity.X, 0, deceleration)
+	} else {
+		b.Velocity.X = approach(b.Velocity.X, direction*physics.MaxRunSpeed, acceleration)
+	}
+
+	return direction
+}
+
+/*
+jump starts a jump if one was pressed within the last JumpBufferTicks, and the body has
+been on the ground within the last CoyoteTicks. Releasing jump while rising cuts the
+jump short.
+*/
+func (b *Body) jump(input Input, physics *Physics) {
+	pressed := input.Jump && !b.JumpHeld
+	b.JumpHeld = input.Jump
+
+	switch {
+	case pressed:
+		b.JumpBuffer = physics.JumpBufferTicks + 1
+	case b.JumpBuffer > 0:
+		b.JumpBuffer--
+	}
+
+	switch {
+	case b.Grounded:
+		b.CoyoteTicks = physics.CoyoteTicks + 1
+	case b.CoyoteTicks > 0:
+		b.CoyoteTicks--
+	}
+
+	if b.JumpBuffer > 0 && b.CoyoteTicks > 0 {
+		b.Velocity.Y = -physics.JumpVelocity
+		b.JumpBuffer, b.CoyoteTicks = 0, 0
+		b.Grounded = false
+		b.Rising = true
+
+		return
+	}
+
+	if b.Rising && (!input.Jump || b.Velocity.Y >= 0) {
+		if b.Velocity.Y < 0 {
+			b.Velocity.Y *= physics.JumpCutMultiplier
+		}
+
+		b.Rising = false
+	}
+}
+
+// approach moves value towards target by at most step.
+func approach(value, target, step float64) float64 {
+	if value < target {
+		return min(value+step, target)
+	}
+
+	return max(value-step, target)
+}
+
+func (b *Body) animate(input Input, direction float64) {
+	switch {
+	case direction < 0:
+		b.face(FacingLeft)
+	case direction > 0:
+		b.face(FacingRight)
+	}
+
+	switch {
+	case !b.Grounded:
+		b.setAnimation(AnimationJumping)
+	case direction != 0:
+		b.stepRunning()
+	case input.Crouch:
+		b.setAnimation(AnimationCrouching)
+	default:
+		b.setAnimation(AnimationStanding)
+	}
+}
+
+func (b *Body) face(facing Direction) {
+	if b.Facing != facing {
+		b.Facing = facing
+		b.lastAnimated = 0
+	}
+}
+
+func (b *Body) setAnimation(frame AnimationFrame) {
+	b.runningDecrease = false
+	b.animation = frame
+}
+
+// stepRunning steps through the running frames back and forth, every runningFrameTicks
+// ticks.
+func (b *Body) stepRunning() {
+	if b.animation.IsRunning() && b.ticks-b.lastAnimated < runningFrameTicks {
+		return
+	}
+
+	b.lastAnimated = b.ticks
+
+	switch {
+	case !b.animation.IsRunning():
+		b.animation = AnimationRunningStage0
+	case b.runningDecrease && b.animation > AnimationRunningStage0:
+		b.animation--
+	case b.runningDecrease:
+		b.runningDecrease = false
+	case b.animation < AnimationRunningStage4:
+		b.animation++
+	default:
+		b.runningDecrease = true
+	}
+}
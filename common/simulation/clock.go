@@ -0,0 +1,98 @@
+package simulation
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// TickRate is how many times per second the simulation is stepped.
+	TickRate = 60
+
+	// TickDuration is how long each tick of the simulation represents.
+	TickDuration = time.Second / TickRate
+
+	// The most ticks that FixedStep will ask for at once. If the game falls further behind
+	// than this, e.g. while the window is being dragged, the missed time is dropped rather
+	// than being caught up on all at once.
+	maxCatchUpTicks = 8
+)
+
+// Clock is a source of the current time, which can be swapped out for a ManualClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock that reads the system's clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock is a Clock that only moves when it is told to.
+type ManualClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forwards by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+/*
+FixedStep works out how many ticks of the simulation are due, so that the simulation is
+stepped TickRate times per second of the clock, no matter how often Ticks is called.
+Leftover time that doesn't make up a whole tick is carried over to the next call.
+*/
+type FixedStep struct {
+	clock       Clock
+	last        time.Time
+	accumulated time.Duration
+	started     bool
+}
+
+func NewFixedStep(clock Clock) *FixedStep {
+	return &FixedStep{clock: clock}
+}
+
+// Ticks returns how many ticks are due since the last call. The first call starts the
+// clock, and always returns a single tick.
+func (fs *FixedStep) Ticks() int {
+	now := fs.clock.Now()
+
+	if !fs.started {
+		fs.started = true
+		fs.last = now
+
+		return 1
+	}
+
+	fs.accumulated += now.Sub(fs.last)
+	fs.last = now
+
+	ticks := int(fs.accumulated / TickDuration)
+	fs.accumulated -= time.Duration(ticks) * TickDuration
+
+	if ticks > maxCatchUpTicks {
+		ticks = maxCatchUpTicks
+		fs.accumulated = 0
+	}
+
+	return ticks
+}
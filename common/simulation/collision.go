@@ -0,0 +1,184 @@
+package simulation
+
+// Rect is an axis-aligned rectangle, with (X, Y) at its top left.
+type Rect struct {
+	X, Y, W, H float64
+}
+
+func NewRect(x, y, w, h float64) Rect {
+	return Rect{X: x, Y: y, W: w, H: h}
+}
+
+func (r Rect) Right() float64 {
+	return r.X + r.W
+}
+
+func (r Rect) Bottom() float64 {
+	return r.Y + r.H
+}
+
+// Overlaps reports whether the two rectangles share any area. Rectangles that only touch
+// along an edge don't overlap.
+func (r Rect) Overlaps(other Rect) bool {
+	return r.X < other.Right() && other.X < r.Right() && r.Y < other.Bottom() && other.Y < r.Bottom()
+}
+
+// Union returns the smallest rectangle that contains both rectangles.
+func (r Rect) Union(other Rect) Rect {
+	x, y := min(r.X, other.X), min(r.Y, other.Y)
+
+	return Rect{X: x, Y: y, W: max(r.Right(), other.Right()) - x, H: max(r.Bottom(), other.Bottom()) - y}
+}
+
+/*
+Solid is a rectangle that players can't move through. A OneWay solid only stops players
+that are falling onto it from above, and can be jumped up through, walked through, and
+dropped through by crouching.
+
+A solid with a Slope is a 45° slope across the rectangle, rising to the right if Slope is
+1 and to the left if Slope is -1. Players stand on the slope's surface, underneath their
+centre. Slopes only ever hold players up, so they should be placed on top of other solids.
+*/
+type Solid struct {
+	Rect
+	OneWay bool
+	Slope  int
+}
+
+/*
+SurfaceAt returns the height of the top of the solid at x. For anything other than a
+slope, this is the top of its rectangle.
+*/
+func (s Solid) SurfaceAt(x float64) float64 {
+	across := max(0, min(x-s.X, s.W)) * s.H / s.W
+
+	switch {
+	case s.Slope > 0:
+		return s.Bottom() - across
+	case s.Slope < 0:
+		return s.Y + across
+	default:
+		return s.Y
+	}
+}
+
+/*
+Blocks reports whether rect is inside the solid part of the solid: anywhere in a normal
+solid, underneath the surface of a slope, and never for a one-way solid.
+*/
+func (s Solid) Blocks(rect Rect) bool {
+	switch {
+	case s.OneWay || !s.Overlaps(rect):
+		return false
+	case s.Slope != 0:
+		centre := rect.X + rect.W/2
+		return centre >= s.X && centre <= s.Right() && rect.Bottom() > s.SurfaceAt(centre)
+	default:
+		return true
+	}
+}
+
+/*
+Ground describes anything that players collide with, e.g. the currently loaded map.
+*/
+type Ground interface {
+	// Solids returns every solid that overlaps the given area.
+	Solids(area Rect) []Solid
+}
+
+/*
+sweepX moves rect by dx along the x axis, stopping at the first solid in the way. Solids
+that rect already overlaps are ignored, so that a player stuck inside a tile can still
+walk out of it. One-way solids and slopes never stop movement along the x axis. The
+returned bool is true if a solid was hit.
+*/
+func sweepX(rect Rect, dx float64, ground Ground) (float64, bool) {
+	if dx == 0 || ground == nil {
+		return rect.X + dx, false
+	}
+
+	moved := rect
+	moved.X += dx
+
+	x, hit := moved.X, false
+
+	for _, solid := range ground.Solids(rect.Union(moved)) {
+		if solid.OneWay || solid.Slope != 0 || solid.Overlaps(rect) || rect.Y >= solid.Bottom() || solid.Y >= rect.Bottom() {
+			continue
+		}
+
+		switch {
+		case dx > 0 && solid.X >= rect.Right() && solid.X-rect.W < x:
+			x, hit = solid.X-rect.W, true
+		case dx < 0 && solid.Right() <= rect.X && solid.Right() > x:
+			x, hit = solid.Right(), true
+		}
+	}
+
+	return x, hit
+}
+
+/*
+sweepY moves rect by dy along the y axis, stopping at the first solid in the way.
+
+One-way solids only stop rect when it is moving down onto them from above, and not at
+all if dropThrough is set. Slopes stop rect when its centre moves down onto their
+surface, from at most stepUp below the surface, so that walking up a slope lifts rect
+onto it.
+*/
+func sweepY(rect Rect, dy float64, ground Ground, dropThrough bool, stepUp float64) (float64, bool) {
+	if dy == 0 || ground == nil {
+		return rect.Y + dy, false
+	}
+
+	moved := rect
+	moved.Y += dy
+
+	y, hit := moved.Y, false
+	centre := rect.X + rect.W/2
+
+	for _, solid := range ground.Solids(rect.Union(moved)) {
+		if solid.Slope != 0 {
+			if dy < 0 || centre < solid.X || centre > solid.Right() {
+				continue
+			}
+
+			surface := solid.SurfaceAt(centre)
+			if rect.Bottom() <= surface+stepUp && moved.Bottom() >= surface && surface-rect.H < y {
+				y, hit = surface-rect.H, true
+			}
+
+			continue
+		}
+
+		if (solid.OneWay && dropThrough) || solid.Overlaps(rect) || rect.X >= solid.Right() || solid.X >= rect.Right() {
+			continue
+		}
+
+		switch {
+		case dy > 0 && solid.Y >= rect.Bottom() && solid.Y-rect.H < y:
+			y, hit = solid.Y-rect.H, true
+		case dy < 0 && !solid.OneWay && solid.Bottom() <= rect.Y && solid.Bottom() > y:
+			y, hit = solid.Bottom(), true
+		}
+	}
+
+	return y, hit
+}
+
+/*
+canStepUp reports whether rect can be lifted by height without ending up inside anything
+solid.
+*/
+func canStepUp(rect Rect, height float64, ground Ground) bool {
+	lifted := rect
+	lifted.Y -= height
+
+	for _, solid := range ground.Solids(lifted) {
+		if solid.Blocks(lifted) {
+			return false
+		}
+	}
+
+	return true
+}
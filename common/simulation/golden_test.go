@@ -0,0 +1,395 @@
+package simulation_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fyp/common/simulation"
+)
+
+/*
+update rewrites the golden file instead of checking against it, after an intended change
+to the simulation:
+
+	go test ./common/simulation -run TestSimulationGolden -update
+*/
+var update = flag.Bool("update", false, "rewrite testdata/golden.json instead of checking it")
+
+var goldenPath = filepath.Join("testdata", "golden.json")
+
+/*
+flatWorld is a level with a solid floor, a wall, a ledge and a row of pickups, and a
+separate hill made of slopes and a one-way platform further to the right, so that the
+golden file doesn't change whenever the maps in resources/maps do.
+*/
+type flatWorld struct {
+	solids      []simulation.Solid
+	collectable []simulation.Position
+}
+
+func (w *flatWorld) Solids(area simulation.Rect) []simulation.Solid {
+	solids := []simulation.Solid{}
+
+	for _, solid := range w.solids {
+		if solid.Overlaps(area) {
+			solids = append(solids, solid)
+		}
+	}
+
+	return solids
+}
+
+func (w *flatWorld) Collectables(x, y int) []simulation.Position {
+	touching := []simulation.Position{}
+
+	for _, position := range w.collectable {
+		tileX, tileY := int(position.X), int(position.Y)
+
+		if x < tileX+simulation.PlayerSize && tileX < x+simulation.PlayerSize &&
+			y < tileY+simulation.PlayerSize && tileY < y+simulation.PlayerSize {
+			touching = append(touching, position)
+		}
+	}
+
+	return touching
+}
+
+func newWorld() *flatWorld {
+	world := &flatWorld{
+		solids: []simulation.Solid{
+			// The floor, and a floating ledge to walk off of and bump heads on.
+			{Rect: simulation.NewRect(-64, 160, 512, 16)},
+			{Rect: simulation.NewRect(96, 96, 64, 16)},
+			// A wall at the right hand side.
+			{Rect: simulation.NewRect(336, 64, 16, 96)},
+
+			// A second floor, with a hill of two slopes either side of a flat top, and a
+			// one-way platform to jump up through and drop back down from.
+			{Rect: simulation.NewRect(1000, 160, 400, 16)},
+			{Rect: simulation.NewRect(1100, 144, 16, 16), Slope: 1},
+			{Rect: simulation.NewRect(1116, 144, 32, 16)},
+			{Rect: simulation.NewRect(1148, 144, 16, 16), Slope: -1},
+			{Rect: simulation.NewRect(1232, 128, 48, 16), OneWay: true},
+		},
+	}
+
+	for x := 32; x < 320; x += 48 {
+		world.collectable = append(world.collectable, simulation.NewPosition(float64(x), 144))
+	}
+
+	return world
+}
+
+// script returns every player's input for the given tick.
+type script func(tick uint64, random *simulation.Random) map[string]simulation.Input
+
+type scenario struct {
+	name    string
+	seed    uint64
+	ticks   uint64
+	players map[string]simulation.Position
+	script  script
+}
+
+var scenarios = []scenario{
+	{
+		name:    "walk-right",
+		seed:    1,
+		ticks:   120,
+		players: map[string]simulation.Position{"Blue": simulation.NewPosition(0, 144)},
+		script: func(uint64, *simulation.Random) map[string]simulation.Input {
+			return map[string]simulation.Input{"Blue": {Right: true}}
+		},
+	},
+	{
+		name:    "jump-and-fall",
+		seed:    2,
+		ticks:   90,
+		players: map[string]simulation.Position{"Blue": simulation.NewPosition(64, 144)},
+		script: func(tick uint64, _ *simulation.Random) map[string]simulation.Input {
+			return map[string]simulation.Input{"Blue": {Jump: tick < 30, Left: tick >= 20 && tick < 60}}
+		},
+	},
+	{
+		name:    "variable-jump-height",
+		seed:    5,
+		ticks:   120,
+		players: map[string]simulation.Position{"Blue": simulation.NewPosition(0, 144)},
+		script: func(tick uint64, _ *simulation.Random) map[string]simulation.Input {
+			// A tap, and then a held jump once landed.
+			return map[string]simulation.Input{"Blue": {Jump: tick < 3 || (tick >= 60 && tick < 100)}}
+		},
+	},
+	{
+		name:    "coyote-time-and-jump-buffer",
+		seed:    6,
+		ticks:   120,
+		players: map[string]simulation.Position{"Blue": simulation.NewPosition(128, 80)},
+		script: func(tick uint64, _ *simulation.Random) map[string]simulation.Input {
+			// Walks off the ledge and jumps just after leaving it, then presses jump just
+			// before landing on the floor.
+			return map[string]simulation.Input{"Blue": {Right: tick < 40, Jump: (tick >= 22 && tick < 26) || (tick >= 70 && tick < 74)}}
+		},
+	},
+	{
+		name:    "wall-and-ceiling",
+		seed:    7,
+		ticks:   180,
+		players: map[string]simulation.Position{"Blue": simulation.NewPosition(112, 144)},
+		script: func(tick uint64, _ *simulation.Random) map[string]simulation.Input {
+			// Jumps into the underside of the ledge, and then runs into the wall.
+			return map[string]simulation.Input{"Blue": {Jump: tick < 20, Right: tick >= 40}}
+		},
+	},
+	{
+		name:    "walk-over-slopes",
+		seed:    8,
+		ticks:   120,
+		players: map[string]simulation.Position{"Blue": simulation.NewPosition(1040, 144)},
+		script: func(tick uint64, _ *simulation.Random) map[string]simulation.Input {
+			return map[string]simulation.Input{"Blue": {Right: tick < 80}}
+		},
+	},
+	{
+		name:    "one-way-platform",
+		seed:    9,
+		ticks:   150,
+		players: map[string]simulation.Position{"Blue": simulation.NewPosition(1248, 144)},
+		script: func(tick uint64, _ *simulation.Random) map[string]simulation.Input {
+			// Jumps up through the platform and lands on it, then crouches to drop back
+			// down through it.
+			return map[string]simulation.Input{"Blue": {Jump: tick < 20, Crouch: tick >= 90 && tick < 100}}
+		},
+	},
+	{
+		name:  "two-players-stacking",
+		seed:  3,
+		ticks: 120,
+		players: map[string]simulation.Position{
+			"Blue":  simulation.NewPosition(64, 144),
+			"Green": simulation.NewPosition(64, 80),
+		},
+		script: func(tick uint64, _ *simulation.Random) map[string]simulation.Input {
+			return map[string]simulation.Input{
+				"Blue":  {Crouch: tick < 60, Right: tick >= 60},
+				"Green": {Left: tick >= 90},
+			}
+		},
+	},
+	{
+		name:  "random-inputs",
+		seed:  4,
+		ticks: 600,
+		players: map[string]simulation.Position{
+			"Blue":   simulation.NewPosition(16, 144),
+			"Green":  simulation.NewPosition(96, 144),
+			"Purple": simulation.NewPosition(176, 144),
+			"Orange": simulation.NewPosition(256, 144),
+		},
+		script: func(_ uint64, random *simulation.Random) map[string]simulation.Input {
+			inputs := make(map[string]simulation.Input)
+			for _, name := range []string{"Blue", "Green", "Orange", "Purple"} {
+				buttons := random.Intn(16)
+				inputs[name] = simulation.Input{
+					Left:   buttons&1 != 0,
+					Right:  buttons&2 != 0,
+					Jump:   buttons&4 != 0 && random.Intn(4) == 0,
+					Crouch: buttons&8 != 0,
+				}
+			}
+
+			return inputs
+		},
+	},
+}
+
+type bodyRecord struct {
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	VX        float64 `json:"vx"`
+	VY        float64 `json:"vy"`
+	Grounded  bool    `json:"grounded"`
+	Facing    string  `json:"facing"`
+	Animation int     `json:"animation"`
+}
+
+type frameRecord struct {
+	Frame     uint64                `json:"frame"`
+	Players   map[string]bodyRecord `json:"players"`
+	Collected map[string]string     `json:"collected,omitempty"`
+}
+
+func record(sim *simulation.Simulation) frameRecord {
+	snapshot := sim.Snapshot()
+	frame := frameRecord{Frame: snapshot.Frame, Players: make(map[string]bodyRecord)}
+
+	for name, body := range snapshot.Players {
+		frame.Players[name] = bodyRecord{
+			X:         body.Position.X,
+			Y:         body.Position.Y,
+			VX:        body.Velocity.X,
+			VY:        body.Velocity.Y,
+			Grounded:  body.Grounded,
+			Facing:    body.Facing.String(),
+			Animation: int(body.Animation()),
+		}
+	}
+
+	for position, name := range snapshot.Collected {
+		if frame.Collected == nil {
+			frame.Collected = make(map[string]string)
+		}
+
+		frame.Collected[fmt.Sprintf("%g,%g", position.X, position.Y)] = name
+	}
+
+	return frame
+}
+
+// How often a frame is written to the golden file.
+const recordEvery = 10
+
+/*
+run plays a scenario from the start. If restoreAt is non-zero, the simulation is
+snapshotted at that tick, run on with different inputs for a while, and then restored
+and resimulated with the scripted inputs, as a rollback would.
+*/
+func run(sc scenario, restoreAt uint64) []frameRecord {
+	sim := simulation.New(newWorld(), simulation.DefaultPhysics(), sc.seed)
+	for name, position := range sc.players {
+		sim.AddPlayer(name, simulation.NewBody(position))
+	}
+
+	// The script draws from its own generator, so that it doesn't take numbers from the
+	// simulation's.
+	scriptRandom := simulation.NewRandom(sc.seed)
+	frames := []frameRecord{}
+
+	for tick := uint64(0); tick < sc.ticks; tick++ {
+		if tick == restoreAt && restoreAt != 0 {
+			snapshot := sim.Snapshot()
+
+			for i := 0; i < 8; i++ {
+				sim.Step(map[string]simulation.Input{})
+			}
+
+			sim.Restore(snapshot)
+		}
+
+		if tick%recordEvery == 0 {
+			frames = append(frames, record(sim))
+		}
+
+		sim.Step(sc.script(tick, &scriptRandom))
+	}
+
+	return append(frames, record(sim))
+}
+
+// TestFixedStep checks that FixedStep gives TickRate ticks per second, however irregular the calls to it are.
+func TestFixedStep(t *testing.T) {
+	clock := simulation.NewManualClock(time.Unix(0, 0))
+	stepper := simulation.NewFixedStep(clock)
+
+	total := stepper.Ticks()
+	random := simulation.NewRandom(0)
+
+	var elapsed time.Duration
+	for elapsed < 10*time.Second {
+		frame := time.Duration(5+random.Intn(40)) * time.Millisecond
+		clock.Advance(frame)
+		elapsed += frame
+		total += stepper.Ticks()
+	}
+
+	// The first call is a tick of its own.
+	expected := 1 + int(elapsed/simulation.TickDuration)
+	if total != expected {
+		t.Fatalf("FixedStep gave %d ticks over %s, expected %d", total, elapsed, expected)
+	}
+}
+
+/*
+TestSimulationGolden replays scripted input streams through the simulation, and checks
+the result against the golden file. Every scenario also has to give the same result on a
+second run, and on a run that is restored from a snapshot part of the way through.
+*/
+func TestSimulationGolden(t *testing.T) {
+	results := make(map[string][]frameRecord)
+	for _, sc := range scenarios {
+		results[sc.name] = run(sc, 0)
+	}
+
+	if *update {
+		content, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			t.Fatalf("could not marshal results: %s", err.Error())
+		}
+
+		if err := os.WriteFile(goldenPath, append(content, '\n'), 0o644); err != nil {
+			t.Fatalf("could not write golden file \"%s\": %s", goldenPath, err.Error())
+		}
+
+		t.Logf("Wrote %d scenarios to %s", len(results), goldenPath)
+		return
+	}
+
+	content, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("could not read golden file \"%s\": %s", goldenPath, err.Error())
+	}
+
+	var golden map[string]json.RawMessage
+	if err := json.Unmarshal(content, &golden); err != nil {
+		t.Fatalf("could not parse golden file \"%s\": %s", goldenPath, err.Error())
+	}
+
+	if len(golden) != len(scenarios) {
+		t.Errorf("golden file has %d scenarios, expected %d, run with -update if this was intended", len(golden), len(scenarios))
+	}
+
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			first := marshal(t, results[sc.name])
+
+			if second := marshal(t, run(sc, 0)); !bytes.Equal(first, second) {
+				t.Fatal("gave different results on a second run")
+			}
+
+			if restored := marshal(t, run(sc, sc.ticks/2)); !bytes.Equal(first, restored) {
+				t.Fatal("gave different results after being restored from a snapshot")
+			}
+
+			expected, ok := golden[sc.name]
+			if !ok {
+				t.Fatal("isn't in the golden file, run with -update if this was intended")
+			}
+
+			var compacted bytes.Buffer
+			if err := json.Compact(&compacted, expected); err != nil {
+				t.Fatalf("could not read golden results: %s", err.Error())
+			}
+
+			if !bytes.Equal(first, compacted.Bytes()) {
+				t.Fatalf("no longer matches %s, run with -update if this was intended", goldenPath)
+			}
+		})
+	}
+}
+
+func marshal(t *testing.T, frames []frameRecord) []byte {
+	t.Helper()
+
+	content, err := json.Marshal(frames)
+	if err != nil {
+		t.Fatalf("could not marshal results: %s", err.Error())
+	}
+
+	return content
+}
@@ -0,0 +1,37 @@
+package simulation
+
+/*
+Input is a single sequenced input command sent from a client to the server. The server
+runs the movement simulation from these commands rather than accepting a position from
+the client.
+
+ViewTime is the client's estimate of the server's time when the input was applied
+locally, as Unix time in nanoseconds, and RoundTrip is the client's latest round trip
+time to the server in nanoseconds. These let the server rewind to what the client saw
+when it resolves touches, see models.LagCompensator.
+*/
+type Input struct {
+	Sequence  uint64 `json:"seq"`
+	Tick      uint64 `json:"tick"`
+	Left      bool   `json:"left,omitempty"`
+	Right     bool   `json:"right,omitempty"`
+	Jump      bool   `json:"jump,omitempty"`
+	Crouch    bool   `json:"crouch,omitempty"`
+	ViewTime  int64  `json:"view_time,omitempty"`
+	RoundTrip int64  `json:"rtt,omitempty"`
+}
+
+// IsEmpty reports whether the input has no movement keys held down.
+func (input Input) IsEmpty() bool {
+	return !input.Left && !input.Right && !input.Jump && !input.Crouch
+}
+
+// WithoutMovement returns a copy of the input with all movement keys released.
+func (input Input) WithoutMovement() Input {
+	return Input{
+		Sequence:  input.Sequence,
+		Tick:      input.Tick,
+		ViewTime:  input.ViewTime,
+		RoundTrip: input.RoundTrip,
+	}
+}
@@ -0,0 +1,116 @@
+package simulation
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Physics holds every constant that the movement simulation uses. Distances are in pixels,
+speeds are in pixels per tick, and accelerations are in pixels per tick per tick. Positive
+y is downwards, as in the rest of the game.
+
+The client and the server have to simulate with the same Physics, so the server sends
+its own to each client when it connects.
+*/
+type Physics struct {
+	// How quickly a player speeds up towards, and slows down from, MaxRunSpeed.
+	RunAcceleration float64 `yaml:"run_acceleration" json:"run_acceleration"`
+	RunDeceleration float64 `yaml:"run_deceleration" json:"run_deceleration"`
+	MaxRunSpeed     float64 `yaml:"max_run_speed" json:"max_run_speed"`
+
+	// How much of RunAcceleration and RunDeceleration applies while in the air.
+	AirControl float64 `yaml:"air_control" json:"air_control"`
+
+	Gravity          float64 `yaml:"gravity" json:"gravity"`
+	TerminalVelocity float64 `yaml:"terminal_velocity" json:"terminal_velocity"`
+
+	// The upwards speed given by a jump. If jump is released while still rising, the
+	// upwards speed is multiplied by JumpCutMultiplier, which gives shorter jumps for
+	// shorter presses.
+	JumpVelocity      float64 `yaml:"jump_velocity" json:"jump_velocity"`
+	JumpCutMultiplier float64 `yaml:"jump_cut_multiplier" json:"jump_cut_multiplier"`
+
+	// StepHeight is the highest that a player on the ground is lifted or lowered by to
+	// follow the ground, e.g. when walking up or down a slope. It has to be at least
+	// MaxRunSpeed for players to be able to walk up 45° slopes, and half of PlayerSize to
+	// walk from the top of a slope onto a tile next to it.
+	StepHeight float64 `yaml:"step_height" json:"step_height"`
+
+	// CoyoteTicks is how long after walking off a ledge a player can still jump, and
+	// JumpBufferTicks is how long before landing a jump press is remembered for.
+	CoyoteTicks     int `yaml:"coyote_ticks" json:"coyote_ticks"`
+	JumpBufferTicks int `yaml:"jump_buffer_ticks" json:"jump_buffer_ticks"`
+}
+
+// DefaultPhysicsPath is the path of the physics file that is loaded if it exists.
+const DefaultPhysicsPath = "resources/physics.yml"
+
+/*
+DefaultPhysics returns the physics that the game was designed around, which gives a jump
+of roughly three tiles.
+*/
+func DefaultPhysics() Physics {
+	return Physics{
+		RunAcceleration:   0.5,
+		RunDeceleration:   0.5,
+		MaxRunSpeed:       2,
+		AirControl:        0.6,
+		Gravity:           0.35,
+		TerminalVelocity:  6,
+		JumpVelocity:      6,
+		JumpCutMultiplier: 0.5,
+		StepHeight:        8,
+		CoyoteTicks:       6,
+		JumpBufferTicks:   6,
+	}
+}
+
+/*
+LoadPhysics loads physics from a YAML file at path. Any constant missing from the file
+keeps its value from DefaultPhysics.
+*/
+func LoadPhysics(path string) (Physics, error) {
+	physics := DefaultPhysics()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return physics, fmt.Errorf("could not load physics from file \"%s\": %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(content, &physics); err != nil {
+		return physics, fmt.Errorf("could not load physics from file \"%s\": %w", path, err)
+	}
+
+	if err := physics.Validate(); err != nil {
+		return physics, fmt.Errorf("could not load physics from file \"%s\": %w", path, err)
+	}
+
+	return physics, nil
+}
+
+// Validate checks that the physics can be simulated with.
+func (p Physics) Validate() error {
+	switch {
+	case p.MaxRunSpeed <= 0:
+		return fmt.Errorf("max_run_speed must be positive, got %g", p.MaxRunSpeed)
+	case p.RunAcceleration <= 0 || p.RunDeceleration <= 0:
+		return fmt.Errorf("run_acceleration and run_deceleration must be positive")
+	case p.AirControl < 0 || p.AirControl > 1:
+		return fmt.Errorf("air_control must be between 0 and 1, got %g", p.AirControl)
+	case p.Gravity <= 0 || p.TerminalVelocity <= 0:
+		return fmt.Errorf("gravity and terminal_velocity must be positive")
+	case p.JumpVelocity < 0:
+		return fmt.Errorf("jump_velocity must not be negative, got %g", p.JumpVelocity)
+	case p.JumpCutMultiplier < 0 || p.JumpCutMultiplier > 1:
+		return fmt.Errorf("jump_cut_multiplier must be between 0 and 1, got %g", p.JumpCutMultiplier)
+	case p.StepHeight < p.MaxRunSpeed:
+		return fmt.Errorf("step_height must be at least max_run_speed (%g), got %g", p.MaxRunSpeed, p.StepHeight)
+	case p.CoyoteTicks < 0 || p.JumpBufferTicks < 0:
+		return fmt.Errorf("coyote_ticks and jump_buffer_ticks must not be negative")
+	default:
+		return nil
+	}
+}
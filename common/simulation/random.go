@@ -0,0 +1,39 @@
+package simulation
+
+/*
+Random is a small seeded pseudo-random number generator (SplitMix64). Unlike math/rand,
+its whole state is a single number, so it is saved and restored along with the rest of
+the simulation's state, and every peer that starts from the same seed draws the same
+numbers in the same order.
+*/
+type Random struct {
+	state uint64
+}
+
+func NewRandom(seed uint64) Random {
+	return Random{state: seed}
+}
+
+func (r *Random) Uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+
+	return z ^ (z >> 31)
+}
+
+// Intn returns a number in [0, n). It panics if n <= 0.
+func (r *Random) Intn(n int) int {
+	if n <= 0 {
+		panic("simulation: Intn called with n <= 0")
+	}
+
+	return int(r.Uint64() % uint64(n))
+}
+
+// Float64 returns a number in [0, 1).
+func (r *Random) Float64() float64 {
+	return float64(r.Uint64()>>11) / (1 << 53)
+}
@@ -0,0 +1,193 @@
+/*
+simulation provides the game simulation that is shared between cmd/client and cmd/server:
+the players, and the state of the map's tiles. The whole simulation can be saved and
+restored, which is what the rollback netcode mode relies on.
+
+The simulation is deterministic: it is stepped at a fixed TickRate, never reads the
+system's clock, and only draws random numbers from a seeded Random that is part of its
+state. Stepping the same State with the same inputs always gives the same result. This
+package must not import anything that draws, so that it can be run headless.
+*/
+package simulation
+
+import "sort"
+
+/*
+World is the level that the simulation is run in. It is implemented by maps.Map, but
+kept as an interface so that the simulation doesn't depend on how the level is loaded.
+*/
+type World interface {
+	Ground
+
+	// Collectables returns the position of every collectable tile that a player at
+	// (x, y) is touching.
+	Collectables(x, y int) []Position
+}
+
+/*
+State is everything in the simulation that can change from frame to frame. The map
+itself never changes, so only the tiles that have been collected are kept.
+*/
+type State struct {
+	Frame     uint64
+	Players   map[string]Body
+	Collected map[Position]string
+	Random    Random
+}
+
+// Copy returns a deep copy of the state.
+func (s State) Copy() State {
+	players := make(map[string]Body, len(s.Players))
+	for name, player := range s.Players {
+		players[name] = player
+	}
+
+	collected := make(map[Position]string, len(s.Collected))
+	for position, name := range s.Collected {
+		collected[position] = name
+	}
+
+	return State{Frame: s.Frame, Players: players, Collected: collected, Random: s.Random}
+}
+
+/*
+Simulation steps every player in a map one frame at a time, from one input per player per
+frame.
+*/
+type Simulation struct {
+	world   World
+	physics Physics
+	state   State
+}
+
+/*
+New creates an empty simulation in the given world. Simulations created with the same
+seed draw the same random numbers.
+*/
+func New(world World, physics Physics, seed uint64) *Simulation {
+	return &Simulation{
+		world:   world,
+		physics: physics,
+		state: State{
+			Players:   make(map[string]Body),
+			Collected: make(map[Position]string),
+			Random:    NewRandom(seed),
+		},
+	}
+}
+
+// AddPlayer adds a player to the simulation, replacing any player with the same name.
+func (s *Simulation) AddPlayer(name string, player Body) {
+	s.state.Players[name] = player
+}
+
+func (s *Simulation) RemovePlayer(name string) {
+	delete(s.state.Players, name)
+}
+
+func (s *Simulation) Frame() uint64 {
+	return s.state.Frame
+}
+
+func (s *Simulation) Player(name string) (Body, bool) {
+	player, ok := s.state.Players[name]
+
+	return player, ok
+}
+
+// Players returns the names of every player in the simulation, in the order they're
+// stepped in.
+func (s *Simulation) Players() []string {
+	names := make([]string, 0, len(s.state.Players))
+	for name := range s.state.Players {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// IsCollected reports whether the tile at the given position has been collected.
+func (s *Simulation) IsCollected(position Position) bool {
+	_, ok := s.state.Collected[position]
+
+	return ok
+}
+
+/*
+Random returns the simulation's random number generator. Anything in a step that needs
+randomness must draw from this, rather than from math/rand, so that it is reproduced
+when the step is resimulated.
+*/
+func (s *Simulation) Random() *Random {
+	return &s.state.Random
+}
+
+/*
+Snapshot returns a copy of the current state, which can later be passed to Restore to
+go back to this frame.
+*/
+func (s *Simulation) Snapshot() State {
+	return s.state.Copy()
+}
+
+// Restore replaces the current state with a previously taken snapshot.
+func (s *Simulation) Restore(snapshot State) {
+	s.state = snapshot.Copy()
+}
+
+/*
+Step advances the simulation by one frame. Players are stepped in name order so that
+every peer steps them identically, and players without an input for this frame are
+stepped with no keys held down. Players can stand on top of each other.
+*/
+func (s *Simulation) Step(inputs map[string]Input) {
+	for _, name := range s.Players() {
+		player := s.state.Players[name]
+		input := inputs[name]
+		input.Tick = s.state.Frame
+
+		player.Step(input, &playerGround{simulation: s, self: name}, &s.physics)
+		s.state.Players[name] = player
+
+		s.collect(name, player)
+	}
+
+	s.state.Frame++
+}
+
+func (s *Simulation) collect(name string, player Body) {
+	for _, position := range s.world.Collectables(int(player.Position.X), int(player.Position.Y)) {
+		if !s.IsCollected(position) {
+			s.state.Collected[position] = name
+		}
+	}
+}
+
+/*
+playerGround is the map's ground, along with every other player in the simulation. Other
+players are one-way solids, so players can stand on each other's heads but can still
+walk and jump past each other.
+*/
+type playerGround struct {
+	simulation *Simulation
+	self       string
+}
+
+func (pg *playerGround) Solids(area Rect) []Solid {
+	solids := pg.simulation.world.Solids(area)
+
+	for _, name := range pg.simulation.Players() {
+		if name == pg.self {
+			continue
+		}
+
+		other := pg.simulation.state.Players[name]
+		if rect := other.Rect(); rect.Overlaps(area) {
+			solids = append(solids, Solid{Rect: rect, OneWay: true})
+		}
+	}
+
+	return solids
+}
@@ -1,7 +1,7 @@
 package typedsockets
 
 import (
-	"bytes"
+	"bufio"
 	"errors"
 	"fmt"
 	"net"
@@ -9,52 +9,67 @@ import (
 	"strconv"
 )
 
+/*
+Messages sent over TCP are separated by this delimiter, as TCP is a stream of bytes
+rather than a series of datagrams. T's Marshal must therefore not produce it.
+*/
+const tcpMessageDelimiter = '\n'
+
 /*
 TCPTypedConnection is a TypedConnection that is suited for TCP connections, and provides
 TCP-specific function implementations.
 */
 type TCPTypedConnection[T Convertable] struct {
 	TypedConnection[T]
+	reader *bufio.Reader
 }
 
 /*
 NewTCPTypedConnection creates a new TCPTypedConnections specialised for T.
 */
 func NewTCPTypedConnection[T Convertable](conn net.Conn) TCPTypedConnection[T] {
-	return TCPTypedConnection[T]{TypedConnection[T]{conn: conn, connectionType: ConnectionTypeTCP}}
+	return TCPTypedConnection[T]{
+		TypedConnection: TypedConnection[T]{conn: conn, connectionType: ConnectionTypeTCP},
+		reader:          bufio.NewReader(conn),
+	}
 }
 
 /*
-ReadFrom reads from the inner connection, attempting to read a T from the connection. On
-success, the amount of bytes read is returned and the data parameter is populated with
-the read data from the connection. On failure, the amount of bytes read is still returned
-but so is an error. The data parameter is left untouched.
+Write attempts to write the data of type T to the connection, followed by the message
+delimiter. On success, it returns the amount of bytes that were written. On failure, it
+returns an error.
 */
-func (utc *TCPTypedConnection[T]) ReadFrom(data *T) (int64, error) {
-	switch conn := utc.conn.(type) {
-	case *net.TCPConn:
-		buffer := make([]byte, 4096)
-		reader := bytes.NewReader(buffer)
+func (utc *TCPTypedConnection[T]) Write(data T) (int, error) {
+	buffer, err := data.Marshal()
+	if err != nil {
+		return 0, errors.Join(errors.New("could not marshal data to write"), err)
+	}
 
-		amountRead, err := conn.ReadFrom(reader)
-		if err != nil {
-			return amountRead, errors.Join(errors.New("could not receive incoming buffer"), err)
-		}
+	return utc.conn.Write(append(buffer, tcpMessageDelimiter))
+}
 
-		resizedBuffer := buffer[:amountRead]
+/*
+ReadFrom reads from the inner connection, attempting to read a single delimited T from
+the connection. On success, the amount of bytes read is returned and the data parameter
+is populated with the read data from the connection. On failure, the amount of bytes read
+is still returned but so is an error. The data parameter is left untouched.
+*/
+func (utc *TCPTypedConnection[T]) ReadFrom(data *T) (int64, error) {
+	message, err := utc.reader.ReadBytes(tcpMessageDelimiter)
+	amountRead := int64(len(message))
+	if err != nil {
+		return amountRead, errors.Join(errors.New("could not receive incoming buffer"), err)
+	}
 
-		var newData T
-		err = newData.Unmarshal(&newData, resizedBuffer)
-		if err != nil {
-			return amountRead, errors.Join(fmt.Errorf("could not unmarshal incoming buffer into %s", reflect.TypeOf(data)))
-		}
+	var newData T
+	err = newData.Unmarshal(&newData, message[:len(message)-1])
+	if err != nil {
+		return amountRead, errors.Join(fmt.Errorf("could not unmarshal incoming buffer into %s", reflect.TypeOf(data)), err)
+	}
 
-		*data = newData
+	*data = newData
 
-		return amountRead, nil
-	default:
-		return 0, errors.New("conn is an invalid connection type for this method")
-	}
+	return amountRead, nil
 }
 
 /*
@@ -6,8 +6,21 @@ import (
 	"net"
 	"reflect"
 	"strconv"
+	"sync"
 )
 
+/*
+MaxDatagramSize is the largest payload that a single UDP datagram over IPv4 can carry.
+Everything that is sent over UDP has to fit in one, as nothing is split up.
+*/
+const MaxDatagramSize = 65507
+
+// readBuffers are reused between reads, as each one is big enough for the largest datagram.
+var readBuffers = sync.Pool{New: func() any {
+	buffer := make([]byte, MaxDatagramSize)
+	return &buffer
+}}
+
 /*
 UDPTypedConnection is a TypedConnection that is suited for UDP connections, and provides
 UDP-specific function implementations.
@@ -50,7 +63,10 @@ but so is an error. The data parameter is left untouched.
 func (utc *UDPTypedConnection[T]) ReadFrom(data *T) (int, net.Addr, error) {
 	switch conn := utc.conn.(type) {
 	case *net.UDPConn:
-		buffer := make([]byte, 4096)
+		pooled := readBuffers.Get().(*[]byte)
+		defer readBuffers.Put(pooled)
+
+		buffer := *pooled
 
 		amountRead, addr, err := conn.ReadFrom(buffer)
 		if err != nil {
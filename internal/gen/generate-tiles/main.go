@@ -39,12 +39,36 @@ type Variant struct {
 	Symbol     string
 	Collidable bool
 	Touchable  bool
+	OneWay     bool   `yaml:"one_way,omitempty"`
+	Slope      string `yaml:"slope,omitempty"`
 }
 
 func (v Variant) GetSuffix() string {
 	return strings.ToLower(v.Suffix)
 }
 
+func (v Variant) GetSlope() (int, error) {
+	return slopeDirection(v.Slope)
+}
+
+/*
+slopeDirection converts the slope property of a tile into the direction the slope rises
+in: 1 for a slope rising to the right ("rising"), -1 for a slope rising to the left
+("falling"), and 0 for a tile that isn't a slope.
+*/
+func slopeDirection(slope string) (int, error) {
+	switch slope {
+	case "":
+		return 0, nil
+	case "rising":
+		return 1, nil
+	case "falling":
+		return -1, nil
+	default:
+		return 0, fmt.Errorf("unknown slope \"%s\", expected \"rising\" or \"falling\"", slope)
+	}
+}
+
 type Tile struct {
 	Name       string    `yaml:"name" json:"name"`
 	Frames     []Bounds  `yaml:"frames,omitempty" json:"frames,omitempty"`
@@ -53,12 +77,18 @@ type Tile struct {
 	Symbol     string    `yaml:"symbol,omitempty" json:"symbol,omitempty"`
 	Collidable bool      `yaml:"collidable,omitempty" json:"collidable,omitempty"`
 	Touchable  bool      `yaml:"touchable,omitempty" json:"touchable,omitempty"`
+	OneWay     bool      `yaml:"one_way,omitempty" json:"one_way,omitempty"`
+	Slope      string    `yaml:"slope,omitempty" json:"slope,omitempty"`
 }
 
 func (t Tile) GetName() string {
 	return strings.ToLower(t.Name)
 }
 
+func (t Tile) GetSlope() (int, error) {
+	return slopeDirection(t.Slope)
+}
+
 type Config struct {
 	Sheet string
 	Tiles []Tile
@@ -66,17 +96,19 @@ type Config struct {
 
 var tilesTemplate = template.Must(template.New("").Parse(`// Code generated by go generate; DO NOT EDIT
 
-package tiles
+package sprites
 
 import (
 	"image"
-	"time"
 
-	"fyp/common/ctypes"
+	"fyp/common/simulation"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
+// How many ticks each frame of an animated tile is shown for, which is 250ms.
+const animationTicks = simulation.TickRate / 4
+
 // Sprites from "{{ .Sheet }}"
 
 type Tiles struct{
@@ -84,10 +116,10 @@ type Tiles struct{
 	{{$tile.Name}} {{$tile.GetName}}
 	{{end}}
 
-	lastAnimated time.Time
+	ticksSinceAnimated int
 }
 
-func Initialise(sheet *ctypes.Spritesheet) Tiles {
+func Initialise(sheet *Spritesheet) Tiles {
 {{range $tile := .Tiles}}
 	var _{{$tile.GetName}} {{$tile.GetName}}
 	_{{$tile.GetName}}.init(sheet)
@@ -100,8 +132,10 @@ func Initialise(sheet *ctypes.Spritesheet) Tiles {
 	}
 }
 
+// StepAnimateTiles should be called once per simulation tick, see simulation.TickRate.
 func (tiles *Tiles) StepAnimateTiles() {
-	if time.Since(tiles.lastAnimated).Milliseconds() < 250 {
+	tiles.ticksSinceAnimated++
+	if tiles.ticksSinceAnimated < animationTicks {
 		return
 	}
 {{range $tile := .Tiles}}
@@ -110,7 +144,7 @@ func (tiles *Tiles) StepAnimateTiles() {
 {{end}}
 {{end}}
 
-	tiles.lastAnimated = time.Now()
+	tiles.ticksSinceAnimated = 0
 }
 
 {{range $tile := .Tiles}}
@@ -120,7 +154,7 @@ type {{$tile.GetName}} struct {
 	{{end}}
 }
 
-func (t *{{$tile.GetName}}) init(sheet *ctypes.Spritesheet) {
+func (t *{{$tile.GetName}}) init(sheet *Spritesheet) {
 	s, err := sheet.Sheet()
 	if err != nil {
 		panic("couldn't init {{$tile.Name}} sprites: " + err.Error())
@@ -147,7 +181,7 @@ type {{$tile.GetName}} struct{
 	totalFrames	 int
 }
 
-func (t *{{$tile.GetName}}) init(sheet *ctypes.Spritesheet) {
+func (t *{{$tile.GetName}}) init(sheet *Spritesheet) {
 	frames := []*ebiten.Image{}
 	s, err := sheet.Sheet()
 	if err != nil {
@@ -184,7 +218,7 @@ type {{$tile.GetName}} struct{
 	image *ebiten.Image
 }
 
-func (t *{{$tile.GetName}}) init(sheet *ctypes.Spritesheet) {
+func (t *{{$tile.GetName}}) init(sheet *Spritesheet) {
 	s, err := sheet.Sheet()
 	if err != nil {
 		panic("couldn't init {{$tile.Name}} sprites: " + err.Error())
@@ -209,16 +243,16 @@ var enumsTemplate = template.Must(template.New("").Parse(strings.ReplaceAll(`//
 
 package tiles
 
-type types int // Symbol[string],Collidable[bool],Touchable[bool]
+type types int // Symbol[string],Collidable[bool],Touchable[bool],OneWay[bool],Slope[int]
 
 //revive:disable:var-naming
 //go:generate goenums tile_types.go
 const ({{range $i, $tile := .Tiles }}
 	{{if not $tile.Variants }}
-	{{$tile.GetName}}_tile{{if (eq $i 0)}} types = iota{{end}} // ¬{{$tile.Symbol}}¬,{{$tile.Collidable}},{{$tile.Touchable}}
+	{{$tile.GetName}}_tile{{if (eq $i 0)}} types = iota{{end}} // ¬{{$tile.Symbol}}¬,{{$tile.Collidable}},{{$tile.Touchable}},{{$tile.OneWay}},{{$tile.GetSlope}}
 	{{else if $tile.Variants}}
 	{{range $variant := $tile.Variants}}
-	{{$tile.GetName}}_{{$variant.GetSuffix}}_tile{{if (eq $i 0)}} types = iota{{end}} // ¬{{$variant.Symbol}}¬,{{$variant.Collidable}},{{$variant.Touchable}}
+	{{$tile.GetName}}_{{$variant.GetSuffix}}_tile{{if (eq $i 0)}} types = iota{{end}} // ¬{{$variant.Symbol}}¬,{{$variant.Collidable}},{{$variant.Touchable}},{{$variant.OneWay}},{{$variant.GetSlope}}
 	{{end}}
 	{{end}}
 	{{end}}
@@ -261,7 +295,9 @@ func main() {
 		printErrorAndExit("could not parse yaml file: %s", err)
 	}
 
-	outFile, err := os.Create("tiles/tiles.go")
+	// The sprites are kept apart from the tile types, so that the tile types can be used
+	// without depending on ebiten.
+	outFile, err := os.Create("sprites/tiles.go")
 	if err != nil {
 		printErrorAndExit("could output spritesheet tile data to go file: %s", err)
 	}
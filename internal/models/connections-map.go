@@ -63,24 +63,20 @@ type ConnectionsMapIterType[T typedConnections] struct {
 
 /*
 Iter allows for doing a `for-range` loop over the connections map inside the
-ConnectionsMap struct. The loop holds a lock for the entirety of the contents
-of the map, thus more entries will increase the amount of time this function
-keeps the lock.
+ConnectionsMap struct. The entries are copied while the lock is held, so the lock is
+released before the loop starts, and the loop can stop early or change the map.
 */
 func (cm *ConnectionsMap[T]) Iter() <-chan ConnectionsMapIterType[T] {
-	iterChannel := make(chan ConnectionsMapIterType[T])
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
 
-	go func() {
-		cm.mutex.RLock()
-		defer cm.mutex.RUnlock()
+	iterChannel := make(chan ConnectionsMapIterType[T], len(cm.connections))
 
-		for key, value := range cm.connections {
-			value := *value
-			iterChannel <- ConnectionsMapIterType[T]{ID: key, Conn: value}
-		}
+	for key, value := range cm.connections {
+		iterChannel <- ConnectionsMapIterType[T]{ID: key, Conn: *value}
+	}
 
-		close(iterChannel)
-	}()
+	close(iterChannel)
 
 	return iterChannel
 }
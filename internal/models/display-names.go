@@ -0,0 +1,54 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The shortest and longest display names that are allowed, in characters.
+const (
+	MinDisplayNameLength = 1
+	MaxDisplayNameLength = 16
+)
+
+/*
+ValidateDisplayName checks that a display name is a sensible length and only uses
+letters, digits, spaces, underscores and hyphens, without leading or trailing spaces. It
+doesn't check that the name is unique, see ServerState.AddPlayer.
+*/
+func ValidateDisplayName(name string) error {
+	return validateName("display name", name, MinDisplayNameLength, MaxDisplayNameLength)
+}
+
+// validateName checks a name that people pick for things, see ValidateDisplayName.
+func validateName(kind, name string, minLength, maxLength int) error {
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%s is not valid UTF-8", kind)
+	}
+
+	length := utf8.RuneCountInString(name)
+	if length < minLength || length > maxLength {
+		return fmt.Errorf("%s must be between %d and %d characters, got %d", kind, minLength, maxLength, length)
+	}
+
+	if strings.TrimSpace(name) != name {
+		return fmt.Errorf("%s can't start or end with a space", kind)
+	}
+
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' || r == '_' || r == '-' {
+			continue
+		}
+
+		return fmt.Errorf("%s can't contain %q, only letters, digits, spaces, underscores and hyphens", kind, r)
+	}
+
+	return nil
+}
+
+// sameDisplayName reports whether two display names would be mistaken for each other.
+func sameDisplayName(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
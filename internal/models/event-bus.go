@@ -0,0 +1,138 @@
+package models
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy is what a subscription does when its queue is full.
+type OverflowPolicy int
+
+const (
+	// DropNewest drops the event being published, keeping the queue as it is.
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the oldest event in the queue to make room.
+	DropOldest
+	// Block makes the publisher wait until there is room, which holds up every change to
+	// the server state, so it should only be used by subscribers that can't miss events
+	// and always keep up, e.g. persistence.
+	Block
+)
+
+/*
+Subscription is a single subscriber's queue of events. Events are received from Events,
+which is closed once the subscription is closed.
+*/
+type Subscription struct {
+	Name   string
+	Events <-chan Event
+
+	events  chan Event
+	policy  OverflowPolicy
+	dropped atomic.Uint64
+	done    chan struct{}
+	once    sync.Once
+	bus     *EventBus
+}
+
+// Dropped returns how many events have been dropped because the queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+/*
+Close unsubscribes from the bus and closes Events. Any events still in the queue can be
+received before Events is closed. It is safe to call Close more than once.
+*/
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		// Closing done first releases any publisher that is blocked on this subscription,
+		// so that the bus can be locked.
+		close(s.done)
+
+		s.bus.mutex.Lock()
+		defer s.bus.mutex.Unlock()
+
+		delete(s.bus.subscriptions, s)
+		close(s.events)
+	})
+}
+
+func (s *Subscription) deliver(event Event) {
+	select {
+	case s.events <- event:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case DropOldest:
+		// Another publisher may fill the space first, in which case this event is the
+		// one dropped.
+		select {
+		case <-s.events:
+			s.dropped.Add(1)
+		default:
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	case Block:
+		select {
+		case s.events <- event:
+		case <-s.done:
+		}
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+/*
+EventBus passes events from the server state to any number of subscribers, e.g. logging,
+metrics, persistence or webhooks. Every subscriber has its own bounded queue, so a slow
+subscriber only loses its own events, or holds up publishing if it asked to.
+*/
+type EventBus struct {
+	mutex         sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscriptions: make(map[*Subscription]struct{})}
+}
+
+/*
+Subscribe adds a subscriber with a queue of the given size, and the given policy for
+when the queue is full. The name is only used to identify the subscriber.
+*/
+func (b *EventBus) Subscribe(name string, size int, policy OverflowPolicy) *Subscription {
+	events := make(chan Event, max(size, 1))
+	subscription := &Subscription{
+		Name:   name,
+		Events: events,
+		events: events,
+		policy: policy,
+		done:   make(chan struct{}),
+		bus:    b,
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.subscriptions[subscription] = struct{}{}
+
+	return subscription
+}
+
+// Publish delivers the event to every subscriber.
+func (b *EventBus) Publish(event Event) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for subscription := range b.subscriptions {
+		subscription.deliver(event)
+	}
+}
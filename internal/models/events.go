@@ -0,0 +1,221 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/tiles"
+)
+
+// EventMeta is what every event has in common.
+type EventMeta struct {
+	// Version is the version of the server state that the event produced, see Snapshot.
+	Version uint64
+	At      time.Time
+}
+
+func (meta EventMeta) Meta() EventMeta {
+	return meta
+}
+
+/*
+Event is anything that is published on an EventBus. Subscribers tell events apart with a
+type switch.
+*/
+type Event interface {
+	Meta() EventMeta
+	String() string
+
+	withMeta(meta EventMeta) Event
+}
+
+// PlayerJoined is published when a player is added to the server state.
+type PlayerJoined struct {
+	EventMeta
+	// ID is the ID of the player's client.
+	ID     string
+	Player ctypes.Player
+}
+
+func (e PlayerJoined) String() string {
+	return fmt.Sprintf("%s (%s) joined at (%.0f, %.0f)", e.Player.DisplayName, e.ID, e.Player.Position.X, e.Player.Position.Y)
+}
+
+func (e PlayerJoined) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PlayerLeft is published when a player is removed from the server state.
+type PlayerLeft struct {
+	EventMeta
+	ID          string
+	DisplayName string
+}
+
+func (e PlayerLeft) String() string {
+	return fmt.Sprintf("%s (%s) left", e.DisplayName, e.ID)
+}
+
+func (e PlayerLeft) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PlayerMoved is published whenever a player is simulated, with the player afterwards.
+type PlayerMoved struct {
+	EventMeta
+	ID     string
+	Player ctypes.Player
+}
+
+func (e PlayerMoved) String() string {
+	return fmt.Sprintf("%s (%s) moved to (%.1f, %.1f)", e.Player.DisplayName, e.ID, e.Player.Position.X, e.Player.Position.Y)
+}
+
+func (e PlayerMoved) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// MatchStateChanged is published when the match moves from one phase to another.
+type MatchStateChanged struct {
+	EventMeta
+	Previous MatchState
+	Current  MatchState
+	Round    int
+}
+
+func (e MatchStateChanged) String() string {
+	return fmt.Sprintf("match %d went from %s to %s", e.Round, e.Previous, e.Current)
+}
+
+func (e MatchStateChanged) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PlayerReadied is published when a player says whether they are ready for the next match.
+type PlayerReadied struct {
+	EventMeta
+	ID          string
+	DisplayName string
+	Ready       bool
+}
+
+func (e PlayerReadied) String() string {
+	if e.Ready {
+		return fmt.Sprintf("%s (%s) is ready", e.DisplayName, e.ID)
+	}
+
+	return fmt.Sprintf("%s (%s) is no longer ready", e.DisplayName, e.ID)
+}
+
+func (e PlayerReadied) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PlayerTouchedTile is published when a player touches a tile that the game mode cares about.
+type PlayerTouchedTile struct {
+	EventMeta
+	ID          string
+	DisplayName string
+	Tile        tiles.Types
+}
+
+func (e PlayerTouchedTile) String() string {
+	return fmt.Sprintf("%s (%s) touched %s", e.DisplayName, e.ID, e.Tile.String())
+}
+
+func (e PlayerTouchedTile) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PickupCollected is published when a player collects a pickup, with their score afterwards.
+type PickupCollected struct {
+	EventMeta
+	ID          string
+	DisplayName string
+	Tile        tiles.Types
+	Position    ctypes.Position
+	Score       int
+}
+
+func (e PickupCollected) String() string {
+	return fmt.Sprintf("%s (%s) collected %s at (%.0f, %.0f), and has a score of %d", e.DisplayName, e.ID, e.Tile.String(), e.Position.X, e.Position.Y, e.Score)
+}
+
+func (e PickupCollected) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PickupsRespawned is published when pickups come back after being collected.
+type PickupsRespawned struct {
+	EventMeta
+	Positions []ctypes.Position
+}
+
+func (e PickupsRespawned) String() string {
+	return fmt.Sprintf("%d pickups came back", len(e.Positions))
+}
+
+func (e PickupsRespawned) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PlayerHurt is published when a player is hurt, with the health they have left.
+type PlayerHurt struct {
+	EventMeta
+	ID          string
+	DisplayName string
+	Cause       string
+	Health      int
+}
+
+func (e PlayerHurt) String() string {
+	return fmt.Sprintf("%s (%s) was hurt by %s, and has %d health left", e.DisplayName, e.ID, e.Cause, e.Health)
+}
+
+func (e PlayerHurt) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// PlayerDied is published when a player dies, after which they have already respawned.
+type PlayerDied struct {
+	EventMeta
+	ID          string
+	DisplayName string
+	Cause       string
+}
+
+func (e PlayerDied) String() string {
+	return fmt.Sprintf("%s (%s) was killed by %s", e.DisplayName, e.ID, e.Cause)
+}
+
+func (e PlayerDied) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
+
+// CheckpointReached is published when a player reaches a checkpoint, which is where they respawn from then on.
+type CheckpointReached struct {
+	EventMeta
+	ID          string
+	DisplayName string
+	Position    ctypes.Position
+}
+
+func (e CheckpointReached) String() string {
+	return fmt.Sprintf("%s (%s) reached the checkpoint at (%.0f, %.0f)", e.DisplayName, e.ID, e.Position.X, e.Position.Y)
+}
+
+func (e CheckpointReached) withMeta(meta EventMeta) Event {
+	e.EventMeta = meta
+	return e
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/tiles"
+)
+
+// How long the timed parts of each game mode last, unless the room says otherwise.
+const (
+	// DefaultFinishGrace is how long everyone else has to finish a race once the first player has.
+	DefaultFinishGrace = 15 * time.Second
+	// DefaultCoinsTimeLimit is how long players have to collect pickups.
+	DefaultCoinsTimeLimit = time.Minute
+	// DefaultCoopTimeLimit is how long everyone has to reach the door together.
+	DefaultCoopTimeLimit = 3 * time.Minute
+)
+
+/*
+GameMode decides how a room's matches are played and won. A room's match goes through the
+same phases whatever its mode is, see Match, but while it is being played the mode decides
+what touching a tile does, when the match is over and who won.
+
+Every hook is called from inside a change to the server state, so a mode keeps everything
+it needs in the match it is given rather than in itself, and is only ever given the copy
+of the match that is about to be published. Players are given by the ID of their client.
+*/
+type GameMode interface {
+	// Name is what the mode is picked by when a room is created.
+	Name() GameModeName
+	// PlayerJoined is called when a player joins the room, whatever phase the match is in.
+	PlayerJoined(id string, match *Match)
+	// PlayerLeft is called when a player leaves the room, once the match has forgotten them.
+	PlayerLeft(id string, match *Match)
+	// Started is called when the match starts being played, with every player in the room.
+	Started(now time.Time, players map[string]ctypes.Player, match *Match)
+	// Tick is called regularly while the match is being played, and returns whether it is over.
+	Tick(now time.Time, players map[string]ctypes.Player, match *Match) bool
+	/*
+		TileTouched is called when a player touches a tile that isn't a pickup, e.g. the door,
+		while the match is being played, at the time they saw themselves touch it. It returns
+		whether the match changed.
+	*/
+	TileTouched(id string, tile tiles.Types, at time.Time, match *Match) bool
+	/*
+		PickupCollected is called when a player collects a pickup while the match is being
+		played, with what it is worth, once the lag compensator has decided who touched it
+		first, see LagCompensator. The player's score has already had the pickup's added to it.
+	*/
+	PickupCollected(id string, tile tiles.Types, pickup Pickup, match *Match)
+	// Results returns every player that placed, best first, and a summary of how the match ended.
+	Results(players map[string]ctypes.Player, match *Match) ([]string, string)
+}
+
+// GameModes returns the name of every game mode that a room can be created with.
+func GameModes() []GameModeName {
+	return []GameModeName{GameModeRace, GameModeCoins, GameModeCoop}
+}
+
+// NewGameMode returns the game mode with the given name, with its default timings.
+func NewGameMode(name GameModeName) (GameMode, error) {
+	switch name {
+	case GameModeRace:
+		return RaceMode{FinishGrace: DefaultFinishGrace}, nil
+	case GameModeCoins:
+		return CoinsMode{TimeLimit: DefaultCoinsTimeLimit}, nil
+	case GameModeCoop:
+		return CoopMode{TimeLimit: DefaultCoopTimeLimit}, nil
+	}
+
+	names := make([]string, 0, len(GameModes()))
+	for _, mode := range GameModes() {
+		names = append(names, string(mode))
+	}
+
+	return nil, fmt.Errorf("unknown game mode %q, must be one of %s", name, strings.Join(names, ", "))
+}
+
+// isExit reports whether touching the tile finishes the level, see maps.Map.ReachedExit.
+func isExit(tile tiles.Types) bool {
+	return tile == tiles.Typeses.DOOR_OPENED_TILE
+}
+
+/*
+RaceMode is won by the first player to reach the door. Once they have, everyone else has
+FinishGrace to finish too, and they place in the order they saw themselves reach the door,
+see Match.finish.
+*/
+type RaceMode struct {
+	FinishGrace time.Duration
+}
+
+func (RaceMode) Name() GameModeName {
+	return GameModeRace
+}
+
+func (RaceMode) PlayerJoined(string, *Match) {}
+
+func (RaceMode) PlayerLeft(string, *Match) {}
+
+func (RaceMode) Started(time.Time, map[string]ctypes.Player, *Match) {}
+
+func (RaceMode) Tick(now time.Time, players map[string]ctypes.Player, match *Match) bool {
+	return match.everyoneFinished(players) || match.timeUp(now)
+}
+
+func (r RaceMode) TileTouched(id string, tile tiles.Types, at time.Time, match *Match) bool {
+	if !isExit(tile) {
+		return false
+	}
+
+	place, ok := match.finish(id, at)
+	if !ok {
+		return false
+	}
+
+	// The first player to finish starts the clock on everyone else, even if they were
+	// placed ahead of someone who the server heard from first.
+	if place == 1 {
+		match.EndsAt = at.Add(r.FinishGrace)
+	}
+
+	return true
+}
+
+func (RaceMode) PickupCollected(string, tiles.Types, Pickup, *Match) {}
+
+func (RaceMode) Results(players map[string]ctypes.Player, match *Match) ([]string, string) {
+	if len(match.finished) == 0 {
+		return nil, "Nobody reached the door"
+	}
+
+	return match.Finished(), fmt.Sprintf("%s won the race", players[match.finished[0]].DisplayName)
+}
+
+/*
+CoinsMode is won by whoever scores the most points within TimeLimit, by collecting coins
+and any other pickup that is worth something, see Pickups. Reaching the door does nothing.
+Pickups are only seen by the server in the modes that it simulates players in, so in
+rollback mode nobody ever scores.
+*/
+type CoinsMode struct {
+	TimeLimit time.Duration
+}
+
+func (CoinsMode) Name() GameModeName {
+	return GameModeCoins
+}
+
+// PlayerJoined gives a player that joins part of the way through a score, so they are placed.
+func (CoinsMode) PlayerJoined(id string, match *Match) {
+	if match.State == MatchPlaying {
+		match.scores[id] = 0
+	}
+}
+
+func (CoinsMode) PlayerLeft(string, *Match) {}
+
+func (c CoinsMode) Started(now time.Time, players map[string]ctypes.Player, match *Match) {
+	match.EndsAt = now.Add(c.TimeLimit)
+
+	for id := range players {
+		match.scores[id] = 0
+	}
+}
+
+func (CoinsMode) Tick(now time.Time, _ map[string]ctypes.Player, match *Match) bool {
+	return match.timeUp(now)
+}
+
+func (CoinsMode) TileTouched(string, tiles.Types, time.Time, *Match) bool {
+	return false
+}
+
+// PickupCollected scores the pickup for the player by what it is worth, so the match's scores are the players' own.
+func (CoinsMode) PickupCollected(id string, _ tiles.Types, pickup Pickup, match *Match) {
+	match.scores[id] += pickup.Score
+}
+
+// Results places players by their score, and then by name so that ties are placed the same way every time.
+func (CoinsMode) Results(players map[string]ctypes.Player, match *Match) ([]string, string) {
+	standings := make([]string, 0, len(match.scores))
+	for id := range match.scores {
+		standings = append(standings, id)
+	}
+
+	slices.SortFunc(standings, func(a, b string) int {
+		if byScore := match.scores[b] - match.scores[a]; byScore != 0 {
+			return byScore
+		}
+
+		return strings.Compare(players[a].DisplayName, players[b].DisplayName)
+	})
+
+	switch {
+	case len(standings) == 0 || match.scores[standings[0]] == 0:
+		return standings, "Nobody scored any points"
+	case len(standings) > 1 && match.scores[standings[0]] == match.scores[standings[1]]:
+		return standings, fmt.Sprintf("It's a tie, with %d points", match.scores[standings[0]])
+	default:
+		return standings, fmt.Sprintf("%s scored the most, with %d points", players[standings[0]].DisplayName, match.scores[standings[0]])
+	}
+}
+
+/*
+CoopMode is won by everyone together, if every player in the room reaches the door within
+TimeLimit. A player that joins part of the way through has to reach it too.
+*/
+type CoopMode struct {
+	TimeLimit time.Duration
+}
+
+func (CoopMode) Name() GameModeName {
+	return GameModeCoop
+}
+
+func (CoopMode) PlayerJoined(string, *Match) {}
+
+func (CoopMode) PlayerLeft(string, *Match) {}
+
+func (c CoopMode) Started(now time.Time, _ map[string]ctypes.Player, match *Match) {
+	match.EndsAt = now.Add(c.TimeLimit)
+}
+
+func (CoopMode) Tick(now time.Time, players map[string]ctypes.Player, match *Match) bool {
+	return match.everyoneFinished(players) || match.timeUp(now)
+}
+
+func (CoopMode) TileTouched(id string, tile tiles.Types, at time.Time, match *Match) bool {
+	if !isExit(tile) {
+		return false
+	}
+
+	_, ok := match.finish(id, at)
+
+	return ok
+}
+
+func (CoopMode) PickupCollected(string, tiles.Types, Pickup, *Match) {}
+
+func (CoopMode) Results(players map[string]ctypes.Player, match *Match) ([]string, string) {
+	if len(players) > 0 && match.everyoneFinished(players) {
+		return match.Finished(), "Everyone made it out together"
+	}
+
+	return nil, fmt.Sprintf("Only %d of %d players made it out in time", len(match.finished), len(players))
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"fyp/common/ctypes"
+)
+
+// How players are hurt and killed, unless the room says otherwise.
+const (
+	DefaultMaxHealth = 3
+	// DefaultSpikeDamage is how much health touching spikes takes.
+	DefaultSpikeDamage = 1
+	// DefaultHurtInvulnerability is how long a player can't be hurt again for after being hurt.
+	DefaultHurtInvulnerability = time.Second
+	// DefaultRespawnInvulnerability is how long a player can't be hurt for after respawning.
+	DefaultRespawnInvulnerability = 2 * time.Second
+)
+
+// HealthConfig is how players in a room are hurt, killed and respawned.
+type HealthConfig struct {
+	MaxHealth              int
+	SpikeDamage            int
+	HurtInvulnerability    time.Duration
+	RespawnInvulnerability time.Duration
+}
+
+// DefaultHealthConfig returns a config with the default health, damage and invulnerability.
+func DefaultHealthConfig() HealthConfig {
+	return HealthConfig{
+		MaxHealth:              DefaultMaxHealth,
+		SpikeDamage:            DefaultSpikeDamage,
+		HurtInvulnerability:    DefaultHurtInvulnerability,
+		RespawnInvulnerability: DefaultRespawnInvulnerability,
+	}
+}
+
+/*
+hurt takes damage off the player's health at the given time, unless they are
+invulnerable, and then makes them invulnerable for a moment so that a hazard they are
+still touching doesn't hurt them every tick. It returns whether the player was hurt.
+*/
+func hurt(player *ctypes.Player, damage int, at time.Time, config HealthConfig) bool {
+	if player.IsInvulnerable(at) {
+		return false
+	}
+
+	player.Health = max(0, player.Health-damage)
+	player.InvulnerableUntil = at.Add(config.HurtInvulnerability).UnixNano()
+
+	return true
+}
+
+// heal gives the player back some health, up to the most they can have.
+func heal(player *ctypes.Player, amount int, config HealthConfig) {
+	player.Health = min(config.MaxHealth, player.Health+amount)
+}
+
+/*
+revive puts the player back at the given point, at a standstill and with full health, and
+makes them invulnerable for a moment, e.g. after they die. Their last input is kept, so
+that inputs sent before they died are still ignored, see ctypes.Player.SetPosition.
+*/
+func revive(player *ctypes.Player, at ctypes.Position, now time.Time, config HealthConfig) {
+	player.SetPosition(at)
+	player.Health = config.MaxHealth
+	player.InvulnerableUntil = now.Add(config.RespawnInvulnerability).UnixNano()
+}
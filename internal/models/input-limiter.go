@@ -0,0 +1,74 @@
+package models
+
+import (
+	"sync"
+	"time"
+
+	"fyp/common/simulation"
+)
+
+/*
+DefaultInputBurst is how many inputs a client can send ahead of the server's clock, e.g.
+when several packets held up by the network arrive at once. It is a quarter of a second
+of inputs.
+*/
+const DefaultInputBurst = simulation.TickRate / 4
+
+type inputBudget struct {
+	steps float64
+	at    time.Time
+}
+
+/*
+InputLimiter stops clients from moving faster than the simulation allows by sending more
+inputs than there are ticks, which would otherwise step their player more often. Every
+player has a budget of steps that is refilled at simulation.TickRate per second of server
+time, up to a burst, and every input that is simulated takes a step from it.
+*/
+type InputLimiter struct {
+	mutex   sync.Mutex
+	burst   float64
+	budgets map[string]inputBudget
+}
+
+// NewInputLimiter creates a limiter that lets each player send up to burst inputs ahead of the server's clock.
+func NewInputLimiter(burst int) *InputLimiter {
+	return &InputLimiter{burst: float64(burst), budgets: make(map[string]inputBudget)}
+}
+
+/*
+Allow takes a step from the player's budget for an input received at now, and reports
+whether there was one to take. Inputs that aren't allowed shouldn't be simulated. A
+player's first input starts them with a full budget.
+*/
+func (l *InputLimiter) Allow(id string, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	budget, ok := l.budgets[id]
+	if !ok {
+		budget = inputBudget{steps: l.burst, at: now}
+	}
+
+	if elapsed := now.Sub(budget.at); elapsed > 0 {
+		budget.steps = min(l.burst, budget.steps+elapsed.Seconds()*simulation.TickRate)
+		budget.at = now
+	}
+
+	allowed := budget.steps >= 1
+	if allowed {
+		budget.steps--
+	}
+
+	l.budgets[id] = budget
+
+	return allowed
+}
+
+// RemovePlayer forgets the player's budget, e.g. when they disconnect.
+func (l *InputLimiter) RemovePlayer(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.budgets, id)
+}
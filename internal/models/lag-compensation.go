@@ -0,0 +1,338 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/tiles"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+)
+
+const (
+	// How much position history is kept for each player.
+	historyLength = time.Second
+
+	// The most entries kept in each player's position history.
+	maxHistoryEntries = 128
+
+	// The furthest back the server will rewind for a client, no matter what round trip
+	// time the client reports. Rewinding any further would let laggy clients win touches
+	// that other players had clearly already made.
+	MaxLagCompensation = 200 * time.Millisecond
+
+	// Allowance for jitter on top of the round trip time.
+	lagCompensationTolerance = 50 * time.Millisecond
+
+	// How long claims on a tile are collected for before the tile is resolved. This has
+	// to be at least as long as the furthest back the server will rewind, otherwise a
+	// claim with an earlier view time could arrive after the tile was resolved.
+	claimWindow = MaxLagCompensation + lagCompensationTolerance
+)
+
+type historyEntry struct {
+	at       time.Time
+	position ctypes.Position
+}
+
+/*
+PositionHistory is a short, time-ordered history of a single player's positions, as of
+the view times of the inputs that moved them.
+*/
+type PositionHistory struct {
+	entries []historyEntry
+}
+
+// Record adds a position to the history, dropping entries older than historyLength.
+func (h *PositionHistory) Record(at time.Time, position ctypes.Position) {
+	index := len(h.entries)
+	for index > 0 && h.entries[index-1].at.After(at) {
+		index--
+	}
+
+	h.entries = append(h.entries, historyEntry{})
+	copy(h.entries[index+1:], h.entries[index:])
+	h.entries[index] = historyEntry{at: at, position: position}
+
+	cutoff := h.entries[len(h.entries)-1].at.Add(-historyLength)
+	dropped := 0
+	for dropped < len(h.entries)-1 && (h.entries[dropped].at.Before(cutoff) || len(h.entries)-dropped > maxHistoryEntries) {
+		dropped++
+	}
+
+	h.entries = h.entries[dropped:]
+}
+
+/*
+At returns where the player was at the given time, interpolating between the entries
+either side of it. The returned bool is false if the history doesn't cover that time.
+*/
+func (h *PositionHistory) At(at time.Time) (ctypes.Position, bool) {
+	if len(h.entries) == 0 || at.Before(h.entries[0].at) {
+		return ctypes.Position{}, false
+	}
+
+	next := sort.Search(len(h.entries), func(i int) bool {
+		return !h.entries[i].at.Before(at)
+	})
+
+	if next == len(h.entries) {
+		return h.entries[len(h.entries)-1].position, true
+	}
+
+	to := h.entries[next]
+	if next == 0 || to.at.Equal(at) {
+		return to.position, true
+	}
+
+	from := h.entries[next-1]
+	t := float64(at.Sub(from.at)) / float64(to.at.Sub(from.at))
+
+	return ctypes.NewPosition(
+		from.position.X+(to.position.X-from.position.X)*t,
+		from.position.Y+(to.position.Y-from.position.Y)*t,
+	), true
+}
+
+/*
+TouchClaim is a single player's claim to have touched a tile, at the time the player saw
+themselves touch it.
+*/
+type TouchClaim struct {
+	// PlayerID is the ID of the player's client.
+	PlayerID   string
+	Tile       maps.PlacedTile
+	At         time.Time
+	ReceivedAt time.Time
+}
+
+/*
+TouchResolution is the outcome of every claim on a single tile: the claim with the
+earliest view time wins.
+*/
+type TouchResolution struct {
+	Tile   maps.PlacedTile
+	Winner TouchClaim
+	Losers []TouchClaim
+}
+
+// Contested reports whether more than one player claimed the tile.
+func (r TouchResolution) Contested() bool {
+	return len(r.Losers) > 0
+}
+
+/*
+LagCompensator decides which player touched a contestable tile (a pickup) first by what
+each player saw, rather than by whose packet reached the server first. Doors aren't
+contestable, since every player can go through them, see Match.finish for how finishers
+are placed instead.
+
+Each player's positions are recorded against the view time of the input that produced
+them, corrected for the client's round trip time, and claims are made from those. Claims
+on a tile are collected for claimWindow after the first one arrives, and the earliest one
+then wins. Once resolved, a tile can't be claimed again until it is released.
+*/
+type LagCompensator struct {
+	mutex     sync.Mutex
+	histories map[string]*PositionHistory
+	claims    map[ctypes.Position][]TouchClaim
+	resolved  map[ctypes.Position]bool
+}
+
+func NewLagCompensator() *LagCompensator {
+	return &LagCompensator{
+		mutex:     sync.Mutex{},
+		histories: make(map[string]*PositionHistory),
+		claims:    make(map[ctypes.Position][]TouchClaim),
+		resolved:  make(map[ctypes.Position]bool),
+	}
+}
+
+func isContestable(tile tiles.Types) bool {
+	switch tile {
+	case tiles.Typeses.COIN_TILE,
+		tiles.Typeses.DIAMOND_TILE,
+		tiles.Typeses.EMERALD_TILE,
+		tiles.Typeses.HEART_TILE:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+ViewTime returns the time on the server's clock that the given input was seen by the
+client. The client's claimed view time is trusted only as far back as its round trip
+time allows, which is itself capped at MaxLagCompensation. Inputs without a usable view
+time are assumed to have been seen half a round trip before they arrived.
+*/
+func ViewTime(receivedAt time.Time, input ctypes.PlayerInput) time.Time {
+	rtt := time.Duration(input.RoundTrip)
+	rtt = max(0, min(rtt, MaxLagCompensation))
+
+	earliest := receivedAt.Add(-(rtt + lagCompensationTolerance))
+	viewTime := time.Unix(0, input.ViewTime)
+
+	switch {
+	case input.ViewTime == 0 || viewTime.After(receivedAt):
+		return receivedAt.Add(-rtt / 2)
+	case viewTime.Before(earliest):
+		return earliest
+	default:
+		return viewTime
+	}
+}
+
+/*
+Record adds the player's position at the given view time to their history, and makes a
+claim on every contestable tile they are touching at that time.
+*/
+func (lc *LagCompensator) Record(id string, at, receivedAt time.Time, position ctypes.Position, world *maps.Map) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	history, ok := lc.histories[id]
+	if !ok {
+		history = &PositionHistory{}
+		lc.histories[id] = history
+	}
+
+	history.Record(at, position)
+
+	for _, tile := range world.TouchingTiles(int(position.X), int(position.Y)) {
+		if !isContestable(tile.Type) || lc.resolved[tile.Position] {
+			continue
+		}
+
+		lc.claim(TouchClaim{PlayerID: id, Tile: tile, At: at, ReceivedAt: receivedAt})
+	}
+}
+
+// claim keeps only the earliest claim a player has made on a tile.
+func (lc *LagCompensator) claim(claim TouchClaim) {
+	claims := lc.claims[claim.Tile.Position]
+
+	for i, existing := range claims {
+		if existing.PlayerID != claim.PlayerID {
+			continue
+		}
+
+		if claim.At.Before(existing.At) {
+			claims[i] = claim
+		}
+
+		return
+	}
+
+	lc.claims[claim.Tile.Position] = append(claims, claim)
+}
+
+/*
+Resolve resolves every tile whose claim window has closed by now. A claim is only upheld
+if the claimant's history still shows them touching the tile at the claimed time.
+*/
+func (lc *LagCompensator) Resolve(now time.Time) []TouchResolution {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	resolutions := []TouchResolution{}
+
+	for position, claims := range lc.claims {
+		firstReceived := claims[0].ReceivedAt
+		for _, claim := range claims {
+			if claim.ReceivedAt.Before(firstReceived) {
+				firstReceived = claim.ReceivedAt
+			}
+		}
+
+		if now.Sub(firstReceived) < claimWindow {
+			continue
+		}
+
+		delete(lc.claims, position)
+
+		upheld := make([]TouchClaim, 0, len(claims))
+		for _, claim := range claims {
+			if lc.confirms(claim) {
+				upheld = append(upheld, claim)
+			}
+		}
+
+		if len(upheld) == 0 {
+			continue
+		}
+
+		sort.SliceStable(upheld, func(i, j int) bool {
+			return upheld[i].At.Before(upheld[j].At)
+		})
+
+		lc.resolved[position] = true
+		resolutions = append(resolutions, TouchResolution{
+			Tile:   upheld[0].Tile,
+			Winner: upheld[0],
+			Losers: upheld[1:],
+		})
+	}
+
+	return resolutions
+}
+
+func (lc *LagCompensator) confirms(claim TouchClaim) bool {
+	history, ok := lc.histories[claim.PlayerID]
+	if !ok {
+		return false
+	}
+
+	position, ok := history.At(claim.At)
+	if !ok {
+		return false
+	}
+
+	playerX, playerY := int(position.X), int(position.Y)
+	tileX, tileY := int(claim.Tile.Position.X), int(claim.Tile.Position.Y)
+
+	return playerX < tileX+maps.TileSize && tileX < playerX+simulation.PlayerSize &&
+		playerY < tileY+maps.TileSize && tileY < playerY+simulation.PlayerSize
+}
+
+// Release allows the tile at the given position to be claimed again.
+func (lc *LagCompensator) Release(position ctypes.Position) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	delete(lc.resolved, position)
+}
+
+// ReleaseAll allows every tile to be claimed again, e.g. when a new match starts.
+func (lc *LagCompensator) ReleaseAll() {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	clear(lc.resolved)
+	clear(lc.claims)
+}
+
+// RemovePlayer drops the player's history and any claims they have outstanding.
+func (lc *LagCompensator) RemovePlayer(id string) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+
+	delete(lc.histories, id)
+
+	for position, claims := range lc.claims {
+		kept := claims[:0]
+		for _, claim := range claims {
+			if claim.PlayerID != id {
+				kept = append(kept, claim)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(lc.claims, position)
+		} else {
+			lc.claims[position] = kept
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package models
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/state"
+)
+
+// MinPlayers is how many players have to be in a room before a match can start.
+const MinPlayers = 2
+
+// MatchState is which phase the match is in, see state.MatchPhase.
+type MatchState = state.MatchPhase
+
+const (
+	MatchWaiting    = state.MatchWaiting
+	MatchReadyCheck = state.MatchReadyCheck
+	MatchCountdown  = state.MatchCountdown
+	MatchPlaying    = state.MatchPlaying
+	MatchResults    = state.MatchResults
+)
+
+// GameModeName is the name of a way to play, see GameMode.
+type GameModeName = state.GameModeName
+
+const (
+	GameModeRace  = state.GameModeRace
+	GameModeCoins = state.GameModeCoins
+	GameModeCoop  = state.GameModeCoop
+)
+
+// How long each timed phase of a match lasts, unless the room says otherwise.
+const (
+	DefaultCountdown       = 3 * time.Second
+	DefaultResultsDuration = 5 * time.Second
+)
+
+// MatchConfig is how a room runs its matches.
+type MatchConfig struct {
+	Countdown       time.Duration
+	ResultsDuration time.Duration
+	// Spawn is where every player is put back to when a countdown starts.
+	Spawn ctypes.Position
+	// Mode decides how the match is played and won, see GameMode.
+	Mode GameMode
+	// Health is how players are hurt, killed and respawned.
+	Health HealthConfig
+}
+
+// DefaultMatchConfig returns a config with the default timings and health, that spawns players at spawn.
+func DefaultMatchConfig(spawn ctypes.Position, mode GameMode) MatchConfig {
+	return MatchConfig{
+		Countdown:       DefaultCountdown,
+		ResultsDuration: DefaultResultsDuration,
+		Spawn:           spawn,
+		Mode:            mode,
+		Health:          DefaultHealthConfig(),
+	}
+}
+
+/*
+Match is a room's match, as of a single snapshot. Like the rest of a Snapshot it is never
+changed once it has been published, so its ready players and finishers are only read
+through its methods.
+*/
+type Match struct {
+	State MatchState
+	Mode  GameModeName
+	Round int
+	// EndsAt is when the current phase ends, or zero if it doesn't end at a set time.
+	EndsAt time.Time
+
+	ready    map[string]bool
+	finished []string
+	// finishedAt is when each player that has finished saw themselves reach the door.
+	finishedAt map[string]time.Time
+	scores     map[string]int
+	standings  []string
+	summary    string
+	// collected is every pickup that has been collected, with when it comes back, or the
+	// zero time if it doesn't until the next match.
+	collected map[ctypes.Position]time.Time
+}
+
+func newMatch(mode GameModeName) Match {
+	return Match{
+		State:      MatchWaiting,
+		Mode:       mode,
+		ready:      make(map[string]bool),
+		finishedAt: make(map[string]time.Time),
+		scores:     make(map[string]int),
+		collected:  make(map[ctypes.Position]time.Time),
+	}
+}
+
+// clone returns a copy of the match that can be changed without changing this one.
+func (m Match) clone() Match {
+	m.ready = maps.Clone(m.ready)
+	m.finished = slices.Clone(m.finished)
+	m.finishedAt = maps.Clone(m.finishedAt)
+	m.scores = maps.Clone(m.scores)
+	m.collected = maps.Clone(m.collected)
+
+	return m
+}
+
+// IsReady reports whether the player with the given ID is ready for the next match.
+func (m Match) IsReady(id string) bool {
+	return m.ready[id]
+}
+
+// HasFinished reports whether the player with the given ID has finished this match.
+func (m Match) HasFinished(id string) bool {
+	return slices.Contains(m.finished, id)
+}
+
+// Finished returns every player that has finished, in the order they saw themselves finish.
+func (m Match) Finished() []string {
+	return slices.Clone(m.finished)
+}
+
+// Score returns how many points the player with the given ID has scored in this match.
+func (m Match) Score(id string) int {
+	return m.scores[id]
+}
+
+// IsCollected reports whether the pickup at the given position has been collected, and hasn't come back yet.
+func (m Match) IsCollected(position ctypes.Position) bool {
+	_, ok := m.collected[position]
+	return ok
+}
+
+// Info describes the match as it is sent to clients.
+func (m Match) Info() state.MatchInfo {
+	info := state.MatchInfo{
+		Phase:     m.State,
+		Mode:      m.Mode,
+		Round:     m.Round,
+		Finished:  m.Finished(),
+		Standings: slices.Clone(m.standings),
+		Summary:   m.summary,
+	}
+
+	if len(m.scores) > 0 {
+		info.Scores = maps.Clone(m.scores)
+	}
+
+	for position := range m.collected {
+		info.Collected = append(info.Collected, position)
+	}
+	slices.SortFunc(info.Collected, func(a, b ctypes.Position) int {
+		if a.Y != b.Y {
+			return cmp.Compare(a.Y, b.Y)
+		}
+
+		return cmp.Compare(a.X, b.X)
+	})
+
+	if !m.EndsAt.IsZero() {
+		info.EndsAt = m.EndsAt.UnixNano()
+	}
+
+	for id, ready := range m.ready {
+		if ready {
+			info.Ready = append(info.Ready, id)
+		}
+	}
+	slices.Sort(info.Ready)
+
+	return info
+}
+
+/*
+advance moves the match on to its next phase, if it is due one by now, given the players
+in the room. Players are put back at the spawn point when a countdown starts, and when the
+match ends, and every pickup comes back and every score and death is reset when a
+countdown starts.
+It returns whether the phase changed.
+
+The phases go waiting, ready check, countdown, playing and results, and then back to the
+ready check for the next match. Whenever there are too few players the match goes back to
+waiting, by way of the results if it was being played. While the match is being played,
+the game mode decides when it is over, and who won, see GameMode.
+*/
+func (m *Match) advance(now time.Time, players map[string]ctypes.Player, config MatchConfig) bool {
+	enough := len(players) >= MinPlayers
+	previous := m.State
+
+	switch m.State {
+	case MatchWaiting:
+		if enough {
+			m.enter(MatchReadyCheck, time.Time{})
+		}
+	case MatchReadyCheck:
+		switch {
+		case !enough:
+			m.enter(MatchWaiting, time.Time{})
+		case m.everyoneReady(players):
+			m.Round++
+			m.enter(MatchCountdown, now.Add(config.Countdown))
+			resetProgress(players)
+			respawn(players, config)
+			clear(m.collected)
+		}
+	case MatchCountdown:
+		switch {
+		case !enough:
+			m.ready = make(map[string]bool)
+			m.enter(MatchWaiting, time.Time{})
+		case !now.Before(m.EndsAt):
+			m.enter(MatchPlaying, time.Time{})
+			config.Mode.Started(now, players, m)
+		}
+	case MatchPlaying:
+		if enough && !config.Mode.Tick(now, players, m) {
+			break
+		}
+
+		m.standings, m.summary = config.Mode.Results(players, m)
+		m.enter(MatchResults, now.Add(config.ResultsDuration))
+
+		// Clients put their player back at their checkpoint, or the spawn point, as soon as
+		// they can't move, so the server does too.
+		respawn(players, config)
+	case MatchResults:
+		if now.Before(m.EndsAt) {
+			break
+		}
+
+		m.ready = make(map[string]bool)
+		m.finished = nil
+		m.finishedAt = make(map[string]time.Time)
+		m.scores = make(map[string]int)
+		m.standings = nil
+		m.summary = ""
+
+		if enough {
+			m.enter(MatchReadyCheck, time.Time{})
+		} else {
+			m.enter(MatchWaiting, time.Time{})
+		}
+	}
+
+	return m.State != previous
+}
+
+/*
+respawn puts every player back at their checkpoint, or the spawn point, at a standstill
+and with full health, just as their clients do, see ctypes.Player.SetPosition.
+*/
+func respawn(players map[string]ctypes.Player, config MatchConfig) {
+	for id, player := range players {
+		player.SetPosition(player.RespawnPoint(config.Spawn))
+		player.Health = config.Health.MaxHealth
+		player.InvulnerableUntil = 0
+		players[id] = player
+	}
+}
+
+// resetProgress takes every player's score and deaths back to 0, and forgets their checkpoint.
+func resetProgress(players map[string]ctypes.Player) {
+	for id, player := range players {
+		player.Score = 0
+		player.Deaths = 0
+		player.Checkpoint = nil
+		players[id] = player
+	}
+}
+
+/*
+collect records that the pickup at the given position was collected at the given time,
+and comes back after respawn, or not until the next match if respawn is 0. It returns
+false if the match isn't being played, or the pickup has already been collected.
+*/
+func (m *Match) collect(position ctypes.Position, at time.Time, respawn time.Duration) bool {
+	if m.State != MatchPlaying || m.IsCollected(position) {
+		return false
+	}
+
+	var back time.Time
+	if respawn > 0 {
+		back = at.Add(respawn)
+	}
+
+	m.collected[position] = back
+
+	return true
+}
+
+// respawnPickups brings back every pickup that is due back by now, and returns where they are.
+func (m *Match) respawnPickups(now time.Time) []ctypes.Position {
+	var respawned []ctypes.Position
+
+	for position, back := range m.collected {
+		if !back.IsZero() && !now.Before(back) {
+			delete(m.collected, position)
+			respawned = append(respawned, position)
+		}
+	}
+
+	return respawned
+}
+
+// enter moves the match into the given phase, which ends at endsAt.
+func (m *Match) enter(phase MatchState, endsAt time.Time) {
+	m.State = phase
+	m.EndsAt = endsAt
+}
+
+func (m *Match) everyoneReady(players map[string]ctypes.Player) bool {
+	for id := range players {
+		if !m.ready[id] {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+setReady records whether the player is ready for the next match. Players can only ready
+up before the countdown starts. It returns whether anything changed.
+*/
+func (m *Match) setReady(id string, ready bool) bool {
+	if m.State != MatchWaiting && m.State != MatchReadyCheck {
+		return false
+	}
+
+	if m.ready[id] == ready {
+		return false
+	}
+
+	if ready {
+		m.ready[id] = true
+	} else {
+		delete(m.ready, id)
+	}
+
+	return true
+}
+
+/*
+finish records that the player finished the level at the time they saw themselves reach
+the door, and returns where they placed, starting from 1. Players are placed by when they
+saw themselves finish rather than by when the server heard about it, so a player whose
+packets arrive later can still place ahead of someone who has already finished. It returns
+false if the match isn't being played, or the player has already finished.
+*/
+func (m *Match) finish(id string, at time.Time) (int, bool) {
+	if m.State != MatchPlaying || m.HasFinished(id) {
+		return 0, false
+	}
+
+	place := len(m.finished)
+	for place > 0 && at.Before(m.finishedAt[m.finished[place-1]]) {
+		place--
+	}
+
+	m.finished = slices.Insert(m.finished, place, id)
+	m.finishedAt[id] = at
+
+	return place + 1, true
+}
+
+// everyoneFinished reports whether every player in the room has finished.
+func (m *Match) everyoneFinished(players map[string]ctypes.Player) bool {
+	for id := range players {
+		if !m.HasFinished(id) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// timeUp reports whether the match's time has run out by now, if it has a set end.
+func (m *Match) timeUp(now time.Time) bool {
+	return !m.EndsAt.IsZero() && !now.Before(m.EndsAt)
+}
+
+// removePlayer forgets everything about a player that has left.
+func (m *Match) removePlayer(id string) {
+	delete(m.ready, id)
+	delete(m.scores, id)
+	delete(m.finishedAt, id)
+	m.finished = slices.DeleteFunc(m.finished, func(finished string) bool {
+		return finished == id
+	})
+}
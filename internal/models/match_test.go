@@ -0,0 +1,154 @@
+package models_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/tiles"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+	"fyp/internal/models"
+)
+
+/*
+playingState returns server state for a match of the given game mode between the given
+players, that is already being played as of now.
+*/
+func playingState(t *testing.T, now time.Time, name models.GameModeName, ids ...string) *models.ServerState {
+	t.Helper()
+
+	mode, err := models.NewGameMode(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := models.DefaultMatchConfig(ctypes.NewPosition(0, 0), mode)
+	config.Countdown = 0
+	serverState := models.NewServerState(models.NewEventBus(), config)
+
+	for i, id := range ids {
+		player, err := ctypes.NewPlayer(ctypes.PlayerColourFromInt(i), ctypes.NewPosition(0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		player.DisplayName = id
+		if err := serverState.AddPlayer(id, *player); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range ids {
+		serverState.AdvanceMatch(now)
+		serverState.SetReady(id, true)
+	}
+
+	for serverState.Snapshot().Match().State != models.MatchPlaying {
+		if _, changed := serverState.AdvanceMatch(now); !changed {
+			t.Fatalf("the match got stuck in %s", serverState.Snapshot().Match().State)
+		}
+	}
+
+	return serverState
+}
+
+// TestRacePlacesByViewTime checks that finishers are placed by when they saw themselves reach the door.
+func TestRacePlacesByViewTime(t *testing.T) {
+	now := time.Now()
+	serverState := playingState(t, now, models.GameModeRace, "first", "second", "third")
+
+	// The server hears from the players in a different order to the one they finished in.
+	touches := []struct {
+		id string
+		at time.Time
+	}{
+		{"second", now.Add(100 * time.Millisecond)},
+		{"third", now.Add(150 * time.Millisecond)},
+		{"first", now.Add(50 * time.Millisecond)},
+	}
+
+	for _, touch := range touches {
+		if !serverState.TouchTile(touch.id, tiles.Typeses.DOOR_OPENED_TILE, touch.at) {
+			t.Fatalf("%s reaching the door didn't finish them", touch.id)
+		}
+	}
+
+	if serverState.TouchTile("second", tiles.Typeses.DOOR_OPENED_TILE, now) {
+		t.Error("second finished twice")
+	}
+
+	match := serverState.Snapshot().Match()
+
+	if want := []string{"first", "second", "third"}; !slices.Equal(match.Finished(), want) {
+		t.Errorf("finished %v, want %v", match.Finished(), want)
+	}
+
+	// The grace period starts from when the winner finished, not from when the server heard about it.
+	if want := now.Add(50 * time.Millisecond).Add(models.DefaultFinishGrace); !match.EndsAt.Equal(want) {
+		t.Errorf("the race ends at %s, want %s", match.EndsAt, want)
+	}
+}
+
+// TestMatchEndRespawnsAtStandstill checks that players are put back at the spawn point without any of their momentum.
+func TestMatchEndRespawnsAtStandstill(t *testing.T) {
+	now := time.Now()
+	serverState := playingState(t, now, models.GameModeRace, "first", "second")
+
+	// With nothing to stand on, the player falls.
+	physics := simulation.DefaultPhysics()
+	input := ctypes.PlayerInput{Sequence: 1, Tick: 1, Right: true}
+
+	falling, ok := serverState.SimulatePlayer("first", input, maps.NewMapFromTiles(nil), &physics)
+	if !ok || falling.Velocity == (ctypes.Position{}) {
+		t.Fatalf("the player didn't move, with a velocity of %v", falling.Velocity)
+	}
+
+	for _, id := range []string{"first", "second"} {
+		serverState.TouchTile(id, tiles.Typeses.DOOR_OPENED_TILE, now)
+	}
+
+	if match, _ := serverState.AdvanceMatch(now); match.State != models.MatchResults {
+		t.Fatalf("the match went to %s once everyone finished, want %s", match.State, models.MatchResults)
+	}
+
+	player, _ := serverState.Snapshot().Player("first")
+	if player.Velocity != (ctypes.Position{}) || player.Grounded || player.Rising {
+		t.Errorf("the player was respawned still moving, with %+v", player.Body)
+	}
+
+	if player.Position != ctypes.NewPosition(0, 0) {
+		t.Errorf("the player was respawned at %v, want the spawn point", player.Position)
+	}
+
+	if player.LastInputSequence != input.Sequence {
+		t.Errorf("the player's last input is %d after respawning, want %d", player.LastInputSequence, input.Sequence)
+	}
+}
+
+// TestCoinsModeScoresPickupsByWorth checks that coins mode ranks players by what their pickups are worth, not by how many they collected.
+func TestCoinsModeScoresPickupsByWorth(t *testing.T) {
+	now := time.Now()
+	serverState := playingState(t, now, models.GameModeCoins, "coins", "diamond")
+
+	coin := models.Pickup{Score: 1}
+	for i := range 3 {
+		serverState.CollectPickup("coins", tiles.Typeses.COIN_TILE, ctypes.NewPosition(float64(i), 0), coin, now)
+	}
+
+	diamond := models.Pickup{Score: 10}
+	serverState.CollectPickup("diamond", tiles.Typeses.DIAMOND_TILE, ctypes.NewPosition(0, 1), diamond, now)
+
+	snapshot := serverState.Snapshot()
+	for _, id := range []string{"coins", "diamond"} {
+		if player, _ := snapshot.Player(id); snapshot.Match().Score(id) != player.Score {
+			t.Errorf("%s has a match score of %d, but a score of %d", id, snapshot.Match().Score(id), player.Score)
+		}
+	}
+
+	match, _ := serverState.AdvanceMatch(now.Add(models.DefaultCoinsTimeLimit))
+	if want := []string{"diamond", "coins"}; !slices.Equal(match.Info().Standings, want) {
+		t.Errorf("placed %v, want %v", match.Info().Standings, want)
+	}
+}
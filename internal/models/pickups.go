@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"fyp/common/ctypes/tiles"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPickupsPath is the path of the pickups file that is loaded if it exists.
+const DefaultPickupsPath = "resources/pickups.yml"
+
+/*
+Pickup is what collecting a pickup tile is worth, in score and in health. Once it has been
+collected it comes back after Respawn, or not until the next match if Respawn is 0.
+*/
+type Pickup struct {
+	Score   int           `yaml:"score"`
+	Heal    int           `yaml:"heal"`
+	Respawn time.Duration `yaml:"respawn"`
+}
+
+// Pickups is every tile that can be collected, and what it is worth.
+type Pickups map[tiles.Types]Pickup
+
+// DefaultPickups returns what each pickup is worth unless the pickups file says otherwise.
+func DefaultPickups() Pickups {
+	return Pickups{
+		tiles.Typeses.COIN_TILE:    {Score: 1},
+		tiles.Typeses.EMERALD_TILE: {Score: 5},
+		tiles.Typeses.DIAMOND_TILE: {Score: 10},
+		tiles.Typeses.HEART_TILE:   {Heal: 1},
+	}
+}
+
+/*
+LoadPickups loads pickups from a YAML file at path, keyed by the name of their tile in
+spritesheet_data.yml, e.g. coin. Any pickup, or value, missing from the file keeps its
+value from DefaultPickups. Only the tiles in DefaultPickups can be collected.
+*/
+func LoadPickups(path string) (Pickups, error) {
+	pickups := DefaultPickups()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return pickups, fmt.Errorf("could not load pickups from file \"%s\": %w", path, err)
+	}
+
+	var byName map[string]yaml.Node
+	if err := yaml.Unmarshal(content, &byName); err != nil {
+		return pickups, fmt.Errorf("could not load pickups from file \"%s\": %w", path, err)
+	}
+
+	for name, node := range byName {
+		tile, ok := pickupTile(name)
+		if !ok {
+			return pickups, fmt.Errorf("could not load pickups from file \"%s\": %q isn't a tile that can be collected", path, name)
+		}
+
+		pickup := pickups[tile]
+		if err := node.Decode(&pickup); err != nil {
+			return pickups, fmt.Errorf("could not load pickups from file \"%s\": %s: %w", path, name, err)
+		}
+
+		if pickup.Score < 0 || pickup.Heal < 0 || pickup.Respawn < 0 {
+			return pickups, fmt.Errorf("could not load pickups from file \"%s\": %s can't have a negative score, heal or respawn time", path, name)
+		}
+
+		pickups[tile] = pickup
+	}
+
+	return pickups, nil
+}
+
+// pickupTile returns the pickup tile with the given name, ignoring case.
+func pickupTile(name string) (tiles.Types, bool) {
+	for tile := range DefaultPickups() {
+		if strings.EqualFold(tile.String(), name+"_TILE") {
+			return tile, true
+		}
+	}
+
+	return tiles.Types{}, false
+}
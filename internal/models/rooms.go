@@ -0,0 +1,197 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The shortest and longest room names that are allowed, in characters.
+const (
+	MinRoomNameLength = 1
+	MaxRoomNameLength = 24
+)
+
+/*
+MaxRoomPlayers is the most players that a room can have. Every player is in every players
+snapshot, at about 300 bytes each, and a snapshot has to fit in a single UDP datagram, see
+typedsockets.MaxDatagramSize, with room to spare for longer names and new fields.
+*/
+const MaxRoomPlayers = 64
+
+// MaxRoomPasswordLength is the longest room password that is allowed, in bytes.
+const MaxRoomPasswordLength = 64
+
+// RoomCodeLength is how many characters there are in the code that a room is joined by.
+const RoomCodeLength = 6
+
+// roomCodeAlphabet leaves out characters that are easily mistaken for each other, e.g. O and 0.
+const roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+/*
+RoomConfig is everything that is decided about a room when it is created. Every room has
+a state, sessions and map of its own, so rooms never see each other's players.
+*/
+type RoomConfig struct {
+	Name string
+	// Private rooms aren't listed, and can only be joined by their code.
+	Private bool
+	// Password has to be given to join the room, unless it is empty.
+	Password string
+	// MaxPlayers and QueueSize are the room's slots and queue, see NewSessions.
+	MaxPlayers  int
+	QueueSize   int
+	IdleTimeout time.Duration
+	MapPath     string
+	// Mode is how the room's matches are played, see GameMode.
+	Mode GameModeName
+	// Pickups is every tile that can be collected in the room, and what it is worth.
+	Pickups Pickups
+	// Persistent rooms are never cleaned up once they are empty, e.g. the default room.
+	Persistent bool
+}
+
+// ValidateRoomName checks a room name in the same way as a display name, see ValidateDisplayName.
+func ValidateRoomName(name string) error {
+	return validateName("room name", name, MinRoomNameLength, MaxRoomNameLength)
+}
+
+// Validate checks that the room can be created with this config.
+func (c RoomConfig) Validate() error {
+	if err := ValidateRoomName(c.Name); err != nil {
+		return err
+	}
+
+	if len(c.Password) > MaxRoomPasswordLength {
+		return fmt.Errorf("room password can't be longer than %d bytes", MaxRoomPasswordLength)
+	}
+
+	if c.MaxPlayers < 1 || c.MaxPlayers > MaxRoomPlayers {
+		return fmt.Errorf("room must allow between 1 and %d players, got %d", MaxRoomPlayers, c.MaxPlayers)
+	}
+
+	if c.QueueSize < 0 {
+		return fmt.Errorf("room queue size can't be negative, got %d", c.QueueSize)
+	}
+
+	if _, err := NewGameMode(c.Mode); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Admits reports whether password is the room's password. Rooms without one admit anyone.
+func (c RoomConfig) Admits(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(c.Password), []byte(password)) == 1
+}
+
+// NewRoomCode returns a random code for a room, see RoomCodeLength.
+func NewRoomCode() (string, error) {
+	var code strings.Builder
+	alphabetSize := big.NewInt(int64(len(roomCodeAlphabet)))
+
+	for range RoomCodeLength {
+		i, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", errors.Join(errors.New("could not generate room code"), err)
+		}
+
+		code.WriteByte(roomCodeAlphabet[i.Int64()])
+	}
+
+	return code.String(), nil
+}
+
+// NormaliseRoomCode returns a room code as the user typed it in the form that it was generated in.
+func NormaliseRoomCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+/*
+JoinTickets hands out the tickets that let a client's UDP connection into the room that
+it joined over TCP. A ticket can only be used once, and only until it expires.
+*/
+type JoinTickets struct {
+	mutex    sync.Mutex
+	tickets  map[string]joinTicket
+	lifetime time.Duration
+}
+
+type joinTicket struct {
+	roomID  string
+	expires time.Time
+}
+
+func NewJoinTickets(lifetime time.Duration) *JoinTickets {
+	return &JoinTickets{
+		tickets:  make(map[string]joinTicket),
+		lifetime: lifetime,
+	}
+}
+
+// Issue returns a new ticket into the room with the given ID.
+func (t *JoinTickets) Issue(roomID string, now time.Time) (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", errors.Join(errors.New("could not generate join ticket"), err)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Tickets that were never used are only cleaned up here, as nothing else would
+	// notice them.
+	for ticket, issued := range t.tickets {
+		if now.After(issued.expires) {
+			delete(t.tickets, ticket)
+		}
+	}
+
+	t.tickets[id.String()] = joinTicket{roomID: roomID, expires: now.Add(t.lifetime)}
+
+	return id.String(), nil
+}
+
+/*
+Redeem uses up the ticket, and returns the ID of the room that it lets the client into.
+It returns false if there is no such ticket, or it has expired.
+*/
+func (t *JoinTickets) Redeem(ticket string, now time.Time) (string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	issued, ok := t.tickets[ticket]
+	if !ok {
+		return "", false
+	}
+
+	delete(t.tickets, ticket)
+
+	if now.After(issued.expires) {
+		return "", false
+	}
+
+	return issued.roomID, true
+}
+
+// Pending reports whether anyone holds a ticket into the room that they haven't used yet.
+func (t *JoinTickets) Pending(roomID string, now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, issued := range t.tickets {
+		if issued.roomID == roomID && !now.After(issued.expires) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"sync"
+
+	"fyp/common/ctypes/state"
+)
+
+/*
+SendQueue is a single client's queue of outbound messages, which are written by a
+goroutine of its own, so that a slow client never holds up sending to anyone else.
+
+Messages are sent in three tiers:
+  - control messages, e.g. whether the player can move, are sent first;
+  - everything else, e.g. relayed rollback inputs, is sent in order after them;
+  - player updates are sent last, and only the newest one is kept, as each one
+    supersedes the last.
+
+If more than limit control and ordinary messages are waiting, the client has fallen too
+far behind: the queue stops, and is reported by FallenBehind.
+*/
+type SendQueue struct {
+	mutex   sync.Mutex
+	control []state.State
+	normal  []state.State
+	players *state.State
+	limit   int
+	stopped bool
+
+	wake  chan struct{}
+	write func(state.State) (int, error)
+	onErr func(error)
+}
+
+/*
+NewSendQueue starts a queue that sends with write. onErr is called from the queue's
+goroutine whenever a write fails, and may be nil.
+*/
+func NewSendQueue(write func(state.State) (int, error), limit int, onErr func(error)) *SendQueue {
+	queue := &SendQueue{
+		limit: limit,
+		wake:  make(chan struct{}, 1),
+		write: write,
+		onErr: onErr,
+	}
+
+	go queue.run()
+
+	return queue
+}
+
+// isControl reports whether a message changes what the client is allowed to do.
+func isControl(message state.State) bool {
+	switch message.Submessage {
+	case state.Submessages.SERVER_FIRST_CLIENT_CONNECTION_INFORMATION,
+		state.Submessages.SERVER_THIS_CLIENT_CAN_MOVE,
+		state.Submessages.SERVER_THIS_CLIENT_CANNOT_MOVE,
+		state.Submessages.SERVER_PLAYERS_HAVE_FINISHED,
+		state.Submessages.SERVER_UPDATING_MATCH,
+		state.Submessages.SERVER_REACHING_CHECKPOINT,
+		state.Submessages.SERVER_RESENDING_UPDATE_ID,
+		state.Submessages.SERVER_REJECTING_NAME,
+		state.Submessages.SERVER_QUEUEING_CLIENT:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+Enqueue adds a message to the queue without waiting for it to be sent. It returns false
+if the queue has stopped, either because it was closed or because the client has fallen
+behind.
+*/
+func (q *SendQueue) Enqueue(message state.State) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.stopped {
+		return false
+	}
+
+	switch {
+	case message.Submessage == state.Submessages.SERVER_UPDATING_PLAYERS:
+		q.players = &message
+	case isControl(message):
+		q.control = append(q.control, message)
+	default:
+		q.normal = append(q.normal, message)
+	}
+
+	if len(q.control)+len(q.normal) > q.limit {
+		q.stop()
+		return false
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// Stopped reports whether the queue has stopped sending.
+func (q *SendQueue) Stopped() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.stopped
+}
+
+// Close stops the queue. Anything that hasn't been sent yet is dropped.
+func (q *SendQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.stop()
+}
+
+// stop has to be called with the mutex held.
+func (q *SendQueue) stop() {
+	if q.stopped {
+		return
+	}
+
+	q.stopped = true
+	q.control, q.normal, q.players = nil, nil, nil
+	close(q.wake)
+}
+
+// next takes the next message to send, in order of priority.
+func (q *SendQueue) next() (state.State, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	switch {
+	case q.stopped:
+		return state.State{}, false
+	case len(q.control) > 0:
+		message := q.control[0]
+		q.control = q.control[1:]
+
+		return message, true
+	case len(q.normal) > 0:
+		message := q.normal[0]
+		q.normal = q.normal[1:]
+
+		return message, true
+	case q.players != nil:
+		message := *q.players
+		q.players = nil
+
+		return message, true
+	default:
+		return state.State{}, false
+	}
+}
+
+func (q *SendQueue) run() {
+	for range q.wake {
+		for {
+			message, ok := q.next()
+			if !ok {
+				break
+			}
+
+			if _, err := q.write(message); err != nil && q.onErr != nil {
+				q.onErr(err)
+			}
+		}
+	}
+}
+
+/*
+Outbox holds a SendQueue for every connected client, keyed by client ID, so that
+messages can be sent and broadcast without waiting on any client.
+*/
+type Outbox struct {
+	mutex        sync.RWMutex
+	queues       map[string]*SendQueue
+	limit        int
+	fallenBehind []string
+	onErr        func(id string, err error)
+}
+
+/*
+NewOutbox creates an outbox whose queues each hold at most limit waiting messages, see
+SendQueue. onErr is called whenever a write to a client fails, and may be nil.
+*/
+func NewOutbox(limit int, onErr func(id string, err error)) *Outbox {
+	return &Outbox{
+		queues: make(map[string]*SendQueue),
+		limit:  limit,
+		onErr:  onErr,
+	}
+}
+
+// Add starts a queue for the client, replacing any queue that it already had.
+func (o *Outbox) Add(id string, write func(state.State) (int, error)) {
+	var onErr func(error)
+	if o.onErr != nil {
+		onErr = func(err error) { o.onErr(id, err) }
+	}
+
+	queue := NewSendQueue(write, o.limit, onErr)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if previous, ok := o.queues[id]; ok {
+		previous.Close()
+	}
+
+	o.queues[id] = queue
+}
+
+// Remove stops and removes the client's queue.
+func (o *Outbox) Remove(id string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if queue, ok := o.queues[id]; ok {
+		queue.Close()
+		delete(o.queues, id)
+	}
+}
+
+// Send queues a message for a single client, see SendQueue.Enqueue.
+func (o *Outbox) Send(id string, message state.State) bool {
+	o.mutex.RLock()
+	queue, ok := o.queues[id]
+	o.mutex.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	return o.enqueue(id, queue, message)
+}
+
+// Broadcast queues a message for every client.
+func (o *Outbox) Broadcast(message state.State) {
+	o.BroadcastExcept("", message)
+}
+
+// BroadcastExcept queues a message for every client other than the one given.
+func (o *Outbox) BroadcastExcept(except string, message state.State) {
+	o.mutex.RLock()
+	queues := make(map[string]*SendQueue, len(o.queues))
+	for id, queue := range o.queues {
+		if id != except {
+			queues[id] = queue
+		}
+	}
+	o.mutex.RUnlock()
+
+	for id, queue := range queues {
+		o.enqueue(id, queue, message)
+	}
+}
+
+func (o *Outbox) enqueue(id string, queue *SendQueue, message state.State) bool {
+	if queue.Enqueue(message) {
+		return true
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	// Only the queue that is still registered is reported, and only once.
+	if o.queues[id] == queue {
+		delete(o.queues, id)
+		o.fallenBehind = append(o.fallenBehind, id)
+	}
+
+	return false
+}
+
+/*
+FallenBehind returns the ID of every client whose queue has stopped because it fell too
+far behind since the last call, so that they can be disconnected.
+*/
+func (o *Outbox) FallenBehind() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	fallenBehind := o.fallenBehind
+	o.fallenBehind = nil
+
+	return fallenBehind
+}
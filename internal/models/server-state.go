@@ -1,68 +1,433 @@
 package models
 
 import (
+	"errors"
+	"maps"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"fyp/common/ctypes"
 	"fyp/common/ctypes/state"
+	"fyp/common/ctypes/tiles"
+	"fyp/common/simulation"
 )
 
-type ServerState struct {
-	mutex          sync.RWMutex
-	state          state.State
-	updatedChannel chan<- string
+/*
+Snapshot is an immutable view of the server state, as of a single version. A Snapshot is
+never changed once it has been published, so it can be read from any goroutine without a
+lock, and everything read from the same Snapshot is consistent.
+*/
+type Snapshot struct {
+	// Version is increased by one for every change to the server state.
+	Version uint64
+
+	// players is keyed by the ID of each player's client.
+	players map[string]ctypes.Player
+	match   Match
 }
 
-func NewServerState() (s *ServerState, u <-chan string) {
-	updatedChannel := make(chan string, 1024)
-	return &ServerState{
-		mutex:          sync.RWMutex{},
-		updatedChannel: updatedChannel,
-		state:          state.Empty(),
-	}, updatedChannel
+// Player returns the player of the client with the given ID, as of this snapshot.
+func (s *Snapshot) Player(id string) (ctypes.Player, bool) {
+	player, ok := s.players[id]
+	return player, ok
 }
 
-func (s *ServerState) AddPlayer(name string, player ctypes.Player) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// ContainsPlayer reports whether the client with the given ID has a player, as of this snapshot.
+func (s *Snapshot) ContainsPlayer(id string) bool {
+	_, ok := s.players[id]
+	return ok
+}
 
-	s.state.Server.Players[name] = player
-	s.updatedChannel <- "added player"
+// PlayerCount returns how many players there are, as of this snapshot.
+func (s *Snapshot) PlayerCount() int {
+	return len(s.players)
 }
 
-func (s *ServerState) RemovePlayer(name string) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+/*
+Players returns a copy of every player, as of this snapshot. The copy belongs to the
+caller, and can be changed without affecting the snapshot.
+*/
+func (s *Snapshot) Players() map[string]ctypes.Player {
+	return maps.Clone(s.players)
+}
 
-	delete(s.state.Server.Players, name)
-	s.updatedChannel <- "removed player"
+// Match returns the room's match, as of this snapshot.
+func (s *Snapshot) Match() Match {
+	return s.match
 }
 
-func (s *ServerState) ContainsPlayer(name string) bool {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+/*
+ServerState holds the server's state as a series of immutable snapshots. Readers load the
+current snapshot without taking a lock. Writers are serialised, and each one copies the
+current snapshot, changes the copy and publishes it as the next version, so that a
+snapshot that has already been handed out never changes underneath its reader.
 
-	_, ok := s.state.Server.Players[name]
+Every change is published on the event bus, in the order the changes were made. The
+match is played by the rules of the game mode in matchConfig, see GameMode.
+*/
+type ServerState struct {
+	// writeMutex is only held by writers, readers never wait on it.
+	writeMutex  sync.Mutex
+	current     atomic.Pointer[Snapshot]
+	events      *EventBus
+	matchConfig MatchConfig
+}
 
-	return ok
+func NewServerState(events *EventBus, matchConfig MatchConfig) *ServerState {
+	serverState := &ServerState{events: events, matchConfig: matchConfig}
+	serverState.current.Store(&Snapshot{players: make(map[string]ctypes.Player), match: newMatch(matchConfig.Mode.Name())})
+
+	return serverState
+}
+
+// MatchConfig returns how the room runs its matches.
+func (s *ServerState) MatchConfig() MatchConfig {
+	return s.matchConfig
+}
+
+// Events returns the bus that changes to the server state are published on.
+func (s *ServerState) Events() *EventBus {
+	return s.events
+}
+
+// Snapshot returns the current snapshot of the server state.
+func (s *ServerState) Snapshot() *Snapshot {
+	return s.current.Load()
 }
 
-func (s *ServerState) UpdatePlayer(name string, data ctypes.Player) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// Version returns the version of the current snapshot.
+func (s *ServerState) Version() uint64 {
+	return s.current.Load().Version
+}
 
-	if _, ok := s.state.Server.Players[name]; ok {
-		s.state.Server.Players[name] = data
-		s.updatedChannel <- "updated player"
+/*
+update publishes the next version of the state, with the players and match as changed by
+change, along with the event that change returns. If change returns a nil event, nothing
+is changed or published. update returns the snapshot that is current once it is done.
+*/
+func (s *ServerState) update(change func(players map[string]ctypes.Player, match *Match) Event) *Snapshot {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	current := s.current.Load()
+	players := maps.Clone(current.players)
+	match := current.match.clone()
+
+	event := change(players, &match)
+	if event == nil {
+		return current
+	}
+
+	next := &Snapshot{Version: current.Version + 1, players: players, match: match}
+	s.current.Store(next)
+
+	if s.events != nil {
+		meta := EventMeta{Version: next.Version, At: time.Now()}
+		s.events.Publish(event.withMeta(meta))
 	}
+
+	return next
 }
 
-func (s *ServerState) FilterPlayers(filter func(key string, player ctypes.Player) bool) map[string]ctypes.Player {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+/*
+AddPlayer adds the player of the client with the given ID, or replaces it if the client
+already has one. It returns an error if another client's player already has the same
+display name, ignoring case.
+*/
+func (s *ServerState) AddPlayer(id string, player ctypes.Player) error {
+	var err error
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		for otherID, other := range players {
+			if otherID != id && sameDisplayName(other.DisplayName, player.DisplayName) {
+				err = errors.New("display name is already taken")
+				return nil
+			}
+		}
+
+		_, rejoined := players[id]
+		if !rejoined {
+			player.Health = s.matchConfig.Health.MaxHealth
+		}
+
+		players[id] = player
+
+		if !rejoined {
+			s.matchConfig.Mode.PlayerJoined(id, match)
+		}
+
+		return PlayerJoined{ID: id, Player: player}
+	})
+
+	return err
+}
+
+func (s *ServerState) RemovePlayer(id string) {
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		player, ok := players[id]
+		if !ok {
+			return nil
+		}
+
+		delete(players, id)
+		match.removePlayer(id)
+		s.matchConfig.Mode.PlayerLeft(id, match)
+
+		return PlayerLeft{ID: id, DisplayName: player.DisplayName}
+	})
+}
+
+func (s *ServerState) ContainsPlayer(id string) bool {
+	return s.Snapshot().ContainsPlayer(id)
+}
+
+/*
+SimulatePlayer runs a single movement step for the client's player from the given input
+command, against the given ground and with the given physics. Inputs that are older than,
+or the same as, the last input processed for the player are ignored, as UDP can duplicate
+and reorder packets. The returned bool is false if the player doesn't exist or the input
+was ignored. Every input is one step, so how often they can be sent is up to the caller,
+see InputLimiter.
+*/
+func (s *ServerState) SimulatePlayer(id string, input ctypes.PlayerInput, ground ctypes.Ground, physics *simulation.Physics) (ctypes.Player, bool) {
+	var simulated ctypes.Player
+	var ok bool
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		simulated, ok = players[id]
+		if !ok || input.Sequence <= simulated.LastInputSequence {
+			ok = false
+			return nil
+		}
+
+		simulated.Simulate(input, ground, physics)
+		players[id] = simulated
+
+		return PlayerMoved{ID: id, Player: simulated}
+	})
+
+	return simulated, ok
+}
+
+/*
+SetReady records whether the client's player is ready for the next match. It returns
+false if nothing changed, e.g. because the countdown has already started.
+*/
+func (s *ServerState) SetReady(id string, ready bool) bool {
+	var changed bool
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		player, ok := players[id]
+		if !ok || !match.setReady(id, ready) {
+			return nil
+		}
+
+		changed = true
+
+		return PlayerReadied{ID: id, DisplayName: player.DisplayName, Ready: ready}
+	})
+
+	return changed
+}
+
+/*
+TouchTile tells the game mode that the client's player touched a tile at the given time,
+see GameMode.TileTouched. It returns false if the match isn't being played, or the touch
+didn't change it.
+*/
+func (s *ServerState) TouchTile(id string, tile tiles.Types, at time.Time) bool {
+	var changed bool
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		player, ok := players[id]
+		if !ok || match.State != MatchPlaying {
+			return nil
+		}
+
+		if !s.matchConfig.Mode.TileTouched(id, tile, at, match) {
+			return nil
+		}
+
+		changed = true
+
+		return PlayerTouchedTile{ID: id, DisplayName: player.DisplayName, Tile: tile}
+	})
 
+	return changed
+}
+
+/*
+ReachCheckpoint makes the checkpoint at position the client's player's respawn point, see
+ctypes.Player.RespawnPoint. Checkpoints are only reached while the match is being played,
+and are forgotten when the next one counts down. It returns false if the checkpoint is
+already the player's respawn point.
+*/
+func (s *ServerState) ReachCheckpoint(id string, position ctypes.Position) bool {
+	var reached bool
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		player, ok := players[id]
+		if !ok || match.State != MatchPlaying {
+			return nil
+		}
+
+		if player.Checkpoint != nil && *player.Checkpoint == position {
+			return nil
+		}
+
+		// Snapshots share the previous player's pointer, so it is replaced rather than written through.
+		player.Checkpoint = &position
+		players[id] = player
+
+		reached = true
+
+		return CheckpointReached{ID: id, DisplayName: player.DisplayName, Position: position}
+	})
+
+	return reached
+}
+
+/*
+CollectPickup records that the client's player collected the pickup tile at position at
+the given time, adds what it is worth to their score and health, and tells the game mode,
+see GameMode.PickupCollected. It returns false if the match isn't being played, or the pickup has already
+been collected. Pickups that only heal are left for someone else if the player already has
+full health.
+*/
+func (s *ServerState) CollectPickup(id string, tile tiles.Types, position ctypes.Position, pickup Pickup, at time.Time) bool {
+	var collected bool
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		player, ok := players[id]
+		if !ok {
+			return nil
+		}
+
+		onlyHeals := pickup.Heal > 0 && pickup.Score == 0
+		if onlyHeals && player.Health >= s.matchConfig.Health.MaxHealth {
+			return nil
+		}
+
+		if !match.collect(position, at, pickup.Respawn) {
+			return nil
+		}
+
+		player.Score += pickup.Score
+		heal(&player, pickup.Heal, s.matchConfig.Health)
+		players[id] = player
+		s.matchConfig.Mode.PickupCollected(id, tile, pickup, match)
+
+		collected = true
+
+		return PickupCollected{ID: id, DisplayName: player.DisplayName, Tile: tile, Position: position, Score: player.Score}
+	})
+
+	return collected
+}
+
+/*
+HurtPlayer takes damage off the client's player's health at the given time, and kills
+them if it runs out, see KillPlayer. Players can only be hurt while the match is being
+played, and not while they are invulnerable. It returns whether the player was hurt.
+*/
+func (s *ServerState) HurtPlayer(id string, damage int, cause string, at time.Time) bool {
+	var hurtPlayer bool
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		player, ok := players[id]
+		if !ok || match.State != MatchPlaying || !hurt(&player, damage, at, s.matchConfig.Health) {
+			return nil
+		}
+
+		hurtPlayer = true
+
+		if player.Health > 0 {
+			players[id] = player
+			return PlayerHurt{ID: id, DisplayName: player.DisplayName, Cause: cause, Health: player.Health}
+		}
+
+		players[id] = s.die(player, at)
+
+		return PlayerDied{ID: id, DisplayName: player.DisplayName, Cause: cause}
+	})
+
+	return hurtPlayer
+}
+
+/*
+KillPlayer kills the client's player at the given time, however much health they have,
+e.g. when they fall out of the map. They are put back at their checkpoint, or the spawn
+point if they haven't reached one, with full health, and can't be hurt again for a
+moment. Like HurtPlayer, players can only be killed while the match is being played. It
+returns whether the player was killed.
+*/
+func (s *ServerState) KillPlayer(id string, cause string, at time.Time) bool {
+	var killed bool
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		player, ok := players[id]
+		if !ok || match.State != MatchPlaying {
+			return nil
+		}
+
+		players[id] = s.die(player, at)
+		killed = true
+
+		return PlayerDied{ID: id, DisplayName: player.DisplayName, Cause: cause}
+	})
+
+	return killed
+}
+
+// die counts a death against the player, and returns them respawned at their checkpoint.
+func (s *ServerState) die(player ctypes.Player, at time.Time) ctypes.Player {
+	player.Deaths++
+	revive(&player, player.RespawnPoint(s.matchConfig.Spawn), at, s.matchConfig.Health)
+
+	return player
+}
+
+// RespawnPickups brings back every pickup that is due back by now, and returns where they are.
+func (s *ServerState) RespawnPickups(now time.Time) []ctypes.Position {
+	var respawned []ctypes.Position
+
+	s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		if respawned = match.respawnPickups(now); len(respawned) == 0 {
+			return nil
+		}
+
+		return PickupsRespawned{Positions: respawned}
+	})
+
+	return respawned
+}
+
+/*
+AdvanceMatch moves the match on to its next phase if it is due one by now, see Match. It
+returns the match afterwards, and whether its phase changed.
+*/
+func (s *ServerState) AdvanceMatch(now time.Time) (Match, bool) {
+	var changed bool
+
+	snapshot := s.update(func(players map[string]ctypes.Player, match *Match) Event {
+		previous := match.State
+		if !match.advance(now, players, s.matchConfig) {
+			return nil
+		}
+
+		changed = true
+
+		return MatchStateChanged{Previous: previous, Current: match.State, Round: match.Round}
+	})
+
+	return snapshot.match, changed
+}
+
+func (s *ServerState) FilterPlayers(filter func(key string, player ctypes.Player) bool) map[string]ctypes.Player {
 	filtered := make(map[string]ctypes.Player)
-	for key, player := range s.state.Server.Players {
+
+	for key, player := range s.Snapshot().players {
 		if !filter(key, player) {
 			continue
 		}
@@ -73,23 +438,24 @@ func (s *ServerState) FilterPlayers(filter func(key string, player ctypes.Player
 	return filtered
 }
 
+/*
+GetPlayers returns a copy of every player in the current snapshot, see Snapshot.Players.
+*/
 func (s *ServerState) GetPlayers() map[string]ctypes.Player {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	return s.state.Server.Players
+	return s.Snapshot().Players()
 }
 
 func (s *ServerState) String() string {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	return s.state.String()
+	return s.Copy().String()
 }
 
+/*
+Copy returns the current snapshot as a state.State. The State's Players map is a copy,
+and isn't shared with the server state or with any other State.
+*/
 func (s *ServerState) Copy() state.State {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	copied := state.Empty()
+	copied.Server.Players = s.GetPlayers()
 
-	return s.state
+	return copied
 }
@@ -0,0 +1,241 @@
+package models_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/tiles"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+	"fyp/internal/models"
+)
+
+// How many players change the server state at once, and how many goroutines read it.
+const (
+	stressWriters = 4
+	stressReaders = 4
+)
+
+// How many inputs each writer sends, which is fewer with -short.
+func stressInputs() int {
+	if testing.Short() {
+		return 200
+	}
+
+	return 2000
+}
+
+// stressGround is a floor for the players to stand on.
+func stressGround() *maps.Map {
+	placed := []maps.PlacedTile{}
+	for column := 0; column < 20; column++ {
+		position := simulation.NewPosition(float64(column*maps.TileSize), 10*maps.TileSize)
+		placed = append(placed, maps.PlacedTile{Type: tiles.Typeses.GROUND_MM_TILE, Position: position})
+	}
+
+	return maps.NewMapFromTiles(placed)
+}
+
+/*
+TestServerStateConcurrentUpdates changes the server state from several goroutines at once,
+as a room's tick loop and the handlers that read it would, while others read snapshots and
+receive every event. A snapshot must never change once it has been published, versions
+must only go forwards, and events must arrive in the order of their versions. Run it with
+the race detector to check the copy-on-write snapshots:
+
+	go test -race ./internal/models -run TestServerStateConcurrentUpdates
+*/
+func TestServerStateConcurrentUpdates(t *testing.T) {
+	ground := stressGround()
+	physics := simulation.DefaultPhysics()
+	spawn := ctypes.NewPosition(32, 9*maps.TileSize)
+
+	mode, err := models.NewGameMode(models.GameModeRace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := models.DefaultMatchConfig(spawn, mode)
+	config.Countdown = 0
+	serverState := models.NewServerState(models.NewEventBus(), config)
+
+	subscription := serverState.Events().Subscribe("stress test", 64, models.Block)
+	eventsReceived := make(chan uint64)
+
+	go func() {
+		eventsReceived <- receiveEvents(t, subscription)
+	}()
+
+	stop := make(chan struct{})
+
+	var readers sync.WaitGroup
+	for i := 0; i < stressReaders; i++ {
+		readers.Add(1)
+
+		go func() {
+			defer readers.Done()
+			readSnapshots(t, serverState, stop)
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for i := 0; i < stressWriters; i++ {
+		writers.Add(1)
+
+		go func(colour ctypes.PlayerColour) {
+			defer writers.Done()
+			writePlayer(t, serverState, colour, ground, &physics)
+		}(ctypes.PlayerColourFromInt(i))
+	}
+
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	if count := serverState.Snapshot().PlayerCount(); count != 0 {
+		t.Errorf("%d players were left after every writer removed theirs", count)
+	}
+
+	version := serverState.Version()
+
+	subscription.Close()
+	events := <-eventsReceived
+
+	// Every version produces at least one event, and a blocking subscription never drops any.
+	if events < version || subscription.Dropped() > 0 {
+		t.Errorf("received %d events for %d versions, and dropped %d", events, version, subscription.Dropped())
+	}
+}
+
+/*
+writePlayer adds a player, and then changes it in every way that a room does, along with
+the match, before removing it again.
+*/
+func writePlayer(t *testing.T, serverState *models.ServerState, colour ctypes.PlayerColour, ground *maps.Map, physics *simulation.Physics) {
+	id := fmt.Sprintf("player-%d", colour)
+
+	player, err := ctypes.NewPlayer(colour, ctypes.NewPosition(float64(colour)*maps.TileSize, 0))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	player.DisplayName = colour.String()
+	if err := serverState.AddPlayer(id, *player); err != nil {
+		t.Error(err)
+		return
+	}
+
+	random := simulation.NewRandom(uint64(colour) + 1)
+	now := time.Now()
+
+	for sequence := uint64(1); sequence <= uint64(stressInputs()); sequence++ {
+		now = now.Add(simulation.TickDuration)
+		buttons := random.Intn(16)
+
+		input := ctypes.PlayerInput{
+			Sequence: sequence,
+			Tick:     sequence,
+			Left:     buttons&1 != 0,
+			Right:    buttons&2 != 0,
+			Jump:     buttons&4 != 0,
+			Crouch:   buttons&8 != 0,
+		}
+
+		serverState.SimulatePlayer(id, input, ground, physics)
+
+		switch sequence % 50 {
+		case 0:
+			serverState.SetReady(id, true)
+		case 10:
+			serverState.HurtPlayer(id, 1, "the stress test", now)
+		case 20:
+			serverState.ReachCheckpoint(id, ctypes.NewPosition(float64(random.Intn(20)*maps.TileSize), 9*maps.TileSize))
+		case 30:
+			pickup := ctypes.NewPosition(float64(random.Intn(20)*maps.TileSize), 8*maps.TileSize)
+			serverState.CollectPickup(id, tiles.Typeses.COIN_TILE, pickup, models.Pickup{Score: 1}, now)
+		case 40:
+			serverState.KillPlayer(id, "the stress test", now)
+		}
+
+		serverState.AdvanceMatch(now)
+		serverState.RespawnPickups(now)
+	}
+
+	serverState.RemovePlayer(id)
+}
+
+/*
+readSnapshots reads the server state until stop is closed, checking that versions only
+go forwards, and that a snapshot never changes after it has been read.
+*/
+func readSnapshots(t *testing.T, serverState *models.ServerState, stop <-chan struct{}) {
+	var last uint64
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		snapshot := serverState.Snapshot()
+		if snapshot.Version < last {
+			t.Errorf("version went backwards from %d to %d", last, snapshot.Version)
+		}
+		last = snapshot.Version
+
+		before := snapshot.Players()
+
+		// Changing the copy must not change the snapshot.
+		for id, player := range before {
+			player.Position.X = -1
+			before[id] = player
+		}
+
+		// Reading the rest of the state gives the writers time to publish newer versions,
+		// which mustn't change the snapshot that is already held.
+		_ = snapshot.Match().Info()
+		_ = serverState.String()
+
+		after := snapshot.Players()
+		if len(after) != snapshot.PlayerCount() {
+			t.Errorf("snapshot %d has %d players, but returned %d", snapshot.Version, snapshot.PlayerCount(), len(after))
+		}
+
+		for id, player := range after {
+			if player.Position.X == -1 {
+				t.Errorf("changing a copy of snapshot %d changed %s in the snapshot", snapshot.Version, id)
+			}
+
+			if latest, ok := snapshot.Player(id); !ok || latest != player {
+				t.Errorf("snapshot %d changed %s after it was published", snapshot.Version, id)
+			}
+		}
+	}
+}
+
+/*
+receiveEvents receives every event from a blocking subscription, checking that they
+arrive in the order of the versions that produced them. It returns the number of events
+received, once the subscription is closed.
+*/
+func receiveEvents(t *testing.T, subscription *models.Subscription) uint64 {
+	var last uint64
+	var received uint64
+
+	for event := range subscription.Events {
+		if version := event.Meta().Version; version < last {
+			t.Errorf("event for version %d arrived after version %d", version, last)
+		} else {
+			last = version
+		}
+
+		received++
+	}
+
+	return received
+}
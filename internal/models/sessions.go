@@ -0,0 +1,291 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/state"
+
+	"github.com/google/uuid"
+)
+
+// SessionPhase is how far a client has got through joining the game.
+type SessionPhase string
+
+const (
+	// SessionQueued is while the server is full, and the client is waiting for a slot.
+	SessionQueued SessionPhase = "queued"
+	// SessionConnecting is from when the client has a slot until it is ready.
+	SessionConnecting SessionPhase = "connecting"
+	// SessionPlaying is once the client is ready, and its player is in the server state.
+	SessionPlaying SessionPhase = "playing"
+)
+
+/*
+Session is everything the server knows about a single connected client. Sessions are
+handed out by value, so a Session is a copy of the client's session at the time.
+*/
+type Session struct {
+	ID uuid.UUID
+	// Slot is the client's place in the game, which decides its colour. Slots are reused
+	// once their session ends. Queued sessions have no slot, and a Slot of -1.
+	Slot   int
+	Colour ctypes.PlayerColour
+	// DisplayName is only set once the client is ready, and its name has been accepted.
+	DisplayName string
+	// ClientAddress is where the client sends from, which every packet in the session has
+	// to come from, see Touch. ReplyAddress is where the server sends to.
+	ClientAddress string
+	ReplyAddress  string
+	Conn          *state.UDPConnection
+	LastSeen      time.Time
+	Phase         SessionPhase
+}
+
+/*
+Sessions is the registry of every connected client. A session that isn't seen for longer
+than the idle timeout is evicted, e.g. when a client crashes without disconnecting, and
+its slot goes to the next client in the queue, or else the next client to connect.
+*/
+type Sessions struct {
+	mutex       sync.RWMutex
+	sessions    map[uuid.UUID]*Session
+	slots       []*Session
+	queue       []*Session
+	queueSize   int
+	admitted    []Session
+	idleTimeout time.Duration
+}
+
+/*
+NewSessions creates a registry with the given number of slots, i.e. the most players that
+can be in the game at once, and a queue of at most queueSize clients waiting for a slot.
+*/
+func NewSessions(slots, queueSize int, idleTimeout time.Duration) *Sessions {
+	return &Sessions{
+		sessions:    make(map[uuid.UUID]*Session),
+		slots:       make([]*Session, slots),
+		queueSize:   queueSize,
+		idleTimeout: idleTimeout,
+	}
+}
+
+/*
+Open starts a session in the lowest free slot, for a client that sends from clientAddress
+and is sent to through conn at replyAddress. If every slot is taken the session is
+queued instead, see Admitted, and if the queue is full too an error is returned.
+*/
+func (s *Sessions) Open(clientAddress, replyAddress string, conn *state.UDPConnection, now time.Time) (Session, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Session{}, errors.Join(errors.New("could not generate session ID"), err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session := &Session{
+		ID:            id,
+		Slot:          -1,
+		Colour:        ctypes.PlayerUnknown,
+		ClientAddress: clientAddress,
+		ReplyAddress:  replyAddress,
+		Conn:          conn,
+		LastSeen:      now,
+		Phase:         SessionQueued,
+	}
+
+	if slot := s.freeSlot(); slot >= 0 {
+		s.seat(session, slot)
+	} else if len(s.queue) < s.queueSize {
+		s.queue = append(s.queue, session)
+	} else {
+		return Session{}, errors.New("server is full")
+	}
+
+	s.sessions[id] = session
+
+	return *session, nil
+}
+
+/*
+Queued returns every queued session, in the order that they will be given slots. A
+session's position in the queue is its index plus one.
+*/
+func (s *Sessions) Queued() []Session {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	queued := make([]Session, len(s.queue))
+	for i, session := range s.queue {
+		queued[i] = *session
+	}
+
+	return queued
+}
+
+/*
+Admitted returns every session that has been moved from the queue into a free slot since
+the last call, so that their clients can be told.
+*/
+func (s *Sessions) Admitted() []Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	admitted := s.admitted
+	s.admitted = nil
+
+	return admitted
+}
+
+func (s *Sessions) Get(id uuid.UUID) (Session, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+
+	return *session, true
+}
+
+/*
+Touch records that the client was seen sending from clientAddress, and returns its
+session. Every player's ID is sent to every client, so an ID alone doesn't prove who sent
+a packet: it returns false if there is no such session, or if the packet didn't come from
+the address that the session was opened from.
+*/
+func (s *Sessions) Touch(id uuid.UUID, clientAddress string, now time.Time) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.ClientAddress != clientAddress {
+		return Session{}, false
+	}
+
+	session.LastSeen = now
+
+	return *session, true
+}
+
+/*
+MarkPlaying records that the client is ready and is playing under the given display
+name. It returns false if there is no such session.
+*/
+func (s *Sessions) MarkPlaying(id uuid.UUID, displayName string, now time.Time) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Phase == SessionQueued {
+		return Session{}, false
+	}
+
+	session.DisplayName = displayName
+	session.Phase = SessionPlaying
+	session.LastSeen = now
+
+	return *session, true
+}
+
+// Close ends the session and frees its slot, returning the session as it was.
+func (s *Sessions) Close(id uuid.UUID) (Session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+
+	s.remove(session)
+
+	return *session, true
+}
+
+// EvictIdle ends every session that hasn't been seen within the idle timeout.
+func (s *Sessions) EvictIdle(now time.Time) []Session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var evicted []Session
+
+	for _, session := range s.sessions {
+		if now.Sub(session.LastSeen) <= s.idleTimeout {
+			continue
+		}
+
+		s.remove(session)
+		evicted = append(evicted, *session)
+	}
+
+	return evicted
+}
+
+// Counts returns how many sessions have a slot, and how many are queued.
+func (s *Sessions) Counts() (seated, queued int) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.sessions) - len(s.queue), len(s.queue)
+}
+
+// Len returns how many sessions there are.
+func (s *Sessions) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.sessions)
+}
+
+/*
+remove ends the session, and gives its slot to the first session in the queue. It has to
+be called with the mutex held.
+*/
+func (s *Sessions) remove(session *Session) {
+	delete(s.sessions, session.ID)
+
+	if session.Phase == SessionQueued {
+		for i, queued := range s.queue {
+			if queued == session {
+				s.queue = append(s.queue[:i:i], s.queue[i+1:]...)
+				break
+			}
+		}
+
+		return
+	}
+
+	s.slots[session.Slot] = nil
+
+	if len(s.queue) > 0 {
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+
+		s.seat(next, session.Slot)
+		s.admitted = append(s.admitted, *next)
+	}
+}
+
+// freeSlot returns the lowest free slot, or -1 if every slot is taken.
+func (s *Sessions) freeSlot() int {
+	for slot, taken := range s.slots {
+		if taken == nil {
+			return slot
+		}
+	}
+
+	return -1
+}
+
+// seat gives the session the slot. It has to be called with the mutex held.
+func (s *Sessions) seat(session *Session, slot int) {
+	session.Slot = slot
+	session.Colour = ctypes.PlayerColourFromInt(slot)
+	session.Phase = SessionConnecting
+	s.slots[slot] = session
+}
@@ -0,0 +1,337 @@
+/*
+stress-server runs the server's UDP handler and default room in-process, connects several
+fake clients to it that send inputs as quickly as they can, and reads the room's server
+state from other goroutines at the same time. It fails if a reader ever sees a snapshot change after it
+was published, the version go backwards, or the events arrive out of order.
+
+It is meant to be run with the race detector, from the repository root:
+
+	go run -race ./internal/stress-server
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fyp/cmd/server/handlers"
+	"fyp/common/ctypes"
+	"fyp/common/ctypes/state"
+	"fyp/common/maps"
+	"fyp/common/simulation"
+	"fyp/common/utils/logging"
+	"fyp/internal/models"
+
+	typedsockets "fyp/common/utils/net/typed-sockets"
+)
+
+func printErrorAndExit(format string, v ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", v...)
+	os.Exit(1)
+}
+
+// How long clients wait before and after disconnecting, once they have stopped.
+const disconnectDelay = 250 * time.Millisecond
+
+// Long enough that no client is evicted for being idle while the stress test runs.
+const idleTimeout = 10 * time.Second
+
+// failures collects every problem found, from any goroutine.
+type failures struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (f *failures) add(format string, v ...any) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.messages = append(f.messages, fmt.Sprintf(format, v...))
+}
+
+/*
+runClient connects to the server as a client would, and then sends random inputs until
+stop is closed. It returns the number of inputs sent.
+*/
+func runClient(colour ctypes.PlayerColour, serverPort string, stop <-chan struct{}, problems *failures) uint64 {
+	conn, err := typedsockets.DialUDP[state.State]("127.0.0.1", serverPort)
+	if err != nil {
+		problems.add("%s could not connect to the server: %s", colour.String(), err.Error())
+		return 0
+	}
+	defer conn.Close()
+
+	socket, err := typedsockets.NewTypedUDPSocketListener[state.State]("0")
+	if err != nil {
+		problems.add("%s could not listen for the server: %s", colour.String(), err.Error())
+		return 0
+	}
+
+	rx, _ := socket.Conn()
+	defer rx.Close()
+
+	localAddress := rx.LocalAddr().String()
+	if _, err := conn.Write(state.WithClientUDPPort(localAddress[strings.LastIndex(localAddress, ":")+1:], "")); err != nil {
+		problems.add("%s could not send its port: %s", colour.String(), err.Error())
+		return 0
+	}
+
+	var initial state.State
+	if _, _, err := rx.ReadFrom(&initial); err != nil {
+		problems.add("%s did not get its initial state: %s", colour.String(), err.Error())
+		return 0
+	}
+
+	clientID := initial.Client.ID
+
+	player, err := ctypes.NewPlayer(colour, ctypes.NewPosition(0, 0))
+	if err != nil {
+		problems.add("%s could not create its player: %s", colour.String(), err.Error())
+		return 0
+	}
+
+	if _, err := conn.Write(state.WithClientReady(clientID.UUID, colour.String(), *player)); err != nil {
+		problems.add("%s could not send ready: %s", colour.String(), err.Error())
+		return 0
+	}
+
+	// Everything the server sends back is read and thrown away, so that the socket's
+	// buffer never fills up.
+	go func() {
+		var received state.State
+		for {
+			if _, _, err := rx.ReadFrom(&received); err != nil && strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+		}
+	}()
+
+	random := simulation.NewRandom(uint64(colour) + 1)
+	var sequence uint64
+
+	for {
+		select {
+		case <-stop:
+			// Give the server time to handle every input before disconnecting, and to
+			// handle the disconnection before the socket it sends to is closed.
+			time.Sleep(disconnectDelay)
+			conn.Write(state.WithClientDisconnecting(clientID))
+			time.Sleep(disconnectDelay)
+
+			return sequence
+		default:
+		}
+
+		sequence++
+		buttons := random.Intn(16)
+		input := ctypes.PlayerInput{
+			Sequence: sequence,
+			Tick:     sequence,
+			Left:     buttons&1 != 0,
+			Right:    buttons&2 != 0,
+			Jump:     buttons&4 != 0,
+			Crouch:   buttons&8 != 0,
+		}
+
+		if _, err := conn.Write(state.WithPlayerInputs(clientID, []ctypes.PlayerInput{input})); err != nil {
+			problems.add("%s could not send an input: %s", colour.String(), err.Error())
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+/*
+runReader reads the server state until stop is closed, checking that versions only go
+forwards, and that a snapshot never changes after it has been read. It returns the
+number of snapshots read.
+*/
+func runReader(serverState *models.ServerState, stop <-chan struct{}, problems *failures) uint64 {
+	var last uint64
+	var reads uint64
+
+	for {
+		select {
+		case <-stop:
+			return reads
+		default:
+		}
+
+		snapshot := serverState.Snapshot()
+		if snapshot.Version < last {
+			problems.add("version went backwards from %d to %d", last, snapshot.Version)
+		}
+		last = snapshot.Version
+
+		before := snapshot.Players()
+
+		// Changing the copy must not change the snapshot.
+		for name, player := range before {
+			player.Position.X = -1
+			before[name] = player
+		}
+
+		// Reading the rest of the state gives the writers time to publish newer versions,
+		// which mustn't change the snapshot that is already held.
+		serverState.Copy()
+		_ = serverState.String()
+
+		after := snapshot.Players()
+		if len(after) != snapshot.PlayerCount() {
+			problems.add("snapshot %d has %d players, but returned %d", snapshot.Version, snapshot.PlayerCount(), len(after))
+		}
+
+		for name, player := range after {
+			if player.Position.X == -1 {
+				problems.add("changing a copy of snapshot %d changed %s in the snapshot", snapshot.Version, name)
+			}
+
+			if latest, ok := snapshot.Player(name); !ok || latest != player {
+				problems.add("snapshot %d changed %s after it was published", snapshot.Version, name)
+			}
+		}
+
+		reads++
+	}
+}
+
+/*
+runSubscriber receives every event from a blocking subscription, checking that they
+arrive in the order of the versions that produced them. It returns the number of events
+received, once the subscription is closed.
+*/
+func runSubscriber(subscription *models.Subscription, problems *failures) uint64 {
+	var last uint64
+	var received uint64
+
+	for event := range subscription.Events {
+		if version := event.Meta().Version; version < last {
+			problems.add("event for version %d arrived after version %d", version, last)
+		} else {
+			last = version
+		}
+
+		received++
+	}
+
+	return received
+}
+
+func main() {
+	clients := flag.Int("clients", 4, "how many clients to connect")
+	readers := flag.Int("readers", 4, "how many goroutines read the server state")
+	duration := flag.Duration("duration", 5*time.Second, "how long to run for")
+	flag.Parse()
+
+	if *clients < 1 {
+		printErrorAndExit("-clients must be at least 1, got %d", *clients)
+	}
+
+	if _, present := os.LookupEnv("LOG_LEVEL"); !present {
+		os.Setenv("LOG_LEVEL", "warn")
+	}
+
+	logger := logging.NewServer()
+
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	socket, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		printErrorAndExit("could not start UDP socket: %s", err.Error())
+	}
+
+	port := socket.LocalAddr().(*net.UDPAddr).Port
+	closeChannel := make(chan any)
+
+	// Every client joins the default room, as none of them have a join ticket.
+	defaultRoom := models.RoomConfig{
+		Name:        "Stress test",
+		MaxPlayers:  *clients,
+		IdleTimeout: idleTimeout,
+		MapPath:     maps.DefaultPath,
+		Mode:        models.GameModeRace,
+		Pickups:     models.DefaultPickups(),
+	}
+
+	rooms, err := handlers.NewRoomManager(logger, simulation.DefaultPhysics(), state.NetcodeAuthoritative, defaultRoom, 1, time.Minute, closeChannel)
+	if err != nil {
+		printErrorAndExit("could not start the room: %s", err.Error())
+	}
+
+	serverState := rooms.Default().ServerState()
+	subscription := serverState.Events().Subscribe("stress-server", 64, models.Block)
+	eventsReceived := make(chan uint64)
+
+	udpHandler := handlers.NewUDPHandler(logger, rooms, socket, addr, port, closeChannel)
+
+	go udpHandler.Handle()
+	go rooms.Handle()
+
+	problems := &failures{}
+	stop := make(chan struct{})
+
+	go func() {
+		eventsReceived <- runSubscriber(subscription, problems)
+	}()
+
+	var group sync.WaitGroup
+	var inputs, reads atomic.Uint64
+
+	for i := 0; i < *clients; i++ {
+		group.Add(1)
+
+		go func(colour ctypes.PlayerColour) {
+			defer group.Done()
+			inputs.Add(runClient(colour, strconv.Itoa(port), stop, problems))
+		}(ctypes.PlayerColourFromInt(i))
+	}
+
+	for i := 0; i < *readers; i++ {
+		group.Add(1)
+
+		go func() {
+			defer group.Done()
+			reads.Add(runReader(serverState, stop, problems))
+		}()
+	}
+
+	time.Sleep(*duration)
+	close(stop)
+	group.Wait()
+
+	version := serverState.Version()
+	if count := serverState.Snapshot().PlayerCount(); count != 0 {
+		problems.add("%d players were left after every client disconnected", count)
+	}
+
+	close(closeChannel)
+
+	subscription.Close()
+	events := <-eventsReceived
+
+	// Every version produces at least one event, and a blocking subscription never
+	// drops any.
+	if events < version || subscription.Dropped() > 0 {
+		problems.add("received %d events for %d versions, and dropped %d", events, version, subscription.Dropped())
+	}
+
+	if version == 0 {
+		problems.add("the server state was never changed")
+	}
+
+	fmt.Printf("%d clients sent %d inputs, %d readers read %d snapshots, up to version %d, with %d events\n", *clients, inputs.Load(), *readers, reads.Load(), version, events)
+
+	if len(problems.messages) > 0 {
+		for _, message := range problems.messages {
+			fmt.Fprintln(os.Stderr, message)
+		}
+
+		os.Exit(1)
+	}
+}